@@ -0,0 +1,18 @@
+// Package sensors declares struct types from which destructive-gen generates the functions
+// in sensor_destructive.go, which its tests compare with its output and use to write and
+// read the structs.
+package sensors
+
+import "time"
+
+//go:generate go run ../.. -type Sensor
+
+type Sensor struct {
+	ID    uint64    `attr:"sys/db/id"`
+	Name  string    `attr:"sensor/name,identity"`
+	Reads int       `attr:"sensor/reads"`
+	Level *float64  `attr:"sensor/level"`
+	Label *string   `attr:"sensor/label,ignoreempty"`
+	Since time.Time `attr:"sensor/since"`
+	Notes string
+}
@@ -0,0 +1,59 @@
+// Code generated by destructive-gen; DO NOT EDIT.
+
+package sensors
+
+import (
+	"time"
+
+	"github.com/dball/destructive/pkg/database"
+)
+
+func init() {
+	database.RegisterGenerated(database.GeneratedStruct[Sensor]{
+		Attrs: []database.GeneratedAttr{
+			{Ident: "sensor/name", Type: "sys/attr/type/string", Unique: "sys/attr/unique/identity"},
+			{Ident: "sensor/reads", Type: "sys/attr/type/int"},
+			{Ident: "sensor/level", Type: "sys/attr/type/float"},
+			{Ident: "sensor/label", Type: "sys/attr/type/string"},
+			{Ident: "sensor/since", Type: "sys/attr/type/inst"},
+		},
+		Shred: func(x *Sensor, emit func(ident string, v any)) {
+			emit("sys/db/id", x.ID)
+			emit("sensor/name", x.Name)
+			emit("sensor/reads", int64(x.Reads))
+			if x.Level != nil {
+				emit("sensor/level", *x.Level)
+			}
+			if x.Label != nil && *x.Label != "" {
+				emit("sensor/label", *x.Label)
+			}
+			emit("sensor/since", x.Since)
+		},
+		Assemble: func(x *Sensor, ident string, v any) (ok bool) {
+			switch ident {
+			case "sys/db/id":
+				x.ID, ok = v.(uint64)
+			case "sensor/name":
+				x.Name, ok = v.(string)
+			case "sensor/reads":
+				var y int64
+				if y, ok = v.(int64); ok {
+					x.Reads = int(y)
+				}
+			case "sensor/level":
+				var y float64
+				if y, ok = v.(float64); ok {
+					x.Level = &y
+				}
+			case "sensor/label":
+				var y string
+				if y, ok = v.(string); ok {
+					x.Label = &y
+				}
+			case "sensor/since":
+				x.Since, ok = v.(time.Time)
+			}
+			return
+		},
+	})
+}
@@ -0,0 +1,28 @@
+package sensors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dball/destructive/pkg/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	db := database.NewDatabase(database.Config{})
+	level := 0.5
+	empty := ""
+	since := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	res := db.Write(database.Request{Assertions: []any{
+		Sensor{Name: "a", Reads: 3, Level: &level, Label: &empty, Since: since, Notes: "lost"},
+	}})
+	assert.NoError(t, res.Error)
+	id := res.IDs[0]
+
+	sensors, err := database.BuildTypedSnapshot[Sensor](res.Snap)
+	assert.NoError(t, err)
+	sensor := sensors.Find(id)
+	if assert.NotNil(t, sensor) {
+		assert.Equal(t, Sensor{ID: id, Name: "a", Reads: 3, Level: &level, Since: since}, *sensor)
+	}
+}
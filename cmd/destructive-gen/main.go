@@ -0,0 +1,340 @@
+// Destructive-gen generates the functions that record and load instances of struct types
+// without reflection, and registers them with the database in an init function.
+//
+// Usage:
+//
+//	destructive-gen -type Person,Pet [-output file] [dir]
+//
+// The struct types must be declared in the package in the given directory, which is the
+// current directory by default. Their attr fields must hold ids, strings, bools, ints,
+// int64s, float64s or time.Times, or pointers to those values, and may use the identity,
+// unique, ignoreempty, required and constraint directives, and their id fields the spec
+// and pred directives. The output is written to the file named for the first type, e.g.
+// person_destructive.go, by default.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/dball/destructive/internal/structs/models"
+)
+
+func main() {
+	typeNames := flag.String("type", "", "comma-separated list of struct type names; required")
+	output := flag.String("output", "", "output file name; default <dir>/<type>_destructive.go")
+	flag.Parse()
+	if *typeNames == "" || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := "."
+	if flag.NArg() == 1 {
+		dir = flag.Arg(0)
+	}
+	names := strings.Split(*typeNames, ",")
+	src, err := run(dir, names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destructive-gen: %v\n", err)
+		os.Exit(1)
+	}
+	path := *output
+	if path == "" {
+		path = filepath.Join(dir, strings.ToLower(names[0])+"_destructive.go")
+	}
+	err = os.WriteFile(path, src, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "destructive-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the package in the directory and generates the source for the named types.
+func run(dir string, names []string) (src []byte, err error) {
+	fset := token.NewFileSet()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return
+	}
+	var files []*ast.File
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") || strings.HasSuffix(path, "_destructive.go") {
+			continue
+		}
+		file, parseErr := parser.ParseFile(fset, path, nil, 0)
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		err = fmt.Errorf("no go files in %s", dir)
+		return
+	}
+	src, err = generate(files, names)
+	return
+}
+
+// scalar describes a supported field value type.
+type scalar struct {
+	// goType is the type of the field value.
+	goType string
+	// valueType is the type of the value given to and by the database.
+	valueType string
+	// attrType is the ident of the attribute type.
+	attrType string
+	// nonzero is the expression reporting whether a value is not its zero value, with %s
+	// for the value.
+	nonzero string
+}
+
+var scalars = map[string]scalar{
+	"string":    {"string", "string", "sys/attr/type/string", `%s != ""`},
+	"bool":      {"bool", "bool", "sys/attr/type/bool", "%s"},
+	"int":       {"int", "int64", "sys/attr/type/int", "%s != 0"},
+	"int64":     {"int64", "int64", "sys/attr/type/int", "%s != 0"},
+	"float64":   {"float64", "float64", "sys/attr/type/float", "%s != 0"},
+	"time.Time": {"time.Time", "time.Time", "sys/attr/type/inst", "!%s.IsZero()"},
+}
+
+// field is an attr field of a generated struct.
+type field struct {
+	name        string
+	ident       string
+	id          bool
+	pointer     bool
+	scalar      scalar
+	unique      string
+	ignoreEmpty bool
+}
+
+// structType is a generated struct type.
+type structType struct {
+	name   string
+	fields []field
+}
+
+// generate returns the formatted source registering the named struct types declared in
+// the files.
+func generate(files []*ast.File, names []string) (src []byte, err error) {
+	specs := map[string]*ast.TypeSpec{}
+	for _, file := range files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			if spec, ok := node.(*ast.TypeSpec); ok {
+				specs[spec.Name.Name] = spec
+			}
+			return true
+		})
+	}
+	structTypes := make([]structType, 0, len(names))
+	usesTime := false
+	for _, name := range names {
+		spec, ok := specs[name]
+		if !ok {
+			err = fmt.Errorf("type %s not found", name)
+			return
+		}
+		var st structType
+		st, err = parseStruct(spec)
+		if err != nil {
+			return
+		}
+		for _, f := range st.fields {
+			usesTime = usesTime || f.scalar.goType == "time.Time"
+		}
+		structTypes = append(structTypes, st)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by destructive-gen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", files[0].Name.Name)
+	fmt.Fprintf(&buf, "import (\n")
+	if usesTime {
+		fmt.Fprintf(&buf, "\t\"time\"\n\n")
+	}
+	fmt.Fprintf(&buf, "\t\"github.com/dball/destructive/pkg/database\"\n)\n\n")
+	fmt.Fprintf(&buf, "func init() {\n")
+	for _, st := range structTypes {
+		writeStruct(&buf, st)
+	}
+	fmt.Fprintf(&buf, "}\n")
+	src, err = format.Source(buf.Bytes())
+	return
+}
+
+// parseStruct models the struct type, rejecting fields that generated functions do not
+// support.
+func parseStruct(spec *ast.TypeSpec) (st structType, err error) {
+	st.name = spec.Name.Name
+	structExpr, ok := spec.Type.(*ast.StructType)
+	if !ok || spec.TypeParams != nil {
+		err = fmt.Errorf("type %s is not a struct type", st.name)
+		return
+	}
+	for _, astField := range structExpr.Fields.List {
+		if astField.Tag == nil {
+			continue
+		}
+		tagValue, _ := strconv.Unquote(astField.Tag.Value)
+		tag, ok := reflect.StructTag(tagValue).Lookup("attr")
+		if !ok {
+			continue
+		}
+		if len(astField.Names) != 1 {
+			err = fmt.Errorf("type %s has an attr field without a single name", st.name)
+			return
+		}
+		f := field{name: astField.Names[0].Name}
+		parts := models.SplitAttrTag(tag)
+		f.ident = parts[0]
+		for _, part := range parts[1:] {
+			switch part {
+			case "identity":
+				f.unique = "sys/attr/unique/identity"
+			case "unique":
+				f.unique = "sys/attr/unique/value"
+			case "ignoreempty":
+				f.ignoreEmpty = true
+			case "required":
+				// Specs are declared and ensured from the struct models.
+			default:
+				if f.ident == "sys/db/id" && (strings.HasPrefix(part, "spec=") || strings.HasPrefix(part, "pred=")) {
+					continue
+				}
+				// Constraints are declared from the struct models.
+				if f.ident != "sys/db/id" && constraintDirective(part) {
+					continue
+				}
+				err = fmt.Errorf("field %s.%s has unsupported directive %q", st.name, f.name, part)
+				return
+			}
+		}
+		typeExpr := astField.Type
+		if star, ok := typeExpr.(*ast.StarExpr); ok {
+			f.pointer = true
+			typeExpr = star.X
+		}
+		typeName := typeString(typeExpr)
+		if f.ident == "sys/db/id" {
+			if typeName != "uint64" || f.pointer {
+				err = fmt.Errorf("field %s.%s has unsupported id type", st.name, f.name)
+				return
+			}
+			f.id = true
+			st.fields = append(st.fields, f)
+			continue
+		}
+		f.scalar, ok = scalars[typeName]
+		if !ok {
+			err = fmt.Errorf("field %s.%s has unsupported type", st.name, f.name)
+			return
+		}
+		st.fields = append(st.fields, f)
+	}
+	return
+}
+
+// constraintDirective reports whether the directive constrains the values of a field.
+func constraintDirective(part string) bool {
+	name, _, ok := strings.Cut(part, "=")
+	if !ok {
+		return false
+	}
+	switch name {
+	case "min", "max", "minlen", "maxlen", "regex", "pred":
+		return true
+	}
+	return false
+}
+
+// typeString returns the name of a builtin type or time.Time, or the empty string.
+func typeString(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr.Name
+	case *ast.SelectorExpr:
+		if pkg, ok := expr.X.(*ast.Ident); ok {
+			return pkg.Name + "." + expr.Sel.Name
+		}
+	}
+	return ""
+}
+
+func writeStruct(buf *bytes.Buffer, st structType) {
+	fmt.Fprintf(buf, "database.RegisterGenerated(database.GeneratedStruct[%s]{\n", st.name)
+	fmt.Fprintf(buf, "Attrs: []database.GeneratedAttr{\n")
+	for _, f := range st.fields {
+		if f.id {
+			continue
+		}
+		fmt.Fprintf(buf, "{Ident: %q, Type: %q", f.ident, f.scalar.attrType)
+		if f.unique != "" {
+			fmt.Fprintf(buf, ", Unique: %q", f.unique)
+		}
+		fmt.Fprintf(buf, "},\n")
+	}
+	fmt.Fprintf(buf, "},\n")
+	fmt.Fprintf(buf, "Shred: func(x *%s, emit func(ident string, v any)) {\n", st.name)
+	for _, f := range st.fields {
+		access := "x." + f.name
+		if f.id {
+			fmt.Fprintf(buf, "emit(%q, %s)\n", f.ident, access)
+			continue
+		}
+		var conds []string
+		if f.pointer {
+			conds = append(conds, access+" != nil")
+			access = "*" + access
+		}
+		if f.ignoreEmpty {
+			conds = append(conds, fmt.Sprintf(f.scalar.nonzero, access))
+		}
+		value := access
+		if f.scalar.valueType != f.scalar.goType {
+			value = f.scalar.valueType + "(" + access + ")"
+		}
+		if len(conds) > 0 {
+			fmt.Fprintf(buf, "if %s {\nemit(%q, %s)\n}\n", strings.Join(conds, " && "), f.ident, value)
+		} else {
+			fmt.Fprintf(buf, "emit(%q, %s)\n", f.ident, value)
+		}
+	}
+	fmt.Fprintf(buf, "},\n")
+	fmt.Fprintf(buf, "Assemble: func(x *%s, ident string, v any) (ok bool) {\n", st.name)
+	fmt.Fprintf(buf, "switch ident {\n")
+	for _, f := range st.fields {
+		fmt.Fprintf(buf, "case %q:\n", f.ident)
+		switch {
+		case f.id:
+			fmt.Fprintf(buf, "x.%s, ok = v.(uint64)\n", f.name)
+		case f.pointer || f.scalar.valueType != f.scalar.goType:
+			fmt.Fprintf(buf, "var y %s\nif y, ok = v.(%s); ok {\n", f.scalar.valueType, f.scalar.valueType)
+			value := "y"
+			if f.scalar.valueType != f.scalar.goType {
+				value = f.scalar.goType + "(y)"
+			}
+			switch {
+			case f.pointer && value == "y":
+				fmt.Fprintf(buf, "x.%s = &y\n", f.name)
+			case f.pointer:
+				fmt.Fprintf(buf, "z := %s\nx.%s = &z\n", value, f.name)
+			default:
+				fmt.Fprintf(buf, "x.%s = %s\n", f.name, value)
+			}
+			fmt.Fprintf(buf, "}\n")
+		default:
+			fmt.Fprintf(buf, "x.%s, ok = v.(%s)\n", f.name, f.scalar.valueType)
+		}
+	}
+	fmt.Fprintf(buf, "}\nreturn\n},\n})\n")
+}
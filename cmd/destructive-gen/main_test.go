@@ -0,0 +1,86 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(t *testing.T, src string) []*ast.File {
+	file, err := parser.ParseFile(token.NewFileSet(), "types.go", src, 0)
+	assert.NoError(t, err)
+	return []*ast.File{file}
+}
+
+func TestGenerate(t *testing.T) {
+	files := parse(t, `package sensors
+
+import "time"
+
+type Sensor struct {
+	ID     uint64    `+"`attr:\"sys/db/id\"`"+`
+	Name   string    `+"`attr:\"sensor/name,identity\"`"+`
+	Reads  int       `+"`attr:\"sensor/reads\"`"+`
+	Level  *float64  `+"`attr:\"sensor/level\"`"+`
+	Label  *string   `+"`attr:\"sensor/label,ignoreempty\"`"+`
+	Since  time.Time `+"`attr:\"sensor/since\"`"+`
+	Code   string    `+"`attr:\"sensor/code,regex=^[0-9]{2\\\\,3}$\"`"+`
+	Notes  string
+}
+`)
+	src, err := generate(files, []string{"Sensor"})
+	assert.NoError(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "sensor_destructive.go", src, 0)
+	assert.NoError(t, err)
+	out := string(src)
+	assert.Contains(t, out, "package sensors")
+	assert.Contains(t, out, "\t\"time\"\n")
+	assert.Contains(t, out, `{Ident: "sensor/name", Type: "sys/attr/type/string", Unique: "sys/attr/unique/identity"},`)
+	assert.Contains(t, out, `emit("sys/db/id", x.ID)`)
+	assert.Contains(t, out, `emit("sensor/reads", int64(x.Reads))`)
+	assert.Contains(t, out, `if x.Label != nil && *x.Label != "" {`)
+	assert.Contains(t, out, `x.Level = &y`)
+	assert.Contains(t, out, `x.Since, ok = v.(time.Time)`)
+	assert.Contains(t, out, `emit("sensor/code", x.Code)`)
+	assert.NotContains(t, out, "Notes")
+}
+
+func TestGenerateGolden(t *testing.T) {
+	// The generated source of the sensors package is checked in, compiled, and exercised
+	// by its tests, so it must match the generator's output.
+	src, err := run("internal/sensors", []string{"Sensor"})
+	assert.NoError(t, err)
+	golden, err := os.ReadFile("internal/sensors/sensor_destructive.go")
+	assert.NoError(t, err)
+	assert.Equal(t, string(golden), string(src))
+}
+
+func TestGenerateRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+	}{
+		{"ref struct", "BFF *Sensor `attr:\"sensor/bff\"`"},
+		{"slice", "Tags []string `attr:\"sensor/tags,value=sensor/tag\"`"},
+		{"ref id", "Owner uint64 `attr:\"sensor/owner,ref\"`"},
+		{"named scalar", "Kind Kind `attr:\"sensor/kind\"`"},
+		{"id pointer", "ID *uint64 `attr:\"sys/db/id\"`"},
+		{"several names", "A, B string `attr:\"sensor/name\"`"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files := parse(t, "package sensors\n\ntype Kind string\n\ntype Sensor struct {\n"+test.field+"\n}\n")
+			_, err := generate(files, []string{"Sensor"})
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("missing type", func(t *testing.T) {
+		_, err := generate(parse(t, "package sensors\n"), []string{"Sensor"})
+		assert.Error(t, err)
+	})
+}
@@ -4,7 +4,11 @@ package database
 import (
 	"log"
 	"maps"
+	"regexp"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/dball/destructive/internal/index"
 	"github.com/dball/destructive/internal/sys"
@@ -15,6 +19,7 @@ type indexDatabase struct {
 	eav index.Index
 	aev index.Index
 	ave index.Index
+	vae index.Index
 
 	attrsByID      map[ID]Attr
 	attrsByIdent   map[Ident]Attr
@@ -22,15 +27,32 @@ type indexDatabase struct {
 	attrUniques    map[ID]ID
 	attrCardManies map[ID]Void
 	idents         map[Ident]ID
+	// aliasVersion identifies the idents and aliases of the attrs, and changes with them.
+	aliasVersion uint64
 
-	lock   sync.RWMutex
+	lock   sync.Mutex
 	logger log.Logger
 	nextID ID
 }
 
 var _ Database = (*indexDatabase)(nil)
 
+// aliasVersions allocates the alias versions of databases, which are distinct across
+// databases and their candidate snapshots, whether or not their transactions commit.
+var aliasVersions atomic.Uint64
+
 func NewIndexDatabase(degree int, attrsSize int, identsSize int) (db Database) {
+	return newIndexDatabase(degree, attrsSize, identsSize, sys.FirstUserID)
+}
+
+// NewIndexDatabaseAbove returns a new index database that allocates ids above the given
+// id, so that claims may refer to entities by the ids up to it though none were written,
+// e.g. to convert structs whose ids are given.
+func NewIndexDatabaseAbove(degree int, attrsSize int, identsSize int, id ID) (db Database) {
+	return newIndexDatabase(degree, attrsSize, identsSize, max(id+1, sys.FirstUserID))
+}
+
+func newIndexDatabase(degree int, attrsSize int, identsSize int, nextID ID) (db Database) {
 	attrsSize += len(sys.Attrs)
 	attrsByID := make(map[ID]Attr, attrsSize)
 	attrsByIdent := make(map[Ident]Attr, attrsSize)
@@ -53,6 +75,7 @@ func NewIndexDatabase(degree int, attrsSize int, identsSize int) (db Database) {
 	eav := index.NewCompositeIndex(degree, index.EAVIndex, attrTypes)
 	aev := index.NewCompositeIndex(degree, index.AEVIndex, attrTypes)
 	ave := index.NewCompositeIndex(degree, index.AVEIndex, attrTypes)
+	vae := index.NewCompositeIndex(degree, index.VAEIndex, attrTypes)
 	// Bootstrap the system datums by writing to the appropriate indexes directly.
 	for _, datum := range sys.Datums {
 		eav.Insert(datum)
@@ -61,26 +84,33 @@ func NewIndexDatabase(degree int, attrsSize int, identsSize int) (db Database) {
 		if ok {
 			ave.Insert(datum)
 		}
+		if attrTypes[datum.A] == sys.AttrTypeRef {
+			vae.Insert(datum)
+		}
 	}
 	db = &indexDatabase{
 		eav:            eav,
 		aev:            aev,
 		ave:            ave,
+		vae:            vae,
 		attrsByID:      attrsByID,
 		attrsByIdent:   attrsByIdent,
 		attrTypes:      attrTypes,
 		attrUniques:    attrUniques,
 		attrCardManies: attrCardManies,
 		idents:         idents,
-		nextID:         sys.FirstUserID,
+		nextID:         nextID,
 		logger:         *log.Default(),
 	}
 	return
 }
 
 func (db *indexDatabase) Read() (snapshot Snapshot) {
-	db.lock.RLock()
-	defer db.lock.RUnlock()
+	// Cloning a btree replaces the copy-on-write context of the original as well as giving
+	// the clone its own, so concurrent clones of the same btree race on the original's
+	// context. Reads clone the indexes, so they must be exclusive rather than shared.
+	db.lock.Lock()
+	defer db.lock.Unlock()
 	snapshot = db.read()
 	return
 }
@@ -92,13 +122,29 @@ func (db *indexDatabase) read() (snapshot Snapshot) {
 		eav: db.eav.Clone(),
 		aev: db.aev.Clone(),
 		ave: db.ave.Clone(),
+		vae: db.vae.Clone(),
 		// These are probably more expensive to copy than the btrees. Maybe we could do cow here?
-		idents: idents,
-		attrs:  attrs,
+		idents:       idents,
+		attrs:        attrs,
+		nextID:       db.nextID,
+		aliasVersion: db.aliasVersion,
 	}
 	return
 }
 
+// With applies the request to a database copied from the snapshot, which must be an
+// index snapshot, and returns its response. The copy is then discarded.
+func (db *indexDatabase) With(snapshot Snapshot, req Request) (res Response) {
+	base, ok := snapshot.(*indexSnapshot)
+	if !ok {
+		res.Error = NewError("database.with.invalidSnapshot")
+		res.Snapshot = snapshot
+		return
+	}
+	res = base.database().Write(req)
+	return
+}
+
 func (db *indexDatabase) Write(req Request) (res Response) {
 	rewrites := map[ID]ID{}
 	db.lock.Lock()
@@ -106,21 +152,41 @@ func (db *indexDatabase) Write(req Request) (res Response) {
 	attrChanges := map[ID]Attr{}
 	identCreates := map[ID]Ident{}
 	identDeletes := map[ID]Ident{}
+	// constrained are the attributes whose constraints the request changes.
+	var constrained []ID
+	// migrations are the extant attributes whose cardinality or uniqueness the request
+	// changes, as they will be.
+	migrations := map[ID]Attr{}
+	// renames are the extant attributes whose idents the request changes, by their new
+	// idents.
+	renames := map[ID]Ident{}
+	// aliases are the alias idents the request asserts, and retired those it retracts, by
+	// their attributes.
+	aliases := map[Ident]ID{}
+	retired := map[Ident]ID{}
+	for _, condition := range req.Conditions {
+		db.evaluateCondition(&res, condition)
+		if res.Error != nil {
+			res.Snapshot = db.read()
+			return
+		}
+	}
 	claims := req.Claims
-	if len(req.Retractions) != 0 {
-		ids := make(map[ID]Void, len(req.Retractions))
-		for _, retraction := range req.Retractions {
+	retractions := req.Retractions
+	if len(req.Computations) != 0 {
+		claims, retractions = db.compute(&res, req)
+		if res.Error != nil {
+			res.Snapshot = db.read()
+			return
+		}
+	}
+	if len(retractions) != 0 {
+		// The retracted attrs of each retracted entity, where nil indicates all of them.
+		ids := make(map[ID]map[ID]Void, len(retractions))
+		for _, retraction := range retractions {
 			var id ID
 			for ref := range retraction.Constraints {
-				var refID ID
-				switch e := ref.(type) {
-				case ID:
-					refID = e
-				case Ident:
-					refID = db.idents[e]
-				case LookupRef:
-					refID = db.resolveLookupRef(e)
-				}
+				refID := db.resolveIDRef(ref)
 				switch {
 				case refID == 0:
 					res.Error = NewError("database.write.invalidE", "retraction", retraction, "ref", ref)
@@ -132,26 +198,80 @@ func (db *indexDatabase) Write(req Request) (res Response) {
 					return
 				}
 			}
-			ids[id] = Void{}
+			attrs, ok := ids[id]
+			switch {
+			case len(retraction.Attrs) == 0:
+				ids[id] = nil
+			case ok && attrs == nil:
+				// The entity is already entirely retracted.
+			default:
+				if attrs == nil {
+					attrs = make(map[ID]Void, len(retraction.Attrs))
+					ids[id] = attrs
+				}
+				for ref := range retraction.Attrs {
+					a := db.resolveIDRef(ref)
+					if _, ok := db.attrsByID[a]; !ok {
+						res.Error = NewError("database.write.invalidA", "retraction", retraction, "a", ref)
+						return
+					}
+					attrs[a] = Void{}
+				}
+			}
 		}
-		for id := range ids {
+		// The stored datums are retracted before the claims are applied, so the claims may
+		// assert them anew.
+		var expanded []Claim
+		for id, attrs := range ids {
 			for datum := range db.eav.Select(index.E, Datum{E: id}) {
+				if attrs != nil {
+					if _, ok := attrs[datum.A]; !ok {
+						continue
+					}
+				}
 				// We could go straight to the indexes with the datums instead of allocating them anew as claims
 				// but we will need to handle invariant enforcement and cache maintenance differently.
-				claims = append(claims, Claim{E: datum.E, A: datum.A, V: datum.V.(VRef), Retract: true})
+				expanded = append(expanded, Claim{E: datum.E, A: datum.A, V: datum.V.(VRef), Retract: true})
 			}
 		}
+		claims = append(expanded, claims...)
 	}
 	lastID := db.nextID
 	res.ID = db.allocateID()
 	res.TempIDs = map[TempID]ID{}
 	data := make([]*Datum, 0, len(claims))
+	// reconstrained are the extant attrs whose constraints the request changes, whose values
+	// the request asserts are checked against the constraints as it leaves them.
+	reconstrained := map[ID]Void{}
+	for _, claim := range claims {
+		e, ok := claim.E.(IDRef)
+		if !ok || !sys.ConstraintAttr(db.resolveIDRef(claim.A)) {
+			continue
+		}
+		if id := db.resolveIDRef(e); id != 0 {
+			reconstrained[id] = Void{}
+		}
+	}
 CLAIMS:
 	for _, claim := range claims {
-		datum := db.evaluateClaim(&res, &claim)
+		datum := db.evaluateClaim(&res, &claim, reconstrained, req.ValuePredicates)
 		if res.Error != nil {
 			break
 		}
+		if datum.A == sys.DbIdent && !claim.Retract {
+			// Asserting the alias of an attr as the ident of a new entity, e.g. by redeclaring
+			// the attr by the ident it had before it was renamed, asserts the alias instead, so
+			// the tempid resolves to the attr.
+			ident := Ident(datum.V.(String))
+			attr, ok := db.attrsByID[db.idents[ident]]
+			if ok && attr.Ident != ident && attr.ID != datum.E {
+				if _, ok := claim.E.(TempID); !ok {
+					res.Error = NewError("database.write.identCollision", "datum", datum, "extant", attr.ID)
+					break
+				}
+				datum.A = sys.DbAlias
+			}
+		}
 		if !claim.Retract {
 			unique := db.attrUniques[datum.A]
 			if unique != 0 {
@@ -177,16 +297,26 @@ CLAIMS:
 		switch datum.A {
 		case sys.DbIdent:
 			ident := Ident(datum.V.(String))
-			// Attributes may not change their idents
+			// Attributes may be renamed, but their idents may not be retracted.
 			attr, ok := db.attrsByID[datum.E]
 			if ok {
 				switch {
 				case claim.Retract:
 					res.Error = NewError("database.write.attrIdentRetractDisallowed", "datum", datum)
 					break CLAIMS
-				case ident != attr.Ident:
-					res.Error = NewError("database.write.attrIdentChangeDisallowed", "datum", datum)
+				case ident == attr.Ident:
+				case !sys.ValidUserIdent(String(attr.Ident)):
+					res.Error = NewError("database.write.sysAttrChangeDisallowed", "attr", attr)
+					break CLAIMS
+				case !sys.ValidUserIdent(String(ident)):
+					res.Error = NewError("database.write.invalidUserIdent", "datum", datum)
 					break CLAIMS
+				default:
+					if id, ok := db.idents[ident]; ok && id != datum.E {
+						res.Error = NewError("database.write.identCollision", "datum", datum, "extant", id)
+						break CLAIMS
+					}
+					renames[datum.E] = ident
 				}
 			} else {
 				if !sys.ValidUserIdent(String(ident)) {
@@ -219,44 +349,116 @@ CLAIMS:
 			}
 		case sys.AttrCardinality:
 			card := datum.V.(ID)
-			if claim.Retract {
-				res.Error = NewError("database.write.attrRetractDisallowed", "datum", datum)
-				break CLAIMS
-			}
-			attr, ok := db.attrsByID[datum.E]
-			if ok {
-				if attr.Cardinality != card {
-					res.Error = NewError("database.write.attrCardinalityChangeDisallowed", "datum", datum)
+			_, ok := db.attrsByID[datum.E]
+			switch {
+			case ok:
+				db.migrate(&res, migrations, datum.E, func(attr *Attr) {
+					switch {
+					case !claim.Retract:
+						attr.Cardinality = card
+					case attr.Cardinality == card:
+						// Attributes without a cardinality have cardinality one.
+						attr.Cardinality = 0
+					}
+				})
+				if res.Error != nil {
 					break CLAIMS
 				}
-			} else {
-				attr = attrChanges[datum.E]
+			case claim.Retract:
+				res.Error = NewError("database.write.attrRetractDisallowed", "datum", datum)
+				break CLAIMS
+			default:
+				attr := attrChanges[datum.E]
 				attr.ID = datum.E
 				attr.Cardinality = card
 				attrChanges[datum.E] = attr
 			}
 		case sys.AttrUnique:
 			unique := datum.V.(ID)
-			if claim.Retract {
-				res.Error = NewError("database.write.attrRetractDisallowed", "datum", datum)
-				break CLAIMS
-			}
-			attr, ok := db.attrsByID[datum.E]
-			if ok {
-				if attr.Unique != unique {
-					res.Error = NewError("database.write.attrUniqueChangeDisallowed", "datum", datum)
+			_, ok := db.attrsByID[datum.E]
+			switch {
+			case ok:
+				db.migrate(&res, migrations, datum.E, func(attr *Attr) {
+					switch {
+					case !claim.Retract:
+						attr.Unique = unique
+					case attr.Unique == unique:
+						attr.Unique = 0
+					}
+				})
+				if res.Error != nil {
 					break CLAIMS
 				}
-			} else {
-				attr = attrChanges[datum.E]
+			case claim.Retract:
+				res.Error = NewError("database.write.attrRetractDisallowed", "datum", datum)
+				break CLAIMS
+			default:
+				attr := attrChanges[datum.E]
 				attr.ID = datum.E
 				attr.Unique = unique
 				attrChanges[datum.E] = attr
 			}
+		case sys.DbAlias:
+			alias := Ident(datum.V.(String))
+			e := datum.E
+			if id, ok := rewrites[e]; ok {
+				e = id
+			}
+			attr, ok := db.attrsByID[e]
+			switch {
+			case !ok:
+				res.Error = NewError("database.write.aliasRequiresAttr", "datum", datum)
+				break CLAIMS
+			case !sys.ValidUserIdent(String(attr.Ident)):
+				res.Error = NewError("database.write.sysAttrChangeDisallowed", "attr", attr)
+				break CLAIMS
+			case !sys.ValidUserIdent(String(alias)):
+				res.Error = NewError("database.write.invalidUserIdent", "datum", datum)
+				break CLAIMS
+			case claim.Retract:
+				if db.idents[alias] == e && alias != attr.Ident {
+					retired[alias] = e
+				}
+			case alias == attr.Ident:
+				res.Error = NewError("database.write.invalidAlias", "datum", datum)
+				break CLAIMS
+			default:
+				if id, ok := db.idents[alias]; ok && id != e {
+					res.Error = NewError("database.write.identCollision", "datum", datum, "extant", id)
+					break CLAIMS
+				}
+				aliases[alias] = e
+			}
+		default:
+			if sys.ConstraintAttr(datum.A) {
+				constrained = append(constrained, datum.E)
+			}
 		}
 		data = append(data, datum)
 	}
+	if res.Error == nil && len(renames) != 0 {
+		// The prior idents of renamed attrs remain as their aliases, and their new idents are
+		// no longer aliases.
+		claims = slices.Clip(claims)
+		for id, ident := range renames {
+			prior := String(db.attrsByID[id].Ident)
+			claims = append(claims, Claim{E: id, A: sys.DbAlias, V: prior})
+			data = append(data, &Datum{E: id, A: sys.DbAlias, V: prior, T: res.ID})
+			aliases[Ident(prior)] = id
+			if db.idents[ident] == id {
+				claims = append(claims, Claim{E: id, A: sys.DbAlias, V: String(ident), Retract: true})
+				data = append(data, &Datum{E: id, A: sys.DbAlias, V: String(ident), T: res.ID})
+				retired[ident] = id
+			}
+		}
+	}
+	if res.Error == nil {
+		db.rewriteSchemaChanges(&res, rewrites, attrChanges, identCreates)
+	}
 	for id, attr := range attrChanges {
+		if res.Error != nil {
+			break
+		}
 		ident, ok := identCreates[id]
 		if !ok {
 			res.Error = NewError("database.write.attrRequiresIdent", "attr", attr)
@@ -277,12 +479,28 @@ CLAIMS:
 			break
 		}
 	}
+	if res.Error == nil {
+		for i, id := range constrained {
+			if rewrite, ok := rewrites[id]; ok {
+				id = rewrite
+				constrained[i] = id
+			}
+			_, extant := db.attrsByID[id]
+			_, created := attrChanges[id]
+			if !extant && !created {
+				res.Error = NewError("database.write.invalidAttrConstraint", "attr", id)
+				break
+			}
+		}
+	}
 	// We now have datums with resolved or assigned ids and consistent avs.
+	var eav, aev, ave, vae index.Index
 	if res.Error == nil {
-		eav := db.eav.Clone()
-		aev := db.aev.Clone()
+		eav = db.eav.Clone()
+		aev = db.aev.Clone()
 		// Could defer this clone until we know we need it
-		ave := db.ave.Clone()
+		ave = db.ave.Clone()
+		vae = db.vae.Clone()
 		// We could consider transacting into the indexes concurrently.
 		for i, datum := range data {
 			claim := claims[i]
@@ -319,27 +537,116 @@ CLAIMS:
 							if ok {
 								ave.Delete(d)
 							}
+							if db.attrTypes[datum.A] == sys.AttrTypeRef {
+								vae.Delete(d)
+							}
+							d.T = res.ID
+							res.Retracted = append(res.Retracted, d)
 						}
 					}
 				}
-				eav.Insert(*datum)
+				if !eav.Insert(*datum) {
+					res.Asserted = append(res.Asserted, *datum)
+				}
 				aev.Insert(*datum)
 				_, ok = db.attrUniques[datum.A]
 				if ok {
 					ave.Insert(*datum)
 				}
+				if db.attrTypes[datum.A] == sys.AttrTypeRef {
+					vae.Insert(*datum)
+				}
 			} else {
-				eav.Delete(*datum)
+				if eav.Delete(*datum) {
+					res.Retracted = append(res.Retracted, *datum)
+				}
 				aev.Delete(*datum)
 				_, ok := db.attrUniques[datum.A]
 				if ok {
 					ave.Delete(*datum)
 				}
+				if db.attrTypes[datum.A] == sys.AttrTypeRef {
+					vae.Delete(*datum)
+				}
+			}
+		}
+		res.Asserted, res.Retracted = netChanges(res.Asserted, res.Retracted)
+		db.migrateAttrs(&res, migrations, attrChanges, aev, ave)
+		for id, ident := range renames {
+			attr, ok := attrChanges[id]
+			if !ok {
+				attr = db.attrsByID[id]
+			}
+			attr.Ident = ident
+			attrChanges[id] = attr
+		}
+		// The constraints of attributes apply to the values of the transactions that change
+		// them, as they leave them, and of subsequent transactions.
+		for _, id := range constrained {
+			if res.Error != nil {
+				break
+			}
+			attr, ok := attrChanges[id]
+			if !ok {
+				attr = db.attrsByID[id]
+			}
+			var err error
+			attr.Constraints, err = readConstraints(eav, id, attr.Type)
+			if err != nil {
+				res.Error = err
+				break
+			}
+			attrChanges[id] = attr
+		}
+		for i, datum := range data {
+			if res.Error != nil {
+				break
+			}
+			if _, ok := reconstrained[datum.A]; !ok || claims[i].Retract {
+				continue
+			}
+			attr, ok := attrChanges[datum.A]
+			if !ok {
+				attr = db.attrsByID[datum.A]
+			}
+			checkValue(&res, datum, attr, req.ValuePredicates)
+		}
+		if res.Error == nil && (len(res.Asserted) != 0 || len(res.Retracted) != 0) {
+			// The snapshot of the candidate indexes is built only if the specs or validators
+			// need it.
+			var after Snapshot
+			candidate := func() Snapshot {
+				if after == nil {
+					after = db.candidate(lastID, eav, aev, ave, vae, identCreates, identDeletes, attrChanges, aliases, retired)
+				}
+				return after
 			}
+			db.enforceSpecs(&res, req.Predicates, eav, candidate)
+			if res.Error == nil && len(req.Validators) != 0 {
+				db.validate(&res, req.Validators, lastID, candidate())
+			}
+		}
+	}
+	if res.Error == nil {
+		// The prior indexes are no longer changed, so they may be shared with the snapshot.
+		res.Before = &indexSnapshot{
+			eav:          db.eav,
+			aev:          db.aev,
+			ave:          db.ave,
+			vae:          db.vae,
+			idents:       maps.Clone(db.idents),
+			attrs:        maps.Clone(db.attrsByID),
+			nextID:       lastID,
+			aliasVersion: db.aliasVersion,
+		}
+		// Renamed attrs keep their prior idents as aliases.
+		if len(aliases) != 0 || len(retired) != 0 {
+			db.aliasVersion = aliasVersions.Add(1)
 		}
 		db.eav = eav
 		db.aev = aev
 		db.ave = ave
+		db.vae = vae
 		for _, ident := range identDeletes {
 			delete(db.idents, ident)
 		}
@@ -350,25 +657,182 @@ CLAIMS:
 			db.idents[attr.Ident] = id
 			db.attrsByID[id] = attr
 			db.attrsByIdent[attr.Ident] = attr
-			db.attrTypes[id] = attr.Type
+			// The indexes share the types, which do not change for extant attrs.
+			if db.attrTypes[id] != attr.Type {
+				db.attrTypes[id] = attr.Type
+			}
 			if attr.Cardinality == sys.AttrCardinalityMany {
 				db.attrCardManies[id] = Void{}
+			} else {
+				delete(db.attrCardManies, id)
 			}
 			if attr.Unique != 0 {
 				db.attrUniques[id] = attr.Unique
+			} else {
+				delete(db.attrUniques, id)
+			}
+			for datum := range eav.Select(index.EA, Datum{E: id, A: sys.DbAlias}) {
+				db.attrsByIdent[Ident(datum.V.(String))] = attr
+			}
+		}
+		for alias, id := range retired {
+			if db.attrsByID[id].Ident != alias {
+				delete(db.idents, alias)
+				delete(db.attrsByIdent, alias)
 			}
 		}
+		for alias, id := range aliases {
+			db.idents[alias] = id
+			db.attrsByIdent[alias] = db.attrsByID[id]
+		}
 	}
 	if res.Error != nil {
 		res.ID = 0
 		db.nextID = lastID
 		res.TempIDs = nil
+		res.Asserted = nil
+		res.Retracted = nil
 	}
 	res.Snapshot = db.read()
 	return
 }
 
-func (db *indexDatabase) evaluateClaim(res *Response, claim *Claim) (datum *Datum) {
+// rewriteSchemaChanges moves the ident and attr changes recorded for tempids that have
+// since resolved to extant entities through identity unique values onto those entities,
+// so that redeclaring an ident or attr refers to the extant entity rather than orphaning
+// it. Redeclared attrs must agree with the extant attrs, which change only through claims
+// on their ids or idents.
+func (db *indexDatabase) rewriteSchemaChanges(res *Response, rewrites map[ID]ID, attrChanges map[ID]Attr, identCreates map[ID]Ident) {
+	for id, ident := range maps.Clone(identCreates) {
+		extantID, ok := rewrites[id]
+		if !ok {
+			continue
+		}
+		delete(identCreates, id)
+		if db.idents[ident] != extantID {
+			identCreates[extantID] = ident
+		}
+	}
+	for id, attr := range maps.Clone(attrChanges) {
+		extantID, ok := rewrites[id]
+		if !ok {
+			continue
+		}
+		delete(attrChanges, id)
+		extant, ok := db.attrsByID[extantID]
+		if !ok {
+			// An extant ident is becoming an attr.
+			attr.ID = extantID
+			attrChanges[extantID] = attr
+			if _, ok := identCreates[extantID]; !ok {
+				d, ok := db.eav.First(index.EA, Datum{E: extantID, A: sys.DbIdent})
+				if ok {
+					identCreates[extantID] = Ident(d.V.(String))
+				}
+			}
+			continue
+		}
+		switch {
+		case attr.Type != 0 && attr.Type != extant.Type:
+			res.Error = NewError("database.write.attrTypeChangeDisallowed", "attr", attr, "extant", extant)
+			return
+		case attr.Cardinality != 0 && attr.Cardinality != extant.Cardinality:
+			res.Error = NewError("database.write.attrCardinalityChangeDisallowed", "attr", attr, "extant", extant)
+			return
+		case attr.Unique != 0 && attr.Unique != extant.Unique:
+			res.Error = NewError("database.write.attrUniqueChangeDisallowed", "attr", attr, "extant", extant)
+			return
+		}
+	}
+}
+
+// netChanges returns the datums the transaction asserted and retracted without those it
+// both asserted and retracted, such as the intermediate values of repeated changes of a
+// cardinality one attribute, which the database holds neither before nor after it.
+func netChanges(asserted, retracted []Datum) (netAsserted, netRetracted []Datum) {
+	counts := make(map[Datum]int, len(asserted)+len(retracted))
+	for _, datum := range asserted {
+		counts[datum]++
+	}
+	for _, datum := range retracted {
+		counts[datum]--
+	}
+	for _, datum := range asserted {
+		if counts[datum] > 0 {
+			counts[datum]--
+			netAsserted = append(netAsserted, datum)
+		}
+	}
+	for _, datum := range retracted {
+		if counts[datum] < 0 {
+			counts[datum]++
+			netRetracted = append(netRetracted, datum)
+		}
+	}
+	return
+}
+
+// migrate records the change of the cardinality or uniqueness of an extant attr, which
+// is applied to its datums once the request's datums are indexed. System attrs may not
+// be changed, though they may be redeclared as they are.
+func (db *indexDatabase) migrate(res *Response, migrations map[ID]Attr, id ID, change func(attr *Attr)) {
+	extant := db.attrsByID[id]
+	attr, ok := migrations[id]
+	if !ok {
+		attr = extant
+	}
+	change(&attr)
+	switch {
+	case attr == extant:
+		delete(migrations, id)
+	case !sys.ValidUserIdent(String(attr.Ident)):
+		res.Error = NewError("database.write.sysAttrChangeDisallowed", "attr", attr)
+	case attr.Cardinality != 0 && !sys.ValidAttrCardinality(attr.Cardinality):
+		res.Error = NewError("database.write.invalidAttrCardinality", "attr", attr)
+	case attr.Unique != 0 && !sys.ValidUnique(attr.Unique):
+		res.Error = NewError("database.write.invalidAttrUnique", "attr", attr)
+	default:
+		migrations[id] = attr
+	}
+}
+
+// migrateAttrs applies the migrations to the datums of their attrs in the candidate
+// indexes, rejecting the transaction if any entity has many values for an attr becoming
+// cardinality one, or any value is held by many entities for an attr becoming unique.
+// The migrated attrs are recorded as attr changes.
+func (db *indexDatabase) migrateAttrs(res *Response, migrations map[ID]Attr, attrChanges map[ID]Attr, aev, ave index.Index) {
+	for id, attr := range migrations {
+		extant := db.attrsByID[id]
+		if extant.Cardinality == sys.AttrCardinalityMany && attr.Cardinality != sys.AttrCardinalityMany {
+			var prior Datum
+			for datum := range aev.Select(index.A, Datum{A: id}) {
+				if datum.E == prior.E {
+					res.Error = NewError("database.write.attrCardinalityMigrationImpossible", "attr", attr, "datum", datum, "extant", prior)
+					return
+				}
+				prior = datum
+			}
+		}
+		switch {
+		case extant.Unique == 0 && attr.Unique != 0:
+			for datum := range aev.Select(index.A, Datum{A: id}) {
+				d, ok := ave.First(index.AV, datum)
+				if ok && d.E != datum.E {
+					res.Error = NewError("database.write.attrUniqueMigrationImpossible", "attr", attr, "datum", datum, "extant", d)
+					return
+				}
+				ave.Insert(datum)
+			}
+		case extant.Unique != 0 && attr.Unique == 0:
+			for datum := range aev.Select(index.A, Datum{A: id}) {
+				ave.Delete(datum)
+			}
+		}
+		attrChanges[id] = attr
+	}
+}
+
+func (db *indexDatabase) evaluateClaim(res *Response, claim *Claim, reconstrained map[ID]Void, predicates map[string]ValuePredicate) (datum *Datum) {
 	datum = &Datum{T: res.ID}
 	switch e := claim.E.(type) {
 	case ID:
@@ -457,6 +921,348 @@ func (db *indexDatabase) evaluateClaim(res *Response, claim *Claim) (datum *Datu
 	}
 	if !sys.ValidValue(db.attrTypes[datum.A], datum.V) {
 		res.Error = NewError("database.write.inconsistentAV", "datum", datum)
+		return
+	}
+	if _, ok := reconstrained[datum.A]; ok || claim.Retract {
+		return
+	}
+	checkValue(res, datum, db.attrsByID[datum.A], predicates)
+	return
+}
+
+// checkValue records an error in the response if the datum's value violates the
+// constraints of its attr.
+func checkValue(res *Response, datum *Datum, attr Attr, predicates map[string]ValuePredicate) {
+	if attr.Constraints == nil {
+		return
+	}
+	violated := sys.ConstrainedValue(attr.Constraints, datum.V)
+	if violated != 0 {
+		res.Error = NewError("database.write.constraintViolated", "e", datum.E, "a", attr.Ident, "v", datum.V, "constraint", sys.Attrs[violated].Ident)
+		return
+	}
+	for _, name := range attr.Constraints.Preds {
+		fn, ok := predicates[name]
+		if !ok {
+			res.Error = NewError("database.write.invalidValuePredicate", "e", datum.E, "a", attr.Ident, "v", datum.V, "pred", name)
+			return
+		}
+		err := fn(datum.V)
+		if err != nil {
+			res.Error = NewError("database.write.valuePredicateFailed", "e", datum.E, "a", attr.Ident, "v", datum.V, "pred", name, "error", err)
+			return
+		}
+	}
+}
+
+// readConstraints returns the constraints of the attribute of the type in the eav index,
+// or nil if it has none.
+func readConstraints(eav index.Index, a ID, typ ID) (constraints *Constraints, err error) {
+	var c Constraints
+	found := false
+	for datum := range eav.Select(index.E, Datum{E: a}) {
+		if !sys.ConstraintAttr(datum.A) {
+			continue
+		}
+		if !sys.ValidConstraint(datum.A, typ) {
+			err = NewError("database.write.invalidAttrConstraint", "attr", a, "constraint", sys.Attrs[datum.A].Ident)
+			return
+		}
+		found = true
+		switch datum.A {
+		case sys.AttrMin:
+			v := datum.V.(Float)
+			c.Min = &v
+		case sys.AttrMax:
+			v := datum.V.(Float)
+			c.Max = &v
+		case sys.AttrMinLength:
+			v := datum.V.(Int)
+			c.MinLength = &v
+		case sys.AttrMaxLength:
+			v := datum.V.(Int)
+			c.MaxLength = &v
+		case sys.AttrRegex:
+			c.Regex, err = regexp.Compile(string(datum.V.(String)))
+			if err != nil {
+				err = NewError("database.write.invalidAttrRegex", "attr", a, "error", err)
+				return
+			}
+		case sys.AttrMinInst:
+			v := datum.V.(Inst)
+			c.MinInst = &v
+		case sys.AttrMaxInst:
+			v := datum.V.(Inst)
+			c.MaxInst = &v
+		case sys.AttrPreds:
+			c.Preds = append(c.Preds, string(datum.V.(String)))
+		}
+	}
+	if found {
+		constraints = &c
+	}
+	return
+}
+
+// candidate returns a snapshot of the database as the indexes and cache changes of a
+// transaction would leave it.
+func (db *indexDatabase) candidate(lastID ID, eav, aev, ave, vae index.Index, identCreates map[ID]Ident, identDeletes map[ID]Ident, attrChanges map[ID]Attr, aliases map[Ident]ID, retired map[Ident]ID) (snapshot Snapshot) {
+	idents := maps.Clone(db.idents)
+	attrs := maps.Clone(db.attrsByID)
+	for _, ident := range identDeletes {
+		delete(idents, ident)
+	}
+	for id, ident := range identCreates {
+		idents[ident] = id
+	}
+	for id, attr := range attrChanges {
+		idents[attr.Ident] = id
+		attrs[id] = attr
+	}
+	for alias, id := range retired {
+		if attrs[id].Ident != alias {
+			delete(idents, alias)
+		}
+	}
+	for alias, id := range aliases {
+		idents[alias] = id
+	}
+	// The candidate indexes are shared with the database if the transaction is committed,
+	// so the snapshot has its own clones.
+	aliasVersion := db.aliasVersion
+	if len(aliases) != 0 || len(retired) != 0 {
+		aliasVersion = aliasVersions.Add(1)
+	}
+	snapshot = &indexSnapshot{
+		eav:          eav.Clone(),
+		aev:          aev.Clone(),
+		ave:          ave.Clone(),
+		vae:          vae.Clone(),
+		idents:       idents,
+		attrs:        attrs,
+		nextID:       db.nextID,
+		aliasVersion: aliasVersion,
+	}
+	return
+}
+
+// enforceSpecs rejects the transaction if any entity whose datums it changed does not
+// conform to the specs it ensures, given the candidate eav index. An entity conforms to a
+// spec if it has a value for each of the spec's required attributes, and satisfies its
+// predicates in the candidate snapshot.
+func (db *indexDatabase) enforceSpecs(res *Response, predicates map[string]Predicate, eav index.Index, candidate func() Snapshot) {
+	checked := map[ID]Void{}
+	for _, datums := range [][]Datum{res.Asserted, res.Retracted} {
+		for _, datum := range datums {
+			e := datum.E
+			if _, ok := checked[e]; ok {
+				continue
+			}
+			checked[e] = Void{}
+			for ensure := range eav.Select(index.EA, Datum{E: e, A: sys.SpecEnsure}) {
+				spec := ensure.V.(ID)
+				for required := range eav.Select(index.EA, Datum{E: spec, A: sys.SpecAttrs}) {
+					a := required.V.(ID)
+					if _, ok := eav.First(index.EA, Datum{E: e, A: a}); !ok {
+						res.Error = NewError("database.write.missingRequiredAttr", "e", e, "spec", db.specIdent(eav, spec), "attr", candidate().ResolveAttrIdent(a))
+						return
+					}
+				}
+				for pred := range eav.Select(index.EA, Datum{E: spec, A: sys.SpecPreds}) {
+					name := string(pred.V.(String))
+					fn, ok := predicates[name]
+					if !ok {
+						res.Error = NewError("database.write.invalidPredicate", "e", e, "spec", db.specIdent(eav, spec), "pred", name)
+						return
+					}
+					err := fn(candidate(), e)
+					if err != nil {
+						res.Error = NewError("database.write.predicateFailed", "e", e, "spec", db.specIdent(eav, spec), "pred", name, "error", err)
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// specIdent returns the ident of the spec in the eav index.
+func (db *indexDatabase) specIdent(eav index.Index, spec ID) (ident Ident) {
+	datum, ok := eav.First(index.EA, Datum{E: spec, A: sys.DbIdent})
+	if ok {
+		ident = Ident(datum.V.(String))
+	}
+	return
+}
+
+// validate gives the transaction to the validators in order, with the candidate snapshot
+// of the database, until one rejects it.
+func (db *indexDatabase) validate(res *Response, validators []Validator, lastID ID, after Snapshot) {
+	tx := Transaction{
+		ID: res.ID,
+		Before: &indexSnapshot{
+			eav:          db.eav.Clone(),
+			aev:          db.aev.Clone(),
+			ave:          db.ave.Clone(),
+			vae:          db.vae.Clone(),
+			idents:       maps.Clone(db.idents),
+			attrs:        maps.Clone(db.attrsByID),
+			nextID:       lastID,
+			aliasVersion: db.aliasVersion,
+		},
+		After:     after,
+		Asserted:  slices.Clone(res.Asserted),
+		Retracted: slices.Clone(res.Retracted),
+	}
+	for _, validator := range validators {
+		err := validator(tx)
+		if err != nil {
+			res.Error = err
+			return
+		}
+	}
+}
+
+// evaluateCondition records an error in the response if the condition does not hold in
+// the current state of the database.
+func (db *indexDatabase) evaluateCondition(res *Response, condition Condition) {
+	if ref, ok := condition.E.(LookupRef); ok && db.resolveLookupRef(ref) == 0 {
+		// The lookup ref refers to no entity yet, e.g. one the request creates, which has
+		// no values.
+		attr, ok := db.attrsByID[db.resolveIDRef(condition.A)]
+		switch {
+		case !ok:
+			res.Error = NewError("database.write.invalidA", "a", condition.A)
+		case condition.V != nil:
+			res.Error = NewError("database.write.conditionFailed",
+				"e", ID(0), "a", attr.Ident, "expected", condition.V, "actual", nil)
+		}
+		return
+	}
+	datum, attr, err := db.resolveValue(condition.E, condition.A)
+	switch {
+	case err != nil:
+		res.Error = err
+	case condition.V != nil && !sys.ValidValue(attr.Type, condition.V):
+		res.Error = NewError("database.write.invalidV", "condition", condition)
+	case datum.V != condition.V:
+		res.Error = NewError("database.write.conditionFailed",
+			"e", datum.E, "a", attr.Ident, "expected", condition.V, "actual", datum.V)
+	}
+}
+
+// compute evaluates the computations of the request against the current state of the
+// database, returning the request's claims and retractions with those they compute.
+func (db *indexDatabase) compute(res *Response, req Request) (claims []Claim, retractions []Retraction) {
+	claims = slices.Clone(req.Claims)
+	retractions = slices.Clone(req.Retractions)
+	computations := slices.Clone(req.Computations)
+	// increments are the incremented values by their entities and attributes.
+	increments := map[Datum]Int{}
+	var snapshot Snapshot
+	// Calls may compute further computations, which are appended as they are evaluated.
+	for i := 0; i < len(computations); i++ {
+		switch computation := computations[i].(type) {
+		case CAS:
+			datum, attr, err := db.resolveValue(computation.E, computation.A)
+			switch {
+			case err != nil:
+				res.Error = err
+			case computation.Old != nil && !sys.ValidValue(attr.Type, computation.Old):
+				res.Error = NewError("database.write.invalidV", "cas", computation)
+			case datum.V != computation.Old:
+				res.Error = NewError("database.write.casFailed",
+					"e", datum.E, "a", attr.Ident, "expected", computation.Old, "actual", datum.V)
+			}
+			if res.Error != nil {
+				return
+			}
+			claims = append(claims, Claim{E: datum.E, A: datum.A, V: computation.New})
+		case Increment:
+			datum, attr, err := db.resolveValue(computation.E, computation.A)
+			if err == nil && attr.Type != sys.AttrTypeInt {
+				err = NewError("database.write.invalidIncrementType", "increment", computation)
+			}
+			if err != nil {
+				res.Error = err
+				return
+			}
+			key := Datum{E: datum.E, A: datum.A}
+			v, ok := increments[key]
+			if !ok && datum.V != nil {
+				v = datum.V.(Int)
+			}
+			v += computation.Delta
+			increments[key] = v
+			claims = append(claims, Claim{E: datum.E, A: datum.A, V: v})
+		case Call:
+			if snapshot == nil {
+				snapshot = db.read()
+			}
+			callReq, err := computation.Fn(snapshot, computation.Args)
+			if err != nil {
+				res.Error = err
+				return
+			}
+			for _, condition := range callReq.Conditions {
+				db.evaluateCondition(res, condition)
+				if res.Error != nil {
+					return
+				}
+			}
+			prefix := "call/" + strconv.Itoa(i) + "/"
+			for _, claim := range callReq.Claims {
+				if tempID, ok := claim.E.(TempID); ok {
+					claim.E = TempID(prefix + string(tempID))
+				}
+				if tempID, ok := claim.V.(TempID); ok {
+					claim.V = TempID(prefix + string(tempID))
+				}
+				claims = append(claims, claim)
+			}
+			retractions = append(retractions, callReq.Retractions...)
+			computations = append(computations, callReq.Computations...)
+		default:
+			res.Error = NewError("database.write.invalidComputation", "computation", computation)
+			return
+		}
+	}
+	return
+}
+
+// resolveValue resolves the entity and cardinality one attribute, returning the datum
+// of the entity's current value for the attribute, if any, and the attribute.
+func (db *indexDatabase) resolveValue(e IDRef, a IDRef) (datum Datum, attr Attr, err error) {
+	datum.E = db.resolveIDRef(e)
+	if datum.E == 0 {
+		err = NewError("database.write.invalidE", "e", e)
+		return
+	}
+	datum.A = db.resolveIDRef(a)
+	attr, ok := db.attrsByID[datum.A]
+	if !ok {
+		err = NewError("database.write.invalidA", "a", a)
+		return
+	}
+	if _, ok := db.attrCardManies[datum.A]; ok {
+		err = NewError("database.write.invalidCardinality", "a", a)
+		return
+	}
+	if current, ok := db.eav.First(index.EA, datum); ok {
+		datum.V = current.V
+	}
+	return
+}
+
+// resolveIDRef returns the id of the entity to which the ref refers, or zero if none.
+func (db *indexDatabase) resolveIDRef(ref IDRef) (id ID) {
+	switch ref := ref.(type) {
+	case ID:
+		id = ref
+	case Ident:
+		id = db.idents[ref]
+	case LookupRef:
+		id = db.resolveLookupRef(ref)
 	}
 	return
 }
@@ -477,7 +1283,7 @@ func (db *indexDatabase) resolveLookupRef(ref LookupRef) (id ID) {
 	default:
 		return
 	}
-	if db.attrUniques[datum.A] == 0 {
+	if db.attrUniques[datum.A] == 0 || !sys.ValidValue(db.attrTypes[datum.A], datum.V) {
 		return
 	}
 	match, ok := db.ave.First(index.AV, datum)
@@ -1,7 +1,10 @@
 package database
 
 import (
+	"errors"
+	"regexp"
 	"slices"
+	"sync"
 	"testing"
 	"time"
 
@@ -10,6 +13,28 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// TestConcurrentReads confirms that concurrent reads, which clone the indexes, do not race
+// with each other or with writes.
+func TestConcurrentReads(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				snapshot := db.Read()
+				assert.True(t, snapshot.Has(Claim{E: sys.DbIdent, A: sys.DbIdent}))
+			}
+			if i == 0 {
+				res := db.Write(Request{Claims: []Claim{{E: TempID("1"), A: sys.DbIdent, V: String("test/ident")}}})
+				assert.NoError(t, res.Error)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func TestWriteSimple(t *testing.T) {
 	db := NewIndexDatabase(32, 64, 64)
 	var e, tx ID
@@ -184,6 +209,329 @@ func TestRetract(t *testing.T) {
 	assert.False(t, ok)
 }
 
+func TestRetractAttrs(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	assert.NoError(t, Declare(db,
+		Attr{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity},
+		Attr{Ident: "person/age", Type: sys.AttrTypeInt},
+		Attr{Ident: "person/aliases", Type: sys.AttrTypeString, Cardinality: sys.AttrCardinalityMany},
+	))
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("person/age"), V: Int(49)},
+			{E: TempID("1"), A: Ident("person/aliases"), V: String("Don")},
+			{E: TempID("1"), A: Ident("person/aliases"), V: String("Duck")},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	donald := LookupRef{A: Ident("person/name"), V: String("Donald")}
+
+	t.Run("attrs", func(t *testing.T) {
+		res := db.Write(Request{
+			Retractions: []Retraction{
+				{Constraints: map[IDRef]Void{donald: {}}, Attrs: map[IDRef]Void{Ident("person/aliases"): {}}},
+			},
+		})
+		assert.NoError(t, res.Error)
+		assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/age"), V: Int(49)}))
+		assert.False(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/aliases"), V: String("Don")}))
+		assert.False(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/aliases"), V: String("Duck")}))
+	})
+
+	t.Run("invalid attr", func(t *testing.T) {
+		res := db.Write(Request{
+			Retractions: []Retraction{
+				{Constraints: map[IDRef]Void{donald: {}}, Attrs: map[IDRef]Void{Ident("person/nope"): {}}},
+			},
+		})
+		assert.Error(t, res.Error)
+	})
+
+	t.Run("entity and attrs", func(t *testing.T) {
+		res := db.Write(Request{
+			Retractions: []Retraction{
+				{Constraints: map[IDRef]Void{donald: {}}},
+				{Constraints: map[IDRef]Void{donald: {}}, Attrs: map[IDRef]Void{Ident("person/age"): {}}},
+			},
+		})
+		assert.NoError(t, res.Error)
+		assert.False(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/age"), V: Int(49)}))
+		assert.False(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/name"), V: String("Donald")}))
+	})
+}
+
+func TestConditions(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	assert.NoError(t, Declare(db,
+		Attr{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity},
+		Attr{Ident: "person/version", Type: sys.AttrTypeInt},
+		Attr{Ident: "person/aliases", Type: sys.AttrTypeString, Cardinality: sys.AttrCardinalityMany},
+	))
+	res := db.Write(Request{Claims: []Claim{{E: TempID("1"), A: Ident("person/name"), V: String("Donald")}}})
+	assert.NoError(t, res.Error)
+	donald := LookupRef{A: Ident("person/name"), V: String("Donald")}
+
+	res = db.Write(Request{
+		Claims:     []Claim{{E: donald, A: Ident("person/version"), V: Int(1)}},
+		Conditions: []Condition{{E: donald, A: Ident("person/version")}},
+	})
+	assert.NoError(t, res.Error)
+	assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/version"), V: Int(1)}))
+
+	t.Run("conflict", func(t *testing.T) {
+		res := db.Write(Request{
+			Claims:     []Claim{{E: donald, A: Ident("person/version"), V: Int(1)}},
+			Conditions: []Condition{{E: donald, A: Ident("person/version")}},
+		})
+		if assert.Error(t, res.Error) {
+			err := res.Error.(Error)
+			assert.Equal(t, "database.write.conditionFailed", err.Code)
+			assert.Equal(t, Int(1), err.Context["actual"])
+		}
+		assert.Zero(t, res.ID)
+		res = db.Write(Request{
+			Claims:     []Claim{{E: donald, A: Ident("person/version"), V: Int(3)}},
+			Conditions: []Condition{{E: donald, A: Ident("person/version"), V: Int(2)}},
+		})
+		assert.Error(t, res.Error)
+		assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/version"), V: Int(1)}))
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		// A lookup ref to no entity refers to one without values.
+		stephen := LookupRef{A: Ident("person/name"), V: String("Stephen")}
+		res := db.Write(Request{Conditions: []Condition{{E: stephen, A: Ident("person/version"), V: Int(1)}}})
+		if assert.Error(t, res.Error) {
+			err := res.Error.(Error)
+			assert.Equal(t, "database.write.conditionFailed", err.Code)
+			assert.Nil(t, err.Context["actual"])
+		}
+		res = db.Write(Request{
+			Claims:     []Claim{{E: TempID("1"), A: Ident("person/name"), V: String("Stephen")}},
+			Conditions: []Condition{{E: stephen, A: Ident("person/version")}},
+		})
+		assert.NoError(t, res.Error)
+		res = db.Write(Request{Conditions: []Condition{{E: LookupRef{A: Ident("person/name"), V: String("Diane")}, A: Ident("nope")}}})
+		assert.Error(t, res.Error)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		res := db.Write(Request{Conditions: []Condition{{E: donald, A: Ident("person/aliases")}}})
+		assert.Error(t, res.Error)
+		res = db.Write(Request{Conditions: []Condition{{E: donald, A: Ident("person/version"), V: String("1")}}})
+		assert.Error(t, res.Error)
+		res = db.Write(Request{Conditions: []Condition{{E: Ident("nope"), A: Ident("person/version")}}})
+		assert.Error(t, res.Error)
+	})
+
+	res = db.Write(Request{
+		Claims:     []Claim{{E: donald, A: Ident("person/version"), V: Int(2)}},
+		Conditions: []Condition{{E: donald, A: Ident("person/version"), V: Int(1)}},
+	})
+	assert.NoError(t, res.Error)
+}
+
+func TestComputations(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	assert.NoError(t, Declare(db,
+		Attr{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity},
+		Attr{Ident: "person/status", Type: sys.AttrTypeString},
+		Attr{Ident: "person/visits", Type: sys.AttrTypeInt},
+		Attr{Ident: "person/friend", Type: sys.AttrTypeRef},
+	))
+	res := db.Write(Request{Claims: []Claim{{E: TempID("1"), A: Ident("person/name"), V: String("Donald")}}})
+	assert.NoError(t, res.Error)
+	donald := LookupRef{A: Ident("person/name"), V: String("Donald")}
+
+	t.Run("cas", func(t *testing.T) {
+		res := db.Write(Request{Computations: []Computation{
+			CAS{E: donald, A: Ident("person/status"), New: String("active")},
+		}})
+		assert.NoError(t, res.Error)
+		assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/status"), V: String("active")}))
+		res = db.Write(Request{Computations: []Computation{
+			CAS{E: donald, A: Ident("person/status"), Old: String("inactive"), New: String("retired")},
+		}})
+		if assert.Error(t, res.Error) {
+			assert.Equal(t, "database.write.casFailed", res.Error.(Error).Code)
+		}
+		res = db.Write(Request{Computations: []Computation{
+			CAS{E: donald, A: Ident("person/status"), Old: String("active"), New: String("retired")},
+		}})
+		assert.NoError(t, res.Error)
+		assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/status"), V: String("retired")}))
+	})
+
+	t.Run("increment", func(t *testing.T) {
+		res := db.Write(Request{Computations: []Computation{
+			Increment{E: donald, A: Ident("person/visits"), Delta: 2},
+			Increment{E: donald, A: Ident("person/visits"), Delta: 3},
+		}})
+		assert.NoError(t, res.Error)
+		assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/visits"), V: Int(5)}))
+		res = db.Write(Request{Computations: []Computation{
+			Increment{E: donald, A: Ident("person/visits"), Delta: -1},
+		}})
+		assert.NoError(t, res.Error)
+		assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/visits"), V: Int(4)}))
+		// The intermediate values are neither asserted nor retracted.
+		res = db.Write(Request{Computations: []Computation{
+			Increment{E: donald, A: Ident("person/visits"), Delta: 1},
+			Increment{E: donald, A: Ident("person/visits"), Delta: -1},
+			Increment{E: donald, A: Ident("person/visits"), Delta: 2},
+		}})
+		assert.NoError(t, res.Error)
+		if assert.Len(t, res.Asserted, 1) && assert.Len(t, res.Retracted, 1) {
+			assert.Equal(t, Int(6), res.Asserted[0].V)
+			assert.Equal(t, Int(4), res.Retracted[0].V)
+		}
+		res = db.Write(Request{Computations: []Computation{
+			Increment{E: donald, A: Ident("person/visits"), Delta: -2},
+		}})
+		assert.NoError(t, res.Error)
+		res = db.Write(Request{Computations: []Computation{
+			Increment{E: donald, A: Ident("person/status"), Delta: 1},
+		}})
+		assert.Error(t, res.Error)
+	})
+
+	t.Run("call", func(t *testing.T) {
+		// befriend asserts a new friend of the named person, who is visited.
+		befriend := func(snapshot Snapshot, args []any) (req Request, err error) {
+			person := LookupRef{A: Ident("person/name"), V: String(args[0].(string))}
+			if snapshot.Count(Claim{E: person, A: Ident("person/friend")}) > 0 {
+				err = errors.New("already befriended")
+				return
+			}
+			req.Claims = []Claim{
+				{E: TempID("1"), A: Ident("person/name"), V: String(args[1].(string))},
+				{E: person, A: Ident("person/friend"), V: TempID("1")},
+			}
+			req.Computations = []Computation{Increment{E: person, A: Ident("person/visits"), Delta: 1}}
+			return
+		}
+		res := db.Write(Request{
+			Claims:       []Claim{{E: TempID("1"), A: Ident("person/name"), V: String("Stephen")}},
+			Computations: []Computation{Call{Fn: befriend, Args: []any{"Donald", "Mickey"}}},
+		})
+		assert.NoError(t, res.Error)
+		mickey := LookupRef{A: Ident("person/name"), V: String("Mickey")}
+		stephen := LookupRef{A: Ident("person/name"), V: String("Stephen")}
+		assert.True(t, res.Snapshot.Has(Claim{E: stephen, A: Ident("person/name"), V: String("Stephen")}))
+		assert.Equal(t, 1, res.Snapshot.Count(Claim{E: donald, A: Ident("person/friend")}))
+		for datum := range res.Snapshot.Select(Claim{E: donald, A: Ident("person/friend")}) {
+			assert.True(t, res.Snapshot.Has(Claim{E: datum.V.(ID), A: Ident("person/name"), V: String("Mickey")}))
+		}
+		assert.True(t, res.Snapshot.Has(Claim{E: mickey, A: Ident("person/name"), V: String("Mickey")}))
+		assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/visits"), V: Int(5)}))
+		res = db.Write(Request{Computations: []Computation{Call{Fn: befriend, Args: []any{"Donald", "Goofy"}}}})
+		assert.EqualError(t, res.Error, "already befriended")
+	})
+}
+
+func TestWith(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	assert.NoError(t, Declare(db,
+		Attr{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity},
+		Attr{Ident: "person/age", Type: sys.AttrTypeInt},
+	))
+	res := db.Write(Request{Claims: []Claim{
+		{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+		{E: TempID("1"), A: Ident("person/age"), V: Int(48)},
+	}})
+	assert.NoError(t, res.Error)
+	donald := LookupRef{A: Ident("person/name"), V: String("Donald")}
+	snapshot := res.Snapshot
+
+	res = db.With(snapshot, Request{Claims: []Claim{
+		{E: TempID("1"), A: Ident("sys/db/ident"), V: String("person/nickname")},
+		{E: TempID("1"), A: Ident("sys/attr/type"), V: Ident("sys/attr/type/string")},
+	}})
+	assert.NoError(t, res.Error)
+	speculative := res.Snapshot
+	res = db.With(speculative, Request{Claims: []Claim{
+		{E: donald, A: Ident("person/age"), V: Int(49)},
+		{E: donald, A: Ident("person/nickname"), V: String("Don")},
+		{E: TempID("1"), A: Ident("person/name"), V: String("Stephen")},
+	}})
+	assert.NoError(t, res.Error)
+	assert.NotZero(t, res.TempIDs[TempID("1")])
+	assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/age"), V: Int(49)}))
+	assert.True(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/nickname"), V: String("Don")}))
+	assert.False(t, res.Snapshot.Has(Claim{E: donald, A: Ident("person/age"), V: Int(48)}))
+
+	// The database and the snapshots are left as they were.
+	for _, snapshot := range []Snapshot{snapshot, speculative, db.Read()} {
+		assert.True(t, snapshot.Has(Claim{E: donald, A: Ident("person/age"), V: Int(48)}))
+		assert.False(t, snapshot.Has(Claim{E: LookupRef{A: Ident("person/name"), V: String("Stephen")}, A: Ident("person/name"), V: String("Stephen")}))
+	}
+	assert.Zero(t, db.Read().ResolveIdent(Ident("person/nickname")))
+	res = db.Write(Request{Claims: []Claim{{E: donald, A: Ident("person/nickname"), V: String("Don")}}})
+	assert.Error(t, res.Error)
+
+	res = db.With(snapshot, Request{Claims: []Claim{{E: donald, A: Ident("person/age"), V: String("old")}}})
+	assert.Error(t, res.Error)
+}
+
+func TestResponseDeltas(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	assert.NoError(t, Declare(db,
+		Attr{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity},
+		Attr{Ident: "person/age", Type: sys.AttrTypeInt},
+		Attr{Ident: "person/aliases", Type: sys.AttrTypeString, Cardinality: sys.AttrCardinalityMany},
+	))
+	name := db.Read().ResolveIdent(Ident("person/name"))
+	age := db.Read().ResolveIdent(Ident("person/age"))
+	aliases := db.Read().ResolveIdent(Ident("person/aliases"))
+	res := db.Write(Request{Claims: []Claim{
+		{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+		{E: TempID("1"), A: Ident("person/age"), V: Int(48)},
+		{E: TempID("1"), A: Ident("person/aliases"), V: String("Don")},
+	}})
+	assert.NoError(t, res.Error)
+	e, t1 := res.TempIDs[TempID("1")], res.ID
+	assert.Equal(t, []Datum{
+		{E: e, A: name, V: String("Donald"), T: t1},
+		{E: e, A: age, V: Int(48), T: t1},
+		{E: e, A: aliases, V: String("Don"), T: t1},
+	}, res.Asserted)
+	assert.Empty(t, res.Retracted)
+	assert.False(t, res.Before.Has(Claim{E: e, A: name}))
+
+	res = db.Write(Request{Claims: []Claim{
+		{E: e, A: Ident("person/name"), V: String("Donald")},
+		{E: e, A: Ident("person/age"), V: Int(49)},
+		{E: e, A: Ident("person/aliases"), V: String("Don")},
+		{E: e, A: Ident("person/aliases"), V: String("Duck")},
+	}})
+	assert.NoError(t, res.Error)
+	t2 := res.ID
+	assert.Equal(t, []Datum{
+		{E: e, A: age, V: Int(49), T: t2},
+		{E: e, A: aliases, V: String("Duck"), T: t2},
+	}, res.Asserted)
+	assert.Equal(t, []Datum{{E: e, A: age, V: Int(48), T: t2}}, res.Retracted)
+	assert.True(t, res.Before.Has(Claim{E: e, A: age, V: Int(48)}))
+	assert.True(t, res.Snapshot.Has(Claim{E: e, A: age, V: Int(49)}))
+
+	res = db.Write(Request{Retractions: []Retraction{
+		{Constraints: map[IDRef]Void{e: {}}, Attrs: map[IDRef]Void{Ident("person/aliases"): {}}},
+	}})
+	assert.NoError(t, res.Error)
+	assert.Empty(t, res.Asserted)
+	assert.ElementsMatch(t, []Datum{
+		{E: e, A: aliases, V: String("Don"), T: res.ID},
+		{E: e, A: aliases, V: String("Duck"), T: res.ID},
+	}, res.Retracted)
+
+	res = db.Write(Request{Claims: []Claim{{E: e, A: Ident("person/age"), V: String("old")}}})
+	assert.Error(t, res.Error)
+	assert.Nil(t, res.Before)
+	assert.Empty(t, res.Asserted)
+}
+
 func TestBool(t *testing.T) {
 	db := NewIndexDatabase(32, 64, 64)
 	assert.NoError(t, Declare(db,
@@ -248,3 +596,243 @@ func TestInt(t *testing.T) {
 		{E: id, A: view.ResolveIdent(Ident("person/age")), V: Int(-49), T: tx},
 	}, data)
 }
+
+func TestValidators(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	assert.NoError(t, Declare(db,
+		Attr{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity},
+		Attr{Ident: "person/age", Type: sys.AttrTypeInt},
+	))
+	donald := LookupRef{A: Ident("person/name"), V: String("Donald")}
+	// Rejects transactions that leave the total age above a limit.
+	errTooOld := errors.New("too old")
+	var txs []Transaction
+	validator := func(tx Transaction) (err error) {
+		txs = append(txs, tx)
+		var total Int
+		for datum := range tx.After.Select(Claim{A: Ident("person/age")}) {
+			total += datum.V.(Int)
+		}
+		if total > 100 {
+			err = errTooOld
+		}
+		return
+	}
+
+	res := db.Write(Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("person/age"), V: Int(48)},
+		},
+		Validators: []Validator{validator},
+	})
+	assert.NoError(t, res.Error)
+	assert.Len(t, txs, 1)
+	assert.Equal(t, res.ID, txs[0].ID)
+	assert.Equal(t, res.Asserted, txs[0].Asserted)
+	assert.False(t, txs[0].Before.Has(Claim{E: donald, A: Ident("person/age"), V: Int(48)}))
+	assert.True(t, txs[0].After.Has(Claim{E: donald, A: Ident("person/age"), V: Int(48)}))
+
+	res = db.Write(Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Stephen")},
+			{E: TempID("1"), A: Ident("person/age"), V: Int(53)},
+		},
+		Validators: []Validator{validator},
+	})
+	assert.ErrorIs(t, res.Error, errTooOld)
+	assert.Zero(t, res.ID)
+	assert.Empty(t, res.Asserted)
+	assert.False(t, res.Snapshot.Has(Claim{A: Ident("person/name"), V: String("Stephen")}))
+
+	// Transactions that change nothing are not validated.
+	res = db.Write(Request{
+		Claims:     []Claim{{E: donald, A: Ident("person/age"), V: Int(48)}},
+		Validators: []Validator{validator},
+	})
+	assert.NoError(t, res.Error)
+	assert.Len(t, txs, 2)
+
+	// Speculative writes are validated.
+	res = db.With(db.Read(), Request{
+		Claims:     []Claim{{E: donald, A: Ident("person/age"), V: Int(101)}},
+		Validators: []Validator{validator},
+	})
+	assert.ErrorIs(t, res.Error, errTooOld)
+}
+
+func TestSpecs(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	assert.NoError(t, Declare(db,
+		Attr{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity},
+		Attr{Ident: "person/age", Type: sys.AttrTypeInt},
+	))
+	res := db.Write(Request{Claims: []Claim{
+		{E: TempID("1"), A: Ident("sys/db/ident"), V: String("person/spec")},
+		{E: TempID("1"), A: Ident("sys/spec/attrs"), V: Ident("person/name")},
+		{E: TempID("1"), A: Ident("sys/spec/attrs"), V: Ident("person/age")},
+		{E: TempID("1"), A: Ident("sys/spec/preds"), V: String("adult")},
+	}})
+	assert.NoError(t, res.Error)
+	errMinor := errors.New("minor")
+	predicates := map[string]Predicate{
+		"adult": func(snapshot Snapshot, e ID) (err error) {
+			for datum := range snapshot.Select(Claim{E: e, A: Ident("person/age")}) {
+				if datum.V.(Int) < 18 {
+					err = errMinor
+				}
+			}
+			return
+		},
+	}
+
+	res = db.Write(Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("sys/spec/ensure"), V: Ident("person/spec")},
+		},
+		Predicates: predicates,
+	})
+	assert.Error(t, res.Error)
+	assert.Equal(t, "database.write.missingRequiredAttr", res.Error.(Error).Code)
+	assert.Equal(t, Ident("person/age"), res.Error.(Error).Context["attr"])
+	assert.Equal(t, Ident("person/spec"), res.Error.(Error).Context["spec"])
+
+	res = db.Write(Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("person/age"), V: Int(12)},
+			{E: TempID("1"), A: Ident("sys/spec/ensure"), V: Ident("person/spec")},
+		},
+		Predicates: predicates,
+	})
+	assert.Error(t, res.Error)
+	assert.Equal(t, "database.write.predicateFailed", res.Error.(Error).Code)
+	assert.Equal(t, errMinor, res.Error.(Error).Context["error"])
+
+	res = db.Write(Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("person/age"), V: Int(48)},
+			{E: TempID("1"), A: Ident("sys/spec/ensure"), V: Ident("person/spec")},
+		},
+	})
+	assert.Error(t, res.Error)
+	assert.Equal(t, "database.write.invalidPredicate", res.Error.(Error).Code)
+
+	res = db.Write(Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("person/age"), V: Int(48)},
+			{E: TempID("1"), A: Ident("sys/spec/ensure"), V: Ident("person/spec")},
+		},
+		Predicates: predicates,
+	})
+	assert.NoError(t, res.Error)
+	donald := LookupRef{A: Ident("person/name"), V: String("Donald")}
+
+	// The entity conforms to the spec after subsequent transactions.
+	res = db.Write(Request{
+		Retractions: []Retraction{{
+			Constraints: map[IDRef]Void{donald: {}},
+			Attrs:       map[IDRef]Void{Ident("person/age"): {}},
+		}},
+		Predicates: predicates,
+	})
+	assert.Error(t, res.Error)
+	assert.Equal(t, "database.write.missingRequiredAttr", res.Error.(Error).Code)
+	res = db.Write(Request{
+		Retractions: []Retraction{{Constraints: map[IDRef]Void{donald: {}}}},
+		Predicates:  predicates,
+	})
+	assert.NoError(t, res.Error)
+}
+
+func TestConstraints(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	zero := Float(0)
+	oldest := Float(150)
+	one := Int(1)
+	epoch := Inst(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, Declare(db,
+		Attr{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity, Constraints: &Constraints{
+			MinLength: &one,
+			Regex:     regexp.MustCompile("^[A-Z]"),
+			Preds:     []string{"polite"},
+		}},
+		Attr{Ident: "person/age", Type: sys.AttrTypeInt, Constraints: &Constraints{Min: &zero, Max: &oldest}},
+		Attr{Ident: "person/born", Type: sys.AttrTypeInst, Constraints: &Constraints{MinInst: &epoch}},
+	))
+	errRude := errors.New("rude")
+	predicates := map[string]ValuePredicate{
+		"polite": func(value Value) (err error) {
+			if value == String("Grumpy") {
+				err = errRude
+			}
+			return
+		},
+	}
+	write := func(a string, v Value) (res Response) {
+		return db.Write(Request{
+			Claims: []Claim{
+				{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+				{E: TempID("1"), A: Ident(a), V: v.(VRef)},
+			},
+			ValuePredicates: predicates,
+		})
+	}
+	code := func(res Response) string {
+		if err, ok := res.Error.(Error); ok {
+			return err.Code + " " + string(err.Context["constraint"].(Ident))
+		}
+		return ""
+	}
+
+	assert.NoError(t, write("person/age", Int(48)).Error)
+	assert.Equal(t, "database.write.constraintViolated sys/attr/min", code(write("person/age", Int(-1))))
+	assert.Equal(t, "database.write.constraintViolated sys/attr/max", code(write("person/age", Int(151))))
+	assert.Equal(t, "database.write.constraintViolated sys/attr/length/min", code(write("person/name", String(""))))
+	assert.Equal(t, "database.write.constraintViolated sys/attr/regex", code(write("person/name", String("donald"))))
+	assert.Equal(t, "database.write.constraintViolated sys/attr/inst/min", code(write("person/born", Inst(time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)))))
+	assert.NoError(t, write("person/born", Inst(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC))).Error)
+	res := write("person/name", String("Grumpy"))
+	assert.Equal(t, errRude, res.Error.(Error).Context["error"])
+	res = db.Write(Request{Claims: []Claim{{E: TempID("1"), A: Ident("person/name"), V: String("Mickey")}}})
+	assert.Equal(t, "database.write.invalidValuePredicate", res.Error.(Error).Code)
+
+	// Constraints may be changed, and apply to subsequent transactions.
+	res = db.Write(Request{Claims: []Claim{
+		{E: Ident("person/age"), A: Ident("sys/attr/max"), V: Float(150)},
+		{E: Ident("person/age"), A: Ident("sys/attr/min"), V: Float(0), Retract: true},
+	}})
+	assert.NoError(t, res.Error)
+	assert.NoError(t, write("person/age", Int(-1)).Error)
+	assert.Equal(t, "database.write.constraintViolated sys/attr/max", code(write("person/age", Int(151))))
+
+	// The values asserted with changes of constraints are checked against the changes.
+	res = db.Write(Request{Claims: []Claim{
+		{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+		{E: TempID("1"), A: Ident("person/age"), V: Int(140)},
+		{E: Ident("person/age"), A: Ident("sys/attr/max"), V: Float(130)},
+	}, ValuePredicates: predicates})
+	assert.Equal(t, "database.write.constraintViolated sys/attr/max", code(res))
+	res = db.Write(Request{Claims: []Claim{
+		{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+		{E: TempID("1"), A: Ident("person/age"), V: Int(151)},
+		{E: Ident("person/age"), A: Ident("sys/attr/max"), V: Float(160)},
+	}, ValuePredicates: predicates})
+	assert.NoError(t, res.Error)
+	res = db.Write(Request{Claims: []Claim{{E: Ident("person/age"), A: Ident("sys/attr/max"), V: Float(150)}}})
+	assert.NoError(t, res.Error)
+
+	// Constraints must suit the attribute's type, and constrain only attributes.
+	res = db.Write(Request{Claims: []Claim{{E: Ident("person/age"), A: Ident("sys/attr/regex"), V: String(".")}}})
+	assert.Equal(t, "database.write.invalidAttrConstraint", res.Error.(Error).Code)
+	res = db.Write(Request{Claims: []Claim{{E: Ident("person/name"), A: Ident("sys/attr/regex"), V: String("(")}}})
+	assert.Equal(t, "database.write.invalidAttrRegex", res.Error.(Error).Code)
+	res = db.Write(Request{Claims: []Claim{
+		{E: TempID("1"), A: Ident("person/name"), V: String("Mickey")},
+		{E: TempID("1"), A: Ident("sys/attr/max"), V: Float(1)},
+	}, ValuePredicates: predicates})
+	assert.Equal(t, "database.write.invalidAttrConstraint", res.Error.(Error).Code)
+}
@@ -34,6 +34,9 @@ func attrClaims(attrs []Attr) (claims []Claim) {
 				Claim{E: e, A: sys.AttrCardinality, V: attr.Cardinality},
 			)
 		}
+		if attr.Constraints != nil {
+			claims = append(claims, sys.ConstraintClaims(e, attr.Constraints)...)
+		}
 	}
 	return
 }
@@ -109,6 +109,15 @@ func TestWriteErrorCodes(t *testing.T) {
 			},
 			code: "database.write.invalidAttrType",
 		},
+		{
+			name: "mistyped lookup ref value",
+			setup: func(t *testing.T) (Database, Request) {
+				return newPersonDB(t), Request{Claims: []Claim{
+					{E: LookupRef{A: Ident("person/name"), V: Int(1)}, A: Ident("person/age"), V: Int(2)},
+				}}
+			},
+			code: "database.write.invalidE",
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -143,6 +152,186 @@ func TestWriteAttrImmutability(t *testing.T) {
 	})
 }
 
+// TestRedeclareResolvesExtant confirms that redeclaring an ident or attr through a
+// tempid resolves to the extant entity rather than orphaning it.
+func TestRedeclareResolvesExtant(t *testing.T) {
+	db := newPersonDB(t)
+	ageID := db.Read().ResolveIdent(Ident("person/age"))
+	res := db.Write(Request{Claims: []Claim{{E: TempID("1"), A: sys.DbIdent, V: String("person/kind/adult")}}})
+	assert.NoError(t, res.Error)
+	adultID := res.TempIDs[TempID("1")]
+
+	t.Run("attr", func(t *testing.T) {
+		assert.NoError(t, Declare(db, Attr{Ident: "person/age", Type: sys.AttrTypeInt}))
+		snapshot := db.Read()
+		assert.Equal(t, ageID, snapshot.ResolveIdent(Ident("person/age")))
+		assert.Equal(t, Ident("person/age"), snapshot.ResolveAttrIdent(ageID))
+	})
+	t.Run("ident", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: TempID("1"), A: sys.DbIdent, V: String("person/kind/adult")}}})
+		assert.NoError(t, res.Error)
+		assert.Equal(t, adultID, res.TempIDs[TempID("1")])
+		assert.Equal(t, adultID, res.Snapshot.ResolveIdent(Ident("person/kind/adult")))
+	})
+	t.Run("attr type change disallowed", func(t *testing.T) {
+		err := Declare(db, Attr{Ident: "person/age", Type: sys.AttrTypeString})
+		assertErrCode(t, err, "database.write.attrTypeChangeDisallowed")
+	})
+	t.Run("no orphans", func(t *testing.T) {
+		assert.NoError(t, Declare(db, Attr{Ident: "person/age", Type: sys.AttrTypeInt}))
+		snapshot := db.Read()
+		assert.Equal(t, 1, snapshot.Count(Claim{A: sys.DbIdent, V: String("person/age")}))
+	})
+	t.Run("ident becomes attr", func(t *testing.T) {
+		assert.NoError(t, Declare(db, Attr{Ident: "person/kind/adult", Type: sys.AttrTypeBool}))
+		snapshot := db.Read()
+		assert.Equal(t, adultID, snapshot.ResolveIdent(Ident("person/kind/adult")))
+		assert.Equal(t, Ident("person/kind/adult"), snapshot.ResolveAttrIdent(adultID))
+		res := db.Write(Request{Claims: []Claim{{E: TempID("x"), A: Ident("person/kind/adult"), V: Bool(true)}}})
+		assert.NoError(t, res.Error)
+	})
+}
+
+// TestSchemaMigrations confirms that the cardinality and uniqueness of extant attrs may
+// change through claims on them when their datums permit it, and that the indexes and
+// caches follow.
+func TestSchemaMigrations(t *testing.T) {
+	db := newPersonDB(t)
+	ageID := db.Read().ResolveIdent(Ident("person/age"))
+	nameID := db.Read().ResolveIdent(Ident("person/name"))
+	res := db.Write(Request{Claims: []Claim{
+		{E: TempID("ada"), A: Ident("person/name"), V: String("Ada")},
+		{E: TempID("ada"), A: Ident("person/age"), V: Int(36)},
+		{E: TempID("bob"), A: Ident("person/name"), V: String("Bob")},
+		{E: TempID("bob"), A: Ident("person/age"), V: Int(36)},
+	}})
+	assert.NoError(t, res.Error)
+	ada := res.TempIDs[TempID("ada")]
+	bob := res.TempIDs[TempID("bob")]
+
+	t.Run("cardinality one to many", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: ageID, A: sys.AttrCardinality, V: sys.AttrCardinalityMany}}})
+		assert.NoError(t, res.Error)
+		res = db.Write(Request{Claims: []Claim{{E: ada, A: Ident("person/age"), V: Int(37)}}})
+		assert.NoError(t, res.Error)
+		assert.Equal(t, 2, res.Snapshot.Count(Claim{E: ada, A: ageID}))
+	})
+	t.Run("cardinality many to one rejected", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: ageID, A: sys.AttrCardinality, V: sys.AttrCardinalityOne}}})
+		assertErrCode(t, res.Error, "database.write.attrCardinalityMigrationImpossible")
+		assert.True(t, res.Snapshot.Has(Claim{E: ageID, A: sys.AttrCardinality, V: sys.AttrCardinalityMany}))
+	})
+	t.Run("cardinality many to one", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{
+			{E: ada, A: Ident("person/age"), V: Int(36), Retract: true},
+			{E: ageID, A: sys.AttrCardinality, V: sys.AttrCardinalityMany, Retract: true},
+		}})
+		assert.NoError(t, res.Error)
+		res = db.Write(Request{Claims: []Claim{{E: ada, A: Ident("person/age"), V: Int(38)}}})
+		assert.NoError(t, res.Error)
+		assert.Equal(t, 1, res.Snapshot.Count(Claim{E: ada, A: ageID}))
+		assert.True(t, res.Snapshot.Has(Claim{E: ada, A: ageID, V: Int(38)}))
+	})
+	t.Run("unique rejected", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{
+			{E: bob, A: Ident("person/age"), V: Int(38)},
+			{E: ageID, A: sys.AttrUnique, V: sys.AttrUniqueValue},
+		}})
+		assertErrCode(t, res.Error, "database.write.attrUniqueMigrationImpossible")
+	})
+	t.Run("unique", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: ageID, A: sys.AttrUnique, V: sys.AttrUniqueIdentity}}})
+		assert.NoError(t, res.Error)
+		res = db.Write(Request{Claims: []Claim{{E: LookupRef{A: Ident("person/age"), V: Int(36)}, A: Ident("person/name"), V: String("Robert")}}})
+		assert.NoError(t, res.Error)
+		assert.True(t, res.Snapshot.Has(Claim{E: bob, A: nameID, V: String("Robert")}))
+		res = db.Write(Request{Claims: []Claim{{E: TempID("bob"), A: Ident("person/age"), V: Int(36)}}})
+		assert.NoError(t, res.Error)
+		assert.Equal(t, bob, res.TempIDs[TempID("bob")])
+	})
+	t.Run("not unique", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: ageID, A: sys.AttrUnique, V: sys.AttrUniqueIdentity, Retract: true}}})
+		assert.NoError(t, res.Error)
+		res = db.Write(Request{Claims: []Claim{{E: TempID("cy"), A: Ident("person/age"), V: Int(36)}}})
+		assert.NoError(t, res.Error)
+		assert.NotEqual(t, bob, res.TempIDs[TempID("cy")])
+		assert.Equal(t, 2, res.Snapshot.Count(Claim{A: ageID, V: Int(36)}))
+	})
+	t.Run("redeclared", func(t *testing.T) {
+		err := Declare(db, Attr{Ident: "person/age", Type: sys.AttrTypeInt, Cardinality: sys.AttrCardinalityMany})
+		assertErrCode(t, err, "database.write.attrCardinalityChangeDisallowed")
+		err = Declare(db, Attr{Ident: "person/age", Type: sys.AttrTypeInt, Unique: sys.AttrUniqueValue})
+		assertErrCode(t, err, "database.write.attrUniqueChangeDisallowed")
+		assert.False(t, db.Read().Has(Claim{E: ageID, A: sys.AttrCardinality}))
+	})
+	t.Run("sys attr change disallowed", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: sys.DbIdent, A: sys.AttrCardinality, V: sys.AttrCardinalityMany}}})
+		assertErrCode(t, res.Error, "database.write.sysAttrChangeDisallowed")
+	})
+}
+
+// TestAliases confirms that renamed attrs keep their prior idents as aliases, which
+// resolve until they are retired.
+func TestAliases(t *testing.T) {
+	db := newPersonDB(t)
+	nameID := db.Read().ResolveIdent(Ident("person/name"))
+	res := db.Write(Request{Claims: []Claim{{E: TempID("ada"), A: Ident("person/name"), V: String("Ada")}}})
+	assert.NoError(t, res.Error)
+	ada := res.TempIDs[TempID("ada")]
+
+	t.Run("rename", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: Ident("person/name"), A: sys.DbIdent, V: String("person/full-name")}}})
+		assert.NoError(t, res.Error)
+		snapshot := res.Snapshot
+		assert.Equal(t, nameID, snapshot.ResolveIdent(Ident("person/full-name")))
+		assert.Equal(t, nameID, snapshot.ResolveIdent(Ident("person/name")))
+		assert.Equal(t, Ident("person/full-name"), snapshot.ResolveAttrIdent(nameID))
+		assert.True(t, snapshot.Has(Claim{E: nameID, A: sys.DbAlias, V: String("person/name")}))
+	})
+	t.Run("alias resolves", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: LookupRef{A: Ident("person/name"), V: String("Ada")}, A: Ident("person/age"), V: Int(36)}}})
+		assert.NoError(t, res.Error)
+		res = db.Write(Request{Claims: []Claim{{E: TempID("ada"), A: Ident("person/name"), V: String("Ada")}}})
+		assert.NoError(t, res.Error)
+		assert.Equal(t, ada, res.TempIDs[TempID("ada")])
+	})
+	t.Run("copies keep aliases", func(t *testing.T) {
+		copied := db.Read().(*indexSnapshot).database()
+		assert.Equal(t, db.(*indexDatabase).attrsByIdent, copied.attrsByIdent)
+		assert.Equal(t, nameID, copied.attrsByIdent[Ident("person/name")].ID)
+	})
+	t.Run("redeclare alias", func(t *testing.T) {
+		assert.NoError(t, Declare(db, Attr{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity}))
+		snapshot := db.Read()
+		assert.Equal(t, nameID, snapshot.ResolveIdent(Ident("person/name")))
+		assert.Equal(t, Ident("person/full-name"), snapshot.ResolveAttrIdent(nameID))
+	})
+	t.Run("collision", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: Ident("person/age"), A: sys.DbIdent, V: String("person/name")}}})
+		assertErrCode(t, res.Error, "database.write.identCollision")
+		res = db.Write(Request{Claims: []Claim{{E: Ident("person/age"), A: sys.DbAlias, V: String("person/full-name")}}})
+		assertErrCode(t, res.Error, "database.write.identCollision")
+	})
+	t.Run("rename to alias", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: nameID, A: sys.DbIdent, V: String("person/name")}}})
+		assert.NoError(t, res.Error)
+		snapshot := res.Snapshot
+		assert.Equal(t, Ident("person/name"), snapshot.ResolveAttrIdent(nameID))
+		assert.Equal(t, nameID, snapshot.ResolveIdent(Ident("person/full-name")))
+		assert.False(t, snapshot.Has(Claim{E: nameID, A: sys.DbAlias, V: String("person/name")}))
+	})
+	t.Run("retire", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: nameID, A: sys.DbAlias, V: String("person/full-name"), Retract: true}}})
+		assert.NoError(t, res.Error)
+		assert.Zero(t, res.Snapshot.ResolveIdent(Ident("person/full-name")))
+		assert.Equal(t, nameID, res.Snapshot.ResolveIdent(Ident("person/name")))
+	})
+	t.Run("sys attr rename disallowed", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: sys.TxAt, A: sys.DbIdent, V: String("tx/at")}}})
+		assertErrCode(t, res.Error, "database.write.sysAttrChangeDisallowed")
+	})
+}
+
 // TestTempIDResolution confirms a tempid used in multiple claims of one request
 // resolves to a single new entity id.
 func TestTempIDResolution(t *testing.T) {
@@ -374,3 +563,49 @@ func TestSnapshotSelectNonUniqueAV(t *testing.T) {
 	assert.Equal(t, 2, view.Count(Claim{A: Ident("person/age"), V: Int(40)}))
 	assert.Equal(t, 1, view.Count(Claim{A: Ident("person/age"), V: Int(41)}))
 }
+
+// TestSnapshotSelectReferrers covers the (*,A,V) and (*,*,V) paths for ref values,
+// which are served by the vae index as it is maintained through assertions,
+// cardinality-one replacements and retractions.
+func TestSnapshotSelectReferrers(t *testing.T) {
+	db := NewIndexDatabase(32, 64, 64)
+	assert.NoError(t, Declare(db,
+		Attr{Ident: "pet/owner", Type: sys.AttrTypeRef},
+		Attr{Ident: "pet/sitter", Type: sys.AttrTypeRef},
+	))
+	res := db.Write(Request{Claims: []Claim{
+		{E: TempID("alice"), A: sys.DbIdent, V: String("alice")},
+		{E: TempID("bob"), A: sys.DbIdent, V: String("bob")},
+		{E: TempID("rex"), A: Ident("pet/owner"), V: TempID("alice")},
+		{E: TempID("tom"), A: Ident("pet/owner"), V: TempID("alice")},
+		{E: TempID("tom"), A: Ident("pet/sitter"), V: TempID("bob")},
+		{E: TempID("fifi"), A: Ident("pet/sitter"), V: TempID("alice")},
+	}})
+	assert.NoError(t, res.Error)
+	alice := res.TempIDs[TempID("alice")]
+	bob := res.TempIDs[TempID("bob")]
+	rex := res.TempIDs[TempID("rex")]
+	tom := res.TempIDs[TempID("tom")]
+	fifi := res.TempIDs[TempID("fifi")]
+	view := res.Snapshot
+	referrers := func(view Snapshot, claim Claim) (es []ID) {
+		for datum := range view.Select(claim) {
+			es = append(es, datum.E)
+		}
+		return
+	}
+	assert.Equal(t, []ID{rex, tom}, referrers(view, Claim{A: Ident("pet/owner"), V: alice}))
+	assert.Equal(t, 2, view.Count(Claim{A: Ident("pet/owner"), V: alice}))
+	assert.ElementsMatch(t, []ID{rex, tom, fifi}, referrers(view, Claim{V: alice}))
+	assert.Equal(t, 3, view.Count(Claim{V: alice}))
+	assert.Empty(t, referrers(view, Claim{A: Ident("pet/owner"), V: String("alice")}))
+
+	res = db.Write(Request{Claims: []Claim{
+		{E: tom, A: Ident("pet/owner"), V: bob},
+		{E: rex, A: Ident("pet/owner"), V: alice, Retract: true},
+	}})
+	assert.NoError(t, res.Error)
+	assert.Empty(t, referrers(res.Snapshot, Claim{A: Ident("pet/owner"), V: alice}))
+	assert.Equal(t, []ID{tom}, referrers(res.Snapshot, Claim{A: Ident("pet/owner"), V: bob}))
+	assert.Equal(t, []ID{rex, tom}, referrers(view, Claim{A: Ident("pet/owner"), V: alice}), "snapshots are isolated")
+}
@@ -2,9 +2,13 @@ package database
 
 import (
 	"iter"
+	"log"
+	"maps"
+	"sync"
 	"time"
 
 	"github.com/dball/destructive/internal/index"
+	"github.com/dball/destructive/internal/sys"
 	. "github.com/dball/destructive/internal/types"
 )
 
@@ -12,12 +16,60 @@ type indexSnapshot struct {
 	eav    index.Index
 	aev    index.Index
 	ave    index.Index
+	vae    index.Index
 	idents map[Ident]ID
 	attrs  map[ID]Attr
+	// nextID is the next id the database would have allocated.
+	nextID ID
+	// aliasVersion is the alias version of the database.
+	aliasVersion uint64
+	// lock guards cloning the indexes.
+	lock sync.Mutex
 }
 
 var _ Snapshot = (*indexSnapshot)(nil)
 
+// database returns a database whose datums and caches are copies of the snapshot's.
+func (snapshot *indexSnapshot) database() (db *indexDatabase) {
+	n := len(snapshot.attrs)
+	db = &indexDatabase{
+		attrsByID:      maps.Clone(snapshot.attrs),
+		attrsByIdent:   make(map[Ident]Attr, n),
+		attrTypes:      make(map[ID]ID, n),
+		attrUniques:    make(map[ID]ID, n),
+		attrCardManies: make(map[ID]Void, n),
+		idents:         maps.Clone(snapshot.idents),
+		nextID:         snapshot.nextID,
+		aliasVersion:   snapshot.aliasVersion,
+		logger:         *log.Default(),
+	}
+	for id, attr := range snapshot.attrs {
+		db.attrsByIdent[attr.Ident] = attr
+		db.attrTypes[id] = attr.Type
+		if attr.Unique != 0 {
+			db.attrUniques[id] = attr.Unique
+		}
+		if attr.Cardinality == sys.AttrCardinalityMany {
+			db.attrCardManies[id] = Void{}
+		}
+	}
+	// The attrs are also found by their aliases.
+	for ident, id := range snapshot.idents {
+		if attr, ok := snapshot.attrs[id]; ok {
+			db.attrsByIdent[ident] = attr
+		}
+	}
+	// Cloning a btree replaces its copy-on-write context, so clones must be exclusive.
+	snapshot.lock.Lock()
+	defer snapshot.lock.Unlock()
+	// The copied indexes must not share the attr types of the snapshot's database.
+	db.eav = snapshot.eav.CloneWith(db.attrTypes)
+	db.aev = snapshot.aev.CloneWith(db.attrTypes)
+	db.ave = snapshot.ave.CloneWith(db.attrTypes)
+	db.vae = snapshot.vae.CloneWith(db.attrTypes)
+	return
+}
+
 // access describes how to answer a resolved claim: which index and partial to read,
 // whether to scan every datum, and an optional value to post-filter by for shapes
 // the chosen index cannot constrain on value. Select and Count share it so the
@@ -46,15 +98,26 @@ func (snapshot *indexSnapshot) access(match Datum) access {
 	case hasE:
 		return access{index: snapshot.eav, partial: index.E}
 	case hasA && hasV:
-		// A unique attribute is indexed by value; otherwise scan the attribute and
-		// filter by value.
-		if snapshot.attrs[match.A].Unique != 0 {
+		// A unique attribute is indexed by value, as is a ref attribute by referent;
+		// otherwise scan the attribute and filter by value.
+		attr := snapshot.attrs[match.A]
+		if !sys.ValidValue(attr.Type, match.V) {
+			return access{index: snapshot.aev, partial: index.A, filterV: match.V}
+		}
+		if attr.Unique != 0 {
 			return access{index: snapshot.ave, partial: index.AV}
 		}
+		if attr.Type == sys.AttrTypeRef {
+			return access{index: snapshot.vae, partial: index.VA}
+		}
 		return access{index: snapshot.aev, partial: index.A, filterV: match.V}
 	case hasA:
 		return access{index: snapshot.aev, partial: index.A}
 	case hasV:
+		// Only ref datums have id values, and these are indexed by referent.
+		if _, ok := match.V.(ID); ok {
+			return access{index: snapshot.vae, partial: index.V}
+		}
 		return access{all: true, filterV: match.V}
 	default:
 		return access{all: true}
@@ -143,6 +206,11 @@ func (snapshot *indexSnapshot) ResolveAttrIdent(id ID) (ident Ident) {
 	return
 }
 
+func (snapshot *indexSnapshot) AliasVersion() (version uint64) {
+	version = snapshot.aliasVersion
+	return
+}
+
 func (snapshot *indexSnapshot) resolveLookupRef(ref LookupRef) (id ID) {
 	datum := Datum{V: ref.V}
 	switch a := ref.A.(type) {
@@ -153,7 +221,8 @@ func (snapshot *indexSnapshot) resolveLookupRef(ref LookupRef) (id ID) {
 	default:
 		return
 	}
-	if snapshot.attrs[datum.A].Unique == 0 {
+	attr := snapshot.attrs[datum.A]
+	if attr.Unique == 0 || !sys.ValidValue(attr.Type, datum.V) {
 		return
 	}
 	match, ok := snapshot.ave.First(index.AV, datum)
@@ -26,6 +26,7 @@ const AE PartialIndex = 3
 const A PartialIndex = 4
 const AV PartialIndex = 5
 const VA PartialIndex = 6
+const V PartialIndex = 7
 
 // EAVIndex is the EAV index type.
 var EAVIndex = IndexType{
@@ -51,7 +52,8 @@ var AVEIndex = IndexType{
 	FloatLesser:  LessAVE[float64],
 }
 
-// VAEIndex is the VAE index type.
+// VAEIndex is the VAE index type. It holds only ref datums, answering which entities
+// refer to a given entity through the VA and V partials.
 var VAEIndex = IndexType{
 	StringLesser: LessVAE[string],
 	IntLesser:    LessVAE[int64],
@@ -84,6 +86,9 @@ type Index interface {
 	// Clone returns a copy of the index. Both instances are hereafter safe to change without affecting
 	// the other.
 	Clone() (clone Index)
+	// CloneWith returns a copy of the index like Clone, which resolves attribute types through the given
+	// map rather than the index's. The map must have the types of the attributes of the indexed datums.
+	CloneWith(attrTypes map[ID]ID) (clone Index)
 }
 
 // CompositeIndex is an index of indexes of the discrete types.
@@ -273,6 +278,11 @@ func (idx *CompositeIndex) Select(p PartialIndex, datum Datum) (seq iter.Seq[Dat
 		seq = mergeEAV(strings, mapSeq(ints, idx.reint), mapSeq(uints, idx.reuint), floats)
 		return
 	}
+	if p == V {
+		// Only ref values are indexed by value alone.
+		seq = idx.uints.Select(CompareV[uint64], refValuer.valuer, TypedDatum[uint64]{V: refValuer.devaluer(datum.V)})
+		return
+	}
 	switch idx.attrTypes[datum.A] {
 	case sys.AttrTypeString:
 		switch p {
@@ -306,6 +316,8 @@ func (idx *CompositeIndex) Select(p PartialIndex, datum Datum) (seq iter.Seq[Dat
 			seq = idx.uints.Select(CompareA[uint64], refValuer.valuer, TypedDatum[uint64]{A: datum.A})
 		case AV:
 			seq = idx.uints.Select(CompareAV[uint64], refValuer.valuer, TypedDatum[uint64]{A: datum.A, V: refValuer.devaluer(datum.V)})
+		case VA:
+			seq = idx.uints.Select(CompareVA[uint64], refValuer.valuer, TypedDatum[uint64]{A: datum.A, V: refValuer.devaluer(datum.V)})
 		}
 	case sys.AttrTypeFloat:
 		switch p {
@@ -354,6 +366,10 @@ func (idx *CompositeIndex) First(p PartialIndex, datum Datum) (match Datum, exta
 		}
 		return
 	}
+	if p == V {
+		match, extant = idx.uints.First(CompareV[uint64], refValuer.valuer, TypedDatum[uint64]{V: refValuer.devaluer(datum.V)})
+		return
+	}
 	switch idx.attrTypes[datum.A] {
 	case sys.AttrTypeString:
 		switch p {
@@ -387,6 +403,8 @@ func (idx *CompositeIndex) First(p PartialIndex, datum Datum) (match Datum, exta
 			match, extant = idx.uints.First(CompareA[uint64], refValuer.valuer, TypedDatum[uint64]{A: datum.A})
 		case AV:
 			match, extant = idx.uints.First(CompareAV[uint64], refValuer.valuer, TypedDatum[uint64]{A: datum.A, V: refValuer.devaluer(datum.V)})
+		case VA:
+			match, extant = idx.uints.First(CompareVA[uint64], refValuer.valuer, TypedDatum[uint64]{A: datum.A, V: refValuer.devaluer(datum.V)})
 		}
 	case sys.AttrTypeFloat:
 		switch p {
@@ -434,6 +452,10 @@ func (idx *CompositeIndex) Count(p PartialIndex, datum Datum) (count int) {
 		count += idx.floats.Count(CompareE[float64], TypedDatum[float64]{E: datum.E})
 		return
 	}
+	if p == V {
+		count = idx.uints.Count(CompareV[uint64], TypedDatum[uint64]{V: refValuer.devaluer(datum.V)})
+		return
+	}
 	switch idx.attrTypes[datum.A] {
 	case sys.AttrTypeString:
 		switch p {
@@ -467,6 +489,8 @@ func (idx *CompositeIndex) Count(p PartialIndex, datum Datum) (count int) {
 			count = idx.uints.Count(CompareA[uint64], TypedDatum[uint64]{A: datum.A})
 		case AV:
 			count = idx.uints.Count(CompareAV[uint64], TypedDatum[uint64]{A: datum.A, V: refValuer.devaluer(datum.V)})
+		case VA:
+			count = idx.uints.Count(CompareVA[uint64], TypedDatum[uint64]{A: datum.A, V: refValuer.devaluer(datum.V)})
 		}
 	case sys.AttrTypeFloat:
 		switch p {
@@ -506,8 +530,12 @@ func (idx *CompositeIndex) Count(p PartialIndex, datum Datum) (count int) {
 }
 
 func (idx *CompositeIndex) Clone() (clone Index) {
+	return idx.CloneWith(idx.attrTypes)
+}
+
+func (idx *CompositeIndex) CloneWith(attrTypes map[ID]ID) (clone Index) {
 	clone = &CompositeIndex{
-		attrTypes: idx.attrTypes,
+		attrTypes: attrTypes,
 		strings:   idx.strings.Clone(),
 		ints:      idx.ints.Clone(),
 		uints:     idx.uints.Clone(),
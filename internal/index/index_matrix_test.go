@@ -114,9 +114,8 @@ func TestAVECountAndFirst(t *testing.T) {
 	assert.Equal(t, Datum{E: e1, A: a, V: String("ident/one"), T: tx}, first)
 }
 
-// TestVAEInsertFindDelete covers the VAE (back-reference) index. The composite
-// index does not wire a Select partial for vae today, so this asserts only the
-// membership operations the database actually uses on it.
+// TestVAEInsertFindDelete covers the membership operations of the VAE
+// (back-reference) index.
 func TestVAEInsertFindDelete(t *testing.T) {
 	allocate := newAllocator()
 	a := allocate()
@@ -133,6 +132,40 @@ func TestVAEInsertFindDelete(t *testing.T) {
 	assert.False(t, idx.Delete(d))
 }
 
+// TestVAESelectByValue covers the VA and V partials of the VAE index, which find
+// the entities referring to a given entity, in attribute then entity order.
+func TestVAESelectByValue(t *testing.T) {
+	allocate := newAllocator()
+	a1 := allocate()
+	a2 := allocate()
+	idx := NewCompositeIndex(32, VAEIndex, map[ID]ID{a1: sys.AttrTypeRef, a2: sys.AttrTypeRef})
+	tx := allocate()
+	ref := allocate()
+	other := allocate()
+	e1 := allocate()
+	e2 := allocate()
+	e3 := allocate()
+	idx.Insert(Datum{E: e2, A: a1, V: ref, T: tx})
+	idx.Insert(Datum{E: e3, A: a2, V: ref, T: tx})
+	idx.Insert(Datum{E: e1, A: a1, V: ref, T: tx})
+	idx.Insert(Datum{E: e1, A: a1, V: other, T: tx})
+
+	expected := []Datum{
+		{E: e1, A: a1, V: ref, T: tx},
+		{E: e2, A: a1, V: ref, T: tx},
+	}
+	assert.Equal(t, expected, slices.Collect(idx.Select(VA, Datum{A: a1, V: ref})))
+	assert.Equal(t, 2, idx.Count(VA, Datum{A: a1, V: ref}))
+	first, ok := idx.First(VA, Datum{A: a2, V: ref})
+	assert.True(t, ok)
+	assert.Equal(t, Datum{E: e3, A: a2, V: ref, T: tx}, first)
+
+	assert.Equal(t, append(expected, Datum{E: e3, A: a2, V: ref, T: tx}), slices.Collect(idx.Select(V, Datum{V: ref})))
+	assert.Equal(t, 1, idx.Count(V, Datum{V: other}))
+	_, ok = idx.First(V, Datum{V: e3})
+	assert.False(t, ok)
+}
+
 // TestFirstNegativeInt covers a fixed bug: First on an int- or inst-typed attribute
 // used to start its scan from the zero value instead of math.MinInt64, skipping
 // negative values. First(EA) now returns the true minimum, -5.
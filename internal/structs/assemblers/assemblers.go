@@ -18,44 +18,78 @@ type mapAwaitingEntry struct {
 }
 
 type sliceAwaitingEntry struct {
-	collValue Ident
-	slice     reflect.Value
-	pointer   reflect.Value
+	index            int
+	slice            reflect.Value
+	pointer          reflect.Value
+	sliceHasPointers bool
+}
+
+// instanceKey identifies an entity instance by the entity id and the struct type in which
+// it is assembled. One entity may be assembled as instances of several struct types.
+type instanceKey struct {
+	id  ID
+	typ reflect.Type
+}
+
+// pendingEntity is an allocated entity awaiting assembly at its depth from the root.
+type pendingEntity struct {
+	id      ID
+	pointer reflect.Value
+	depth   int
+}
+
+// Options control the extent of assembly.
+type Options struct {
+	// MaxDepth, if positive, limits the depth of the entities assembled from the root, which
+	// is at depth zero. The fields of entities at the maximum depth that hold referent
+	// structs are left empty.
+	MaxDepth int
+	// Transient, if given, reports the entity ids that are not recorded in id fields, e.g.
+	// those allocated for entities in an ephemeral snapshot.
+	Transient func(id ID) bool
 }
 
-// TODO an assembler can only have a single instance type per id, which is not a constraint imposed
-// by the database. Should we reify this more formally?
 type assembler struct {
 	// analyzer converts types to struct models
 	analyzer models.Analyzer
 	// snapshot holds the actual datums
 	snapshot Snapshot
+	// options control the extent of assembly
+	options Options
 	// instances are pointers to fully realized entity instances
-	instances map[ID]reflect.Value
+	instances map[instanceKey]reflect.Value
 	// pointers are pointers to all of the at least partially realized entities allocated by the assembler
-	pointers map[ID]reflect.Value
-	// unprocessed are (not nil) pointers to unrealized entities
-	unprocessed map[ID]reflect.Value
+	pointers map[instanceKey]reflect.Value
+	// unprocessed are (not nil) pointers to unrealized entities, in the order they were reached
+	unprocessed []pendingEntity
 	// mapsAwaitingEntries are maps in entity struct fields awaiting referent entities to be realized
-	mapsAwaitingEntries map[ID][]mapAwaitingEntry
+	mapsAwaitingEntries map[instanceKey][]mapAwaitingEntry
 	// slicesAwaitingEntries are slices in entity struct fields awaiting referent entities to be realized
-	slicesAwaitingEntries map[ID][]sliceAwaitingEntry
+	slicesAwaitingEntries map[instanceKey][]sliceAwaitingEntry
+	// interfacesAwaitingEntries are interface entity struct fields awaiting referent struct values to be realized
+	interfacesAwaitingEntries map[instanceKey][]reflect.Value
 }
 
 func NewAssembler(analyzer models.Analyzer, snapshot Snapshot) (as *assembler) {
+	return NewAssemblerWith(analyzer, snapshot, Options{})
+}
+
+// NewAssemblerWith returns an assembler whose extent is controlled by the options.
+func NewAssemblerWith(analyzer models.Analyzer, snapshot Snapshot, options Options) (as *assembler) {
 	as = &assembler{
-		analyzer:              analyzer,
-		snapshot:              snapshot,
-		instances:             map[ID]reflect.Value{},
-		pointers:              map[ID]reflect.Value{},
-		unprocessed:           map[ID]reflect.Value{},
-		mapsAwaitingEntries:   map[ID][]mapAwaitingEntry{},
-		slicesAwaitingEntries: map[ID][]sliceAwaitingEntry{},
+		analyzer:                  analyzer,
+		snapshot:                  snapshot,
+		options:                   options,
+		instances:                 map[instanceKey]reflect.Value{},
+		pointers:                  map[instanceKey]reflect.Value{},
+		mapsAwaitingEntries:       map[instanceKey][]mapAwaitingEntry{},
+		slicesAwaitingEntries:     map[instanceKey][]sliceAwaitingEntry{},
+		interfacesAwaitingEntries: map[instanceKey][]reflect.Value{},
 	}
 	return
 }
 
-func (as *assembler) allocate(id ID, pointerType reflect.Type) (ptr reflect.Value) {
+func (as *assembler) allocate(id ID, pointerType reflect.Type, depth int) (ptr reflect.Value) {
 	// allocate the new pointer
 	pp := reflect.New(pointerType)
 	ptr = pp.Elem()
@@ -63,27 +97,23 @@ func (as *assembler) allocate(id ID, pointerType reflect.Type) (ptr reflect.Valu
 	entity := reflect.New(ptr.Type().Elem())
 	// store the new struct in the new pointer
 	ptr.Set(entity)
-	// store the pointer in the unrealized entities map
-	as.unprocessed[id] = ptr
-	as.pointers[id] = ptr
+	as.enqueue(id, ptr, depth)
 	return
 }
 
+// enqueue stores the pointer to the unrealized entity in the pointers map and the
+// unrealized entities queue.
+func (as *assembler) enqueue(id ID, ptr reflect.Value, depth int) {
+	as.pointers[instanceKey{id, ptr.Type().Elem()}] = ptr
+	as.unprocessed = append(as.unprocessed, pendingEntity{id, ptr, depth})
+}
+
 func (as *assembler) assembleAll() (err error) {
-	for {
-		if len(as.unprocessed) == 0 {
-			break
-		}
-		var id ID
-		var ptr reflect.Value
-		// TODO this should ideally be the first value in id order, so, again, sorted map
-		for k, v := range as.unprocessed {
-			id = k
-			ptr = v
-			delete(as.unprocessed, k)
-			break
-		}
-		err = as.assemble(id, ptr)
+	// The entities are assembled breadth first, so each is reached at its least depth.
+	for len(as.unprocessed) > 0 {
+		next := as.unprocessed[0]
+		as.unprocessed = as.unprocessed[1:]
+		err = as.assemble(next.id, next.pointer, next.depth)
 		if err != nil {
 			return
 		}
@@ -91,8 +121,9 @@ func (as *assembler) assembleAll() (err error) {
 	return
 }
 
-func (as *assembler) assemble(id ID, ptr reflect.Value) (err error) {
+func (as *assembler) assemble(id ID, ptr reflect.Value, depth int) (err error) {
 	value := ptr.Elem()
+	atMaxDepth := as.options.MaxDepth > 0 && depth >= as.options.MaxDepth
 
 	model, modelErr := as.analyzer.Analyze(value.Type())
 	if modelErr != nil {
@@ -102,43 +133,60 @@ func (as *assembler) assemble(id ID, ptr reflect.Value) (err error) {
 
 	// We know the A's in which we're interested, so we could be more selective (heh) here
 	// if we had reason to believe the snapshot E had many more A's.
+	gen := model.Generated
 	foundAny := false
 	for datum := range as.snapshot.Select(Claim{E: id}) {
 		foundAny = true
-		ident := as.snapshot.ResolveAttrIdent(datum.A)
-		attr, ok := model.Attr(ident)
+		attr, ok := as.analyzer.ResolveAttr(model, datum.A, as.snapshot)
 		if !ok {
 			// Here's where we could be accumulating stats of attr hit rates for e types, sort of.
 			continue
 		}
+		// Generated structs hold only ids and scalars, which need no further assembly. The
+		// values their functions decline, e.g. those of stale generated code, are assembled
+		// by reflection.
+		if gen != nil && gen.Assemble(ptr.Interface(), attr.Ident, datum.V) {
+			continue
+		}
+		if _, ok := datum.V.(ID); ok && atMaxDepth && holdsReferents(attr) {
+			continue
+		}
 		field := value.Field(attr.Index)
 		if attr.IsPointer() {
 			// TODO who owns the vs anyway? If they're not copied at some point,
 			// exposing value pointers opens the door to database corruption.
 			switch v := datum.V.(type) {
-			case String:
+			case String, Int, Bool, Float, Inst:
 				// TODO does this count as a copy for the purpose of ensuring the
 				// outer pointer doesn't change the Fact value?
-				fv := string(v)
-				field.Set(reflect.ValueOf(&fv))
-			case Int:
-				fv := int(v)
-				field.Set(reflect.ValueOf(&fv))
-			case Bool:
-				fv := bool(v)
-				field.Set(reflect.ValueOf(&fv))
-			case Float:
-				fv := float64(v)
-				field.Set(reflect.ValueOf(&fv))
-			case Inst:
-				fv := time.Time(v)
-				field.Set(reflect.ValueOf(&fv))
+				fv := reflect.New(field.Type().Elem())
+				err = decode(attr.Codec, fv.Elem(), v)
+				if err != nil {
+					return
+				}
+				field.Set(fv)
 			case ID:
-				pointer, ok := as.pointers[v]
-				if ok {
+				pointer, ok := as.pointers[instanceKey{v, field.Type().Elem()}]
+				switch {
+				case attr.Ref:
+					fv := reflect.New(field.Type().Elem())
+					fv.Elem().SetUint(uint64(v))
+					field.Set(fv)
+				case attr.Lookup != "":
+					key := as.findDatumValue(v, attr.Lookup)
+					if key == nil {
+						continue
+					}
+					fv := reflect.New(field.Type().Elem())
+					err = decode(attr.Codec, fv.Elem(), key)
+					if err != nil {
+						return
+					}
+					field.Set(fv)
+				case ok:
 					field.Set(pointer)
-				} else {
-					pointer := as.allocate(v, field.Type())
+				default:
+					pointer := as.allocate(v, field.Type(), depth+1)
 					field.Set(pointer)
 				}
 			default:
@@ -147,21 +195,56 @@ func (as *assembler) assemble(id ID, ptr reflect.Value) (err error) {
 			}
 		} else {
 			switch v := datum.V.(type) {
-			case String:
-				field.SetString(string(v))
-			case Int:
-				// TODO what about overflows?
-				field.SetInt(int64(v))
-			case Bool:
-				field.SetBool(bool(v))
-			case Float:
-				field.SetFloat(float64(v))
-			case Inst:
-				field.Set(reflect.ValueOf(time.Time(v)))
+			case String, Int, Bool, Float, Inst:
+				err = decode(attr.Codec, field, v)
+				if err != nil {
+					return
+				}
 			case ID:
 				switch {
 				case attr.Ident == sys.DbId:
 					field.SetUint(uint64(v))
+				case attr.Lazy:
+					field.Addr().Interface().(lazyBinder).bind(as.analyzer, as.snapshot, as.options, v)
+				case attr.Ref && attr.IsSlice():
+					if field.IsNil() {
+						n := as.snapshot.Count(Claim{E: datum.E, A: datum.A})
+						field.Set(reflect.MakeSlice(field.Type(), 0, n))
+					}
+					field.Set(reflect.Append(field, reflect.ValueOf(uint64(v)).Convert(field.Type().Elem())))
+				case attr.Ref:
+					field.SetUint(uint64(v))
+				case attr.Lookup != "":
+					key := as.findDatumValue(v, attr.Lookup)
+					if key == nil {
+						continue
+					}
+					err = decode(attr.Codec, field, key)
+					if err != nil {
+						return
+					}
+				case attr.IsInterface():
+					binding, bindingErr := as.binding(v, field.Type())
+					if bindingErr != nil {
+						err = bindingErr
+						return
+					}
+					pointer, ok, referentErr := as.referent(v, binding.StructType(), depth+1)
+					if referentErr != nil {
+						err = referentErr
+						return
+					}
+					switch {
+					case binding.Type.Kind() == reflect.Pointer:
+						field.Set(pointer)
+					case ok:
+						field.Set(pointer.Elem())
+					default:
+						// The interface holds a copy of the struct, so it must wait until the
+						// struct is realized.
+						key := instanceKey{v, binding.StructType()}
+						as.interfacesAwaitingEntries[key] = append(as.interfacesAwaitingEntries[key], field)
+					}
 				case attr.IsMap():
 					var m reflect.Value
 					if field.IsNil() {
@@ -172,15 +255,27 @@ func (as *assembler) assemble(id ID, ptr reflect.Value) (err error) {
 						m = field
 					}
 					mapValueType := m.Type().Elem()
+					if mapValueType.Kind() == reflect.Interface {
+						binding, bindingErr := as.binding(v, mapValueType)
+						if bindingErr != nil {
+							err = bindingErr
+							return
+						}
+						mapValueType = binding.Type
+					}
 					mapHasPointers := mapValueType.Kind() == reflect.Pointer
 					if mapHasPointers {
 						mapValueType = mapValueType.Elem()
 					}
-					pointer, ok := as.pointers[v]
-					if !ok {
-						pointer = as.allocate(v, reflect.PointerTo(mapValueType))
+					pointer, ok, referentErr := as.referent(v, mapValueType, depth+1)
+					if referentErr != nil {
+						err = referentErr
+						return
+					}
+					err = as.addEntityToMap(attr.MapKey, m, instanceKey{v, mapValueType}, pointer, mapHasPointers, ok)
+					if err != nil {
+						return
 					}
-					as.addEntityToMap(attr.MapKey, m, v, pointer, mapHasPointers, ok)
 				case attr.IsSlice():
 					var slice reflect.Value
 					if field.IsNil() {
@@ -192,26 +287,39 @@ func (as *assembler) assemble(id ID, ptr reflect.Value) (err error) {
 					}
 					if attr.CollValue == "" {
 						sliceValueType := slice.Type().Elem()
-						pointer, ok := as.pointers[v]
-						if !ok {
-							pointer = as.allocate(v, reflect.PointerTo(sliceValueType))
+						sliceHasPointers := false
+						if sliceValueType.Kind() == reflect.Interface {
+							binding, bindingErr := as.binding(v, sliceValueType)
+							if bindingErr != nil {
+								err = bindingErr
+								return
+							}
+							sliceValueType = binding.StructType()
+							sliceHasPointers = binding.Type.Kind() == reflect.Pointer
+						}
+						pointer, ok, referentErr := as.referent(v, sliceValueType, depth+1)
+						if referentErr != nil {
+							err = referentErr
+							return
 						}
-						as.addEntityToSlice(attr.CollValue, slice, v, pointer, ok)
+						i := int(as.findValue(v, Ident("sys/db/rank")).(int64))
+						as.addEntityToSlice(slice, i, instanceKey{v, sliceValueType}, pointer, sliceHasPointers, ok)
 					} else {
 						// Since we have exactly two facts to find, we can reasonably just go right to them,
 						// though we may want to mark the entity id as processed now.
-						scalar := as.findValue(v, attr.CollValue)
 						i := int(as.findValue(v, Ident("sys/db/rank")).(int64))
-						slice.Index(i).Set(reflect.ValueOf(scalar))
+						err = decode(attr.Codec, slice.Index(i), as.findDatumValue(v, attr.CollValue))
+						if err != nil {
+							return
+						}
 					}
 				default:
-					pointer, ok := as.pointers[v]
+					pointer, ok := as.pointers[instanceKey{v, field.Type()}]
 					if ok {
 						field.Set(pointer.Elem())
 					} else {
 						pointer = field.Addr()
-						as.pointers[v] = pointer
-						as.unprocessed[v] = pointer
+						as.enqueue(v, pointer, depth+1)
 					}
 				}
 			default:
@@ -220,30 +328,196 @@ func (as *assembler) assemble(id ID, ptr reflect.Value) (err error) {
 			}
 		}
 	}
+	key := instanceKey{id, value.Type()}
 	if !foundAny {
-		as.instances[id] = reflect.ValueOf(nil)
+		as.instances[key] = reflect.ValueOf(nil)
 		return
 	}
 	attr, ok := model.Attr(Ident(sys.DbId))
-	if ok {
+	switch {
+	case !ok || (as.options.Transient != nil && as.options.Transient(id)):
+	case gen != nil && gen.Assemble(ptr.Interface(), sys.DbId, id):
+	default:
 		field := value.Field(attr.Index)
 		field.SetUint(uint64(id))
 	}
-	maes, ok := as.mapsAwaitingEntries[id]
+	for _, attr := range model.AttrFields {
+		if attr.Reverse && !atMaxDepth {
+			err = as.assembleReverse(id, attr, value.Field(attr.Index), depth+1)
+			if err != nil {
+				return
+			}
+		}
+	}
+	maes, ok := as.mapsAwaitingEntries[key]
 	if ok {
 		for _, mae := range maes {
-			as.addEntityToMap(mae.mapKey, mae.m, id, mae.pointer, mae.mapHasPointers, true)
+			err = as.addEntityToMap(mae.mapKey, mae.m, key, mae.pointer, mae.mapHasPointers, true)
+			if err != nil {
+				return
+			}
 		}
-		delete(as.mapsAwaitingEntries, id)
+		delete(as.mapsAwaitingEntries, key)
 	}
-	saes, ok := as.slicesAwaitingEntries[id]
+	saes, ok := as.slicesAwaitingEntries[key]
 	if ok {
 		for _, sae := range saes {
-			as.addEntityToSlice(sae.collValue, sae.slice, id, sae.pointer, true)
+			as.addEntityToSlice(sae.slice, sae.index, key, sae.pointer, sae.sliceHasPointers, true)
+		}
+		delete(as.slicesAwaitingEntries, key)
+	}
+	iaes, ok := as.interfacesAwaitingEntries[key]
+	if ok {
+		for _, field := range iaes {
+			field.Set(ptr.Elem())
+		}
+		delete(as.interfacesAwaitingEntries, key)
+	}
+	as.instances[key] = ptr
+	return
+}
+
+// assembleReverse fills the reverse field with the referrers of the entity with the
+// given id through the field's attribute, in id order. A pointer field holds the first.
+func (as *assembler) assembleReverse(id ID, attr models.AttrFieldModel, field reflect.Value, depth int) (err error) {
+	a := as.snapshot.ResolveIdent(attr.Ident)
+	if a == 0 {
+		return
+	}
+	claim := Claim{A: a, V: id}
+	if attr.IsPointer() {
+		for datum := range as.snapshot.Select(claim) {
+			pointer, _, referentErr := as.referent(datum.E, field.Type().Elem(), depth)
+			if referentErr != nil {
+				err = referentErr
+				return
+			}
+			field.Set(pointer)
+			break
+		}
+		return
+	}
+	n := as.snapshot.Count(claim)
+	if n == 0 {
+		return
+	}
+	slice := reflect.MakeSlice(field.Type(), n, n)
+	field.Set(slice)
+	structType := field.Type().Elem()
+	sliceHasPointers := structType.Kind() == reflect.Pointer
+	if sliceHasPointers {
+		structType = structType.Elem()
+	}
+	i := 0
+	for datum := range as.snapshot.Select(claim) {
+		pointer, _, referentErr := as.referent(datum.E, structType, depth)
+		if referentErr != nil {
+			err = referentErr
+			return
+		}
+		// Struct values are copied once the referrer is realized.
+		key := instanceKey{datum.E, structType}
+		_, realized := as.instances[key]
+		as.addEntityToSlice(slice, i, key, pointer, sliceHasPointers, sliceHasPointers || realized)
+		i++
+	}
+	return
+}
+
+// binding resolves the registered concrete type of the entity with the given id that
+// implements the interface type, by finding the discriminator datum on the entity.
+func (as *assembler) binding(id ID, iface reflect.Type) (binding models.TypeBinding, err error) {
+	for _, b := range as.analyzer.Registry().Implementations(iface) {
+		a := as.snapshot.ResolveIdent(b.Attr)
+		v := as.snapshot.ResolveIdent(b.Ident)
+		if a != 0 && v != 0 && as.snapshot.Has(Claim{E: id, A: a, V: v}) {
+			binding = b
+			return
+		}
+	}
+	err = NewError("assembler.unresolvedConcreteType", "id", id, "type", iface)
+	return
+}
+
+// holdsReferents reports whether the field holds referent structs, which are assembled in
+// turn, rather than ids or values.
+func holdsReferents(attr models.AttrFieldModel) bool {
+	return attr.Ident != sys.DbId && !attr.Ref && attr.Lookup == "" && !attr.Lazy && attr.CollValue == ""
+}
+
+// referent returns the pointer to the entity with the given id as the given struct type,
+// allocating it at the given depth if the assembler has not yet seen the entity as that
+// type. The extant flag reports whether the pointer was already present.
+func (as *assembler) referent(id ID, structType reflect.Type, depth int) (pointer reflect.Value, extant bool, err error) {
+	pointer, extant = as.pointers[instanceKey{id, structType}]
+	if !extant {
+		pointer = as.allocate(id, reflect.PointerTo(structType), depth)
+	}
+	return
+}
+
+// decode sets the scalar field to the value through the codec, if any, and otherwise
+// directly.
+func decode(codec models.Codec, field reflect.Value, v Value) (err error) {
+	if codec != nil {
+		err = codec.Decode(v, field)
+		return
+	}
+	err = setScalar(field, v)
+	return
+}
+
+// setScalar sets the scalar field to the value, converting it to the field's type and
+// rejecting values the field cannot represent.
+func setScalar(field reflect.Value, v Value) (err error) {
+	switch v := v.(type) {
+	case String:
+		if field.Kind() == reflect.Array {
+			if field.Len() != len(v) {
+				err = NewError("assembler.overflow", "value", v, "type", field.Type())
+				return
+			}
+			reflect.Copy(field, reflect.ValueOf([]byte(v)))
+		} else {
+			field.SetString(string(v))
+		}
+	case Int:
+		switch field.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if v < 0 || field.OverflowUint(uint64(v)) {
+				err = NewError("assembler.overflow", "value", v, "type", field.Type())
+				return
+			}
+			field.SetUint(uint64(v))
+		default:
+			if field.OverflowInt(int64(v)) {
+				err = NewError("assembler.overflow", "value", v, "type", field.Type())
+				return
+			}
+			field.SetInt(int64(v))
+		}
+	case Bool:
+		field.SetBool(bool(v))
+	case Float:
+		if field.OverflowFloat(float64(v)) {
+			err = NewError("assembler.overflow", "value", v, "type", field.Type())
+			return
 		}
-		delete(as.slicesAwaitingEntries, id)
+		field.SetFloat(float64(v))
+	case Inst:
+		field.Set(reflect.ValueOf(time.Time(v)))
+	default:
+		err = NewError("assembler.invalidFactValue")
+	}
+	return
+}
+
+// findDatumValue returns the value of the first datum for the entity and attribute.
+func (as *assembler) findDatumValue(e ID, a Ident) (v Value) {
+	for datum := range as.snapshot.Select(Claim{E: e, A: a}) {
+		v = datum.V
+		return
 	}
-	as.instances[id] = ptr
 	return
 }
 
@@ -271,45 +545,55 @@ func (as *assembler) findValue(e ID, a Ident) (v any) {
 	return
 }
 
-func (as *assembler) addEntityToMap(mapKey Ident, m reflect.Value, id ID, pointer reflect.Value, mapHasPointers bool, immediate bool) {
+func (as *assembler) addEntityToMap(mapKey Ident, m reflect.Value, key instanceKey, pointer reflect.Value, mapHasPointers bool, immediate bool) (err error) {
 	if immediate {
-		// findValue is the only way of finding the key value when it's not present on the value struct,
+		// findDatumValue is the only way of finding the key value when it's not present on the value struct,
 		// though is plausibly much less efficient when that is the case. It might be useful to optimize
 		// that common case by constructing a more robust (cached) model of a struct's attributes that
 		// allows lookup by ident and use that to lookup the field value by index here.
-		key := as.findValue(id, mapKey)
+		k := reflect.New(m.Type().Key()).Elem()
+		if mapKey == sys.DbId {
+			k.SetUint(uint64(key.id))
+		} else {
+			err = setScalar(k, as.findDatumValue(key.id, mapKey))
+			if err != nil {
+				return
+			}
+		}
 		value := pointer
 		if !mapHasPointers {
 			value = pointer.Elem()
 		}
-		m.SetMapIndex(reflect.ValueOf(key), value)
+		m.SetMapIndex(k, value)
 		return
 	}
 	mae := mapAwaitingEntry{mapKey, m, pointer, mapHasPointers}
-	maes, ok := as.mapsAwaitingEntries[id]
+	maes, ok := as.mapsAwaitingEntries[key]
 	if !ok {
-		as.mapsAwaitingEntries[id] = []mapAwaitingEntry{mae}
+		as.mapsAwaitingEntries[key] = []mapAwaitingEntry{mae}
 	} else {
 		maes = append(maes, mae)
-		as.mapsAwaitingEntries[id] = maes
+		as.mapsAwaitingEntries[key] = maes
 	}
+	return
 }
 
-func (as *assembler) addEntityToSlice(collValue Ident, slice reflect.Value, id ID, pointer reflect.Value, immediate bool) {
+func (as *assembler) addEntityToSlice(slice reflect.Value, i int, key instanceKey, pointer reflect.Value, sliceHasPointers bool, immediate bool) {
 	if immediate {
-		value := pointer.Elem()
-		index := as.findValue(id, Ident("sys/db/rank"))
-		i := int(index.(int64))
+		value := pointer
+		if !sliceHasPointers {
+			value = pointer.Elem()
+		}
 		slice.Index(i).Set(value)
 		return
 	}
-	sae := sliceAwaitingEntry{collValue, slice, pointer}
-	saes, ok := as.slicesAwaitingEntries[id]
+	sae := sliceAwaitingEntry{i, slice, pointer, sliceHasPointers}
+	saes, ok := as.slicesAwaitingEntries[key]
 	if !ok {
-		as.slicesAwaitingEntries[id] = []sliceAwaitingEntry{sae}
+		as.slicesAwaitingEntries[key] = []sliceAwaitingEntry{sae}
 	} else {
 		saes = append(saes, sae)
-		as.slicesAwaitingEntries[id] = saes
+		as.slicesAwaitingEntries[key] = saes
 	}
 }
 
@@ -320,14 +604,17 @@ func Assemble[T any](as *assembler, id ID) (entity *T, err error) {
 		err = modelErr
 		return
 	}
-	instance, ok := as.instances[id]
+	key := instanceKey{id, structType}
+	instance, ok := as.instances[key]
 	if !ok {
-		as.allocate(id, reflect.TypeFor[*T]())
+		if _, pending := as.pointers[key]; !pending {
+			as.allocate(id, reflect.TypeFor[*T](), 0)
+		}
 		err = as.assembleAll()
 		if err != nil {
 			return
 		}
-		instance, ok = as.instances[id]
+		instance, ok = as.instances[key]
 		if !ok {
 			err = NewError("assembler.failure", "id", id)
 			return
@@ -336,11 +623,35 @@ func Assemble[T any](as *assembler, id ID) (entity *T, err error) {
 	if instance == reflect.ValueOf(nil) {
 		return
 	}
-	extant := instance.Elem().Interface()
-	it, ok := extant.(T)
-	if !ok {
-		err = NewError("assembler.typeConflictForID", "id", id, "extant", extant, "type", structType)
-	}
+	it := instance.Elem().Interface().(T)
 	entity = &it
 	return
 }
+
+// Populate assembles the entity with the given id into the given struct, resetting its attr
+// fields first, and reports whether the entity was found. The assembler must not already
+// hold the entity.
+func Populate[T any](as *assembler, id ID, entity *T) (found bool, err error) {
+	structType := reflect.TypeFor[T]()
+	model, err := as.analyzer.Analyze(structType)
+	if err != nil {
+		return
+	}
+	if _, ok := as.pointers[instanceKey{id, structType}]; ok {
+		err = NewError("assembler.extantID", "id", id, "type", structType)
+		return
+	}
+	value := reflect.ValueOf(entity).Elem()
+	for _, attr := range model.AttrFields {
+		value.Field(attr.Index).SetZero()
+	}
+	ptr := reflect.New(reflect.TypeFor[*T]()).Elem()
+	ptr.Set(reflect.ValueOf(entity))
+	as.enqueue(id, ptr, 0)
+	err = as.assembleAll()
+	if err != nil {
+		return
+	}
+	found = as.instances[instanceKey{id, structType}] != reflect.ValueOf(nil)
+	return
+}
@@ -1,6 +1,7 @@
 package assemblers
 
 import (
+	"net/netip"
 	"reflect"
 	"testing"
 	"time"
@@ -294,3 +295,563 @@ func TestSliceOfScalarValues(t *testing.T) {
 	}
 	assert.Equal(t, expected, *entity)
 }
+
+type party interface {
+	partyName() string
+}
+
+type partyPerson struct {
+	Name string `attr:"person/name"`
+}
+
+func (p *partyPerson) partyName() string { return p.Name }
+
+type partyCompany struct {
+	Name string `attr:"company/name"`
+}
+
+func (c partyCompany) partyName() string { return c.Name }
+
+func TestInterfaceFields(t *testing.T) {
+	type Account struct {
+		Owner   party            `attr:"account/owner"`
+		Admins  []party          `attr:"account/admins"`
+		Vendors map[string]party `attr:"account/vendors,key=company/name"`
+	}
+
+	registry := models.NewRegistry()
+	assert.NoError(t, registry.Register(reflect.TypeFor[*partyPerson](), Ident("party/type"), Ident("party/type/person")))
+	assert.NoError(t, registry.Register(reflect.TypeFor[partyCompany](), Ident("party/type"), Ident("party/type/company")))
+	analyzer := models.BuildRegistryAnalyzer(registry)
+	db := database.NewIndexDatabase(32, 64, 64)
+	claims, err := schemas.AnalyzeWith(registry, reflect.TypeFor[Account]())
+	assert.NoError(t, err)
+	res := db.Write(Request{Claims: claims})
+	assert.NoError(t, res.Error)
+
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("account/owner"), V: TempID("2")},
+			{E: TempID("1"), A: Ident("account/admins"), V: TempID("2")},
+			{E: TempID("1"), A: Ident("account/vendors"), V: TempID("3")},
+			{E: TempID("2"), A: Ident("party/type"), V: Ident("party/type/person")},
+			{E: TempID("2"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("2"), A: Ident("sys/db/rank"), V: Int(0)},
+			{E: TempID("3"), A: Ident("party/type"), V: Ident("party/type/company")},
+			{E: TempID("3"), A: Ident("company/name"), V: String("Acme")},
+		},
+	}
+	res = db.Write(req)
+	assert.NoError(t, res.Error)
+	assembler := NewAssembler(analyzer, res.Snapshot)
+	entity, err := Assemble[Account](assembler, res.TempIDs[TempID("1")])
+	assert.NoError(t, err)
+
+	donald := &partyPerson{Name: "Donald"}
+	expected := Account{
+		Owner:   donald,
+		Admins:  []party{donald},
+		Vendors: map[string]party{"Acme": partyCompany{Name: "Acme"}},
+	}
+	assert.Equal(t, expected, *entity)
+	assert.Same(t, entity.Owner, entity.Admins[0])
+}
+
+func TestInterfaceFieldWithoutDiscriminator(t *testing.T) {
+	type Account struct {
+		Owner party `attr:"account/owner"`
+	}
+
+	registry := models.NewRegistry()
+	assert.NoError(t, registry.Register(reflect.TypeFor[*partyPerson](), Ident("party/type"), Ident("party/type/person")))
+	db := database.NewIndexDatabase(32, 64, 64)
+	claims, err := schemas.AnalyzeWith(registry, reflect.TypeFor[Account]())
+	assert.NoError(t, err)
+	assert.NoError(t, db.Write(Request{Claims: claims}).Error)
+	res := db.Write(Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("account/owner"), V: TempID("2")},
+			{E: TempID("2"), A: Ident("person/name"), V: String("Donald")},
+		},
+	})
+	assert.NoError(t, res.Error)
+	assembler := NewAssembler(models.BuildRegistryAnalyzer(registry), res.Snapshot)
+	_, err = Assemble[Account](assembler, res.TempIDs[TempID("1")])
+	assert.Error(t, err)
+}
+
+type status string
+
+func TestWideScalarFields(t *testing.T) {
+	type Sensor struct {
+		Status   status   `attr:"sensor/status"`
+		Channel  int8     `attr:"sensor/channel"`
+		Reads    uint64   `attr:"sensor/reads"`
+		Gain     *float32 `attr:"sensor/gain"`
+		Mode     *status  `attr:"sensor/mode"`
+		Checksum [4]byte  `attr:"sensor/checksum"`
+		Levels   []uint16 `attr:"sensor/levels,value=sensor/level"`
+	}
+
+	analyzer, db := buildComponents(t, Sensor{})
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("sensor/status"), V: String("online")},
+			{E: TempID("1"), A: Ident("sensor/channel"), V: Int(-3)},
+			{E: TempID("1"), A: Ident("sensor/reads"), V: Int(42)},
+			{E: TempID("1"), A: Ident("sensor/gain"), V: Float(0.5)},
+			{E: TempID("1"), A: Ident("sensor/mode"), V: String("auto")},
+			{E: TempID("1"), A: Ident("sensor/checksum"), V: String("abcd")},
+			{E: TempID("1"), A: Ident("sensor/levels"), V: TempID("2")},
+			{E: TempID("2"), A: Ident("sensor/level"), V: Int(7)},
+			{E: TempID("2"), A: Ident("sys/db/rank"), V: Int(0)},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	id := res.TempIDs[TempID("1")]
+	entity, err := Assemble[Sensor](NewAssembler(analyzer, res.Snapshot), id)
+	assert.NoError(t, err)
+	gain := float32(0.5)
+	mode := status("auto")
+	expected := Sensor{
+		Status:   status("online"),
+		Channel:  -3,
+		Reads:    42,
+		Gain:     &gain,
+		Mode:     &mode,
+		Checksum: [4]byte{'a', 'b', 'c', 'd'},
+		Levels:   []uint16{7},
+	}
+	assert.Equal(t, expected, *entity)
+
+	t.Run("overflow", func(t *testing.T) {
+		for _, claim := range []Claim{
+			{E: TempID("1"), A: Ident("sensor/channel"), V: Int(300)},
+			{E: TempID("1"), A: Ident("sensor/reads"), V: Int(-1)},
+			{E: TempID("1"), A: Ident("sensor/checksum"), V: String("abcde")},
+		} {
+			res := db.Write(Request{Claims: []Claim{claim}})
+			assert.NoError(t, res.Error)
+			_, err := Assemble[Sensor](NewAssembler(analyzer, res.Snapshot), res.TempIDs[TempID("1")])
+			assert.Error(t, err)
+		}
+	})
+}
+
+type cents struct {
+	amount int64
+}
+
+func (c cents) EncodeValue() (any, error) { return c.amount, nil }
+
+func (c *cents) DecodeValue(v any) error {
+	c.amount = v.(int64)
+	return nil
+}
+
+func TestCodecFields(t *testing.T) {
+	type Host struct {
+		Addr    netip.Addr   `attr:"host/addr"`
+		Backup  *netip.Addr  `attr:"host/backup"`
+		Price   cents        `attr:"host/price"`
+		Aliases []netip.Addr `attr:"host/aliases,value=host/alias"`
+	}
+
+	analyzer, db := buildComponents(t, Host{})
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("host/addr"), V: String("10.0.0.1")},
+			{E: TempID("1"), A: Ident("host/backup"), V: String("10.0.0.2")},
+			{E: TempID("1"), A: Ident("host/price"), V: Int(1999)},
+			{E: TempID("1"), A: Ident("host/aliases"), V: TempID("2")},
+			{E: TempID("2"), A: Ident("host/alias"), V: String("::1")},
+			{E: TempID("2"), A: Ident("sys/db/rank"), V: Int(0)},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	entity, err := Assemble[Host](NewAssembler(analyzer, res.Snapshot), res.TempIDs[TempID("1")])
+	assert.NoError(t, err)
+	backup := netip.MustParseAddr("10.0.0.2")
+	expected := Host{
+		Addr:    netip.MustParseAddr("10.0.0.1"),
+		Backup:  &backup,
+		Price:   cents{amount: 1999},
+		Aliases: []netip.Addr{netip.MustParseAddr("::1")},
+	}
+	assert.Equal(t, expected, *entity)
+
+	t.Run("invalid text", func(t *testing.T) {
+		res := db.Write(Request{Claims: []Claim{{E: TempID("1"), A: Ident("host/addr"), V: String("nope")}}})
+		assert.NoError(t, res.Error)
+		_, err := Assemble[Host](NewAssembler(analyzer, res.Snapshot), res.TempIDs[TempID("1")])
+		assert.Error(t, err)
+	})
+}
+
+func TestRefIDFields(t *testing.T) {
+	type Person struct {
+		Name string `attr:"person/name"`
+	}
+	type Pet struct {
+		Name    string   `attr:"pet/name"`
+		Owner   uint64   `attr:"pet/owner,ref"`
+		Vet     *uint64  `attr:"pet/vet,ref"`
+		Sitters []uint64 `attr:"pet/sitters,ref"`
+	}
+
+	analyzer, db := buildComponents(t, Person{}, Pet{})
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("2"), A: Ident("person/name"), V: String("Stephen")},
+			{E: TempID("3"), A: Ident("pet/name"), V: String("Momo")},
+			{E: TempID("3"), A: Ident("pet/owner"), V: TempID("1")},
+			{E: TempID("3"), A: Ident("pet/vet"), V: TempID("2")},
+			{E: TempID("3"), A: Ident("pet/sitters"), V: TempID("1")},
+			{E: TempID("3"), A: Ident("pet/sitters"), V: TempID("2")},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	donald := uint64(res.TempIDs[TempID("1")])
+	stephen := uint64(res.TempIDs[TempID("2")])
+	assembler := NewAssembler(analyzer, res.Snapshot)
+	entity, err := Assemble[Pet](assembler, res.TempIDs[TempID("3")])
+	assert.NoError(t, err)
+	expected := Pet{Name: "Momo", Owner: donald, Vet: &stephen, Sitters: []uint64{donald, stephen}}
+	assert.Equal(t, expected, *entity)
+	// The referents are not assembled.
+	assert.Len(t, assembler.pointers, 1)
+}
+
+func TestLookupFields(t *testing.T) {
+	type Person struct {
+		Email string `attr:"person/email,identity"`
+	}
+	type Order struct {
+		Customer string  `attr:"order/customer,lookup=person/email"`
+		Referrer *string `attr:"order/referrer,lookup=person/email"`
+	}
+
+	analyzer, db := buildComponents(t, Person{}, Order{})
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/email"), V: String("a@example.com")},
+			{E: TempID("2"), A: Ident("person/email"), V: String("b@example.com")},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	req = Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("order/customer"), V: LookupRef{A: Ident("person/email"), V: String("a@example.com")}},
+			{E: TempID("1"), A: Ident("order/referrer"), V: LookupRef{A: Ident("person/email"), V: String("b@example.com")}},
+		},
+	}
+	res = db.Write(req)
+	assert.NoError(t, res.Error)
+	assembler := NewAssembler(analyzer, res.Snapshot)
+	entity, err := Assemble[Order](assembler, res.TempIDs[TempID("1")])
+	assert.NoError(t, err)
+	referrer := "b@example.com"
+	assert.Equal(t, Order{Customer: "a@example.com", Referrer: &referrer}, *entity)
+	assert.Len(t, assembler.pointers, 1)
+}
+
+func TestReverseFields(t *testing.T) {
+	type Pet struct {
+		Name  string `attr:"pet/name"`
+		Owner uint64 `attr:"pet/owner,ref"`
+	}
+	type Person struct {
+		Name    string    `attr:"person/name"`
+		Pets    []*Pet    `attr:"pet/owner,reverse"`
+		Walks   []Pet     `attr:"pet/walker,reverse"`
+		Patient *Pet      `attr:"pet/vet,reverse"`
+		Reports []*Person `attr:"person/manager,reverse"`
+		Manager *Person   `attr:"person/manager"`
+	}
+	analyzer, db := buildComponents(t, Person{}, Pet{})
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("2"), A: Ident("person/name"), V: String("Stephen")},
+			{E: TempID("2"), A: Ident("person/manager"), V: TempID("1")},
+			{E: TempID("3"), A: Ident("pet/name"), V: String("Momo")},
+			{E: TempID("3"), A: Ident("pet/owner"), V: TempID("1")},
+			{E: TempID("3"), A: Ident("pet/walker"), V: TempID("1")},
+			{E: TempID("4"), A: Ident("pet/name"), V: String("Rex")},
+			{E: TempID("4"), A: Ident("pet/owner"), V: TempID("1")},
+			{E: TempID("4"), A: Ident("pet/vet"), V: TempID("1")},
+			{E: TempID("5"), A: Ident("pet/name"), V: String("Fifi")},
+			{E: TempID("5"), A: Ident("pet/owner"), V: TempID("2")},
+			{E: TempID("5"), A: Ident("pet/walker"), V: TempID("1")},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	assembler := NewAssembler(analyzer, res.Snapshot)
+	donaldID := uint64(res.TempIDs[TempID("1")])
+	stephenID := uint64(res.TempIDs[TempID("2")])
+	donald, err := Assemble[Person](assembler, ID(donaldID))
+	assert.NoError(t, err)
+	assert.Equal(t, "Donald", donald.Name)
+	assert.Nil(t, donald.Manager)
+	if assert.Len(t, donald.Pets, 2) {
+		assert.Equal(t, "Momo", donald.Pets[0].Name)
+		assert.Equal(t, "Rex", donald.Pets[1].Name)
+		assert.Equal(t, donaldID, donald.Pets[0].Owner)
+		assert.Same(t, donald.Pets[1], donald.Patient)
+	}
+	if assert.Len(t, donald.Walks, 2) {
+		assert.Equal(t, "Momo", donald.Walks[0].Name)
+		assert.Equal(t, "Fifi", donald.Walks[1].Name)
+		assert.Equal(t, stephenID, donald.Walks[1].Owner)
+	}
+	if assert.Len(t, donald.Reports, 1) {
+		stephen := donald.Reports[0]
+		assert.Equal(t, "Stephen", stephen.Name)
+		assert.Equal(t, "Donald", stephen.Manager.Name)
+		// The referrers refer back to the shared referent.
+		assert.Same(t, stephen, stephen.Manager.Reports[0])
+		assert.Empty(t, stephen.Reports)
+		if assert.Len(t, stephen.Pets, 1) {
+			assert.Equal(t, "Fifi", stephen.Pets[0].Name)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	type Pet struct {
+		Name string `attr:"pet/name"`
+	}
+	type Person struct {
+		Name      string   `attr:"person/name"`
+		Nicknames []string `attr:"person/nicknames,value=person/nickname"`
+		BFF       *Person  `attr:"person/bff"`
+		Pets      []Pet    `attr:"person/pets"`
+		Walks     []*Pet   `attr:"pet/walker,reverse"`
+	}
+
+	analyzer, db := buildComponents(t, Person{})
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("person/bff"), V: TempID("2")},
+			{E: TempID("2"), A: Ident("person/name"), V: String("Stephen")},
+			{E: TempID("2"), A: Ident("person/bff"), V: TempID("3")},
+			{E: TempID("2"), A: Ident("person/nicknames"), V: TempID("n")},
+			{E: TempID("n"), A: Ident("sys/db/rank"), V: Int(0)},
+			{E: TempID("n"), A: Ident("person/nickname"), V: String("Steve")},
+			{E: TempID("2"), A: Ident("person/pets"), V: TempID("p")},
+			{E: TempID("p"), A: Ident("sys/db/rank"), V: Int(0)},
+			{E: TempID("p"), A: Ident("pet/name"), V: String("Momo")},
+			{E: TempID("p"), A: Ident("pet/walker"), V: TempID("2")},
+			{E: TempID("3"), A: Ident("person/name"), V: String("Diane")},
+			{E: TempID("3"), A: Ident("person/bff"), V: TempID("1")},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	donald := res.TempIDs[TempID("1")]
+
+	t.Run("unlimited", func(t *testing.T) {
+		assembler := NewAssembler(analyzer, res.Snapshot)
+		entity, err := Assemble[Person](assembler, donald)
+		assert.NoError(t, err)
+		assert.Equal(t, "Diane", entity.BFF.BFF.Name)
+		assert.Equal(t, "Donald", entity.BFF.BFF.BFF.Name)
+		assert.Len(t, entity.BFF.Pets, 1)
+		assert.Len(t, entity.BFF.Walks, 1)
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		assembler := NewAssemblerWith(analyzer, res.Snapshot, Options{MaxDepth: 1})
+		entity, err := Assemble[Person](assembler, donald)
+		assert.NoError(t, err)
+		assert.Equal(t, "Donald", entity.Name)
+		stephen := entity.BFF
+		assert.Equal(t, "Stephen", stephen.Name)
+		// The values of the entity at the maximum depth are assembled, but not its referents.
+		assert.Equal(t, []string{"Steve"}, stephen.Nicknames)
+		assert.Nil(t, stephen.BFF)
+		assert.Nil(t, stephen.Pets)
+		assert.Nil(t, stephen.Walks)
+		assert.Len(t, assembler.pointers, 2)
+	})
+}
+
+func TestLazyFields(t *testing.T) {
+	type Person struct {
+		Name string `attr:"person/name"`
+	}
+	type Pet struct {
+		Name  string       `attr:"pet/name"`
+		Owner Lazy[Person] `attr:"pet/owner"`
+		Vet   Lazy[Person] `attr:"pet/vet"`
+	}
+
+	analyzer, db := buildComponents(t, Pet{})
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("2"), A: Ident("pet/name"), V: String("Momo")},
+			{E: TempID("2"), A: Ident("pet/owner"), V: TempID("1")},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	assembler := NewAssembler(analyzer, res.Snapshot)
+	entity, err := Assemble[Pet](assembler, res.TempIDs[TempID("2")])
+	assert.NoError(t, err)
+	assert.Equal(t, "Momo", entity.Name)
+	assert.Equal(t, res.TempIDs[TempID("1")], entity.Owner.RefID())
+	// The referent is not assembled with the referring struct.
+	assert.Len(t, assembler.pointers, 1)
+	owner, err := entity.Owner.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, &Person{Name: "Donald"}, owner)
+	again, err := entity.Owner.Get()
+	assert.NoError(t, err)
+	assert.Same(t, owner, again)
+	vet, err := entity.Vet.Get()
+	assert.NoError(t, err)
+	assert.Nil(t, vet)
+	assert.Zero(t, entity.Vet.RefID())
+}
+
+func TestPopulate(t *testing.T) {
+	type Person struct {
+		Name    string            `attr:"person/name"`
+		Age     *int              `attr:"person/age"`
+		BFF     *Person           `attr:"person/bff"`
+		Aliases map[string]Person `attr:"person/aliases,key=person/name"`
+		Notes   string
+	}
+
+	analyzer, db := buildComponents(t, Person{})
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("person/bff"), V: TempID("2")},
+			{E: TempID("2"), A: Ident("person/name"), V: String("Stephen")},
+			{E: TempID("2"), A: Ident("person/bff"), V: TempID("1")},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	donald := res.TempIDs[TempID("1")]
+
+	age := 50
+	person := Person{Name: "Stale", Age: &age, Aliases: map[string]Person{"Don": {}}, Notes: "kept"}
+	found, err := Populate(NewAssembler(analyzer, res.Snapshot), donald, &person)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "Donald", person.Name)
+	// The attr fields are reset, but not the other fields.
+	assert.Nil(t, person.Age)
+	assert.Nil(t, person.Aliases)
+	assert.Equal(t, "kept", person.Notes)
+	assert.Equal(t, "Stephen", person.BFF.Name)
+	// References to the entity refer to the populated struct.
+	assert.Same(t, &person, person.BFF.BFF)
+
+	found, err = Populate(NewAssembler(analyzer, res.Snapshot), ID(0x7fffffff), &person)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, Person{Notes: "kept"}, person)
+
+	assembler := NewAssembler(analyzer, res.Snapshot)
+	_, err = Assemble[Person](assembler, donald)
+	assert.NoError(t, err)
+	_, err = Populate(assembler, donald, &person)
+	assert.Error(t, err)
+}
+
+type viewPerson struct {
+	ID   uint64     `attr:"sys/db/id"`
+	Name string     `attr:"person/name"`
+	Age  int        `attr:"person/age"`
+	BFF  *viewNamed `attr:"person/bff"`
+}
+
+type viewNamed struct {
+	ID   uint64      `attr:"sys/db/id"`
+	Name string      `attr:"person/name"`
+	BFF  *viewPerson `attr:"person/bff"`
+}
+
+func TestEntityAsSeveralTypes(t *testing.T) {
+	analyzer, db := buildComponents(t, viewPerson{})
+	req := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("person/age"), V: Int(48)},
+			{E: TempID("1"), A: Ident("person/bff"), V: TempID("2")},
+			{E: TempID("2"), A: Ident("person/name"), V: String("Stephen")},
+			{E: TempID("2"), A: Ident("person/age"), V: Int(47)},
+			{E: TempID("2"), A: Ident("person/bff"), V: TempID("1")},
+		},
+	}
+	res := db.Write(req)
+	assert.NoError(t, res.Error)
+	donald := res.TempIDs[TempID("1")]
+	stephen := res.TempIDs[TempID("2")]
+
+	t.Run("in one graph", func(t *testing.T) {
+		assembler := NewAssembler(analyzer, res.Snapshot)
+		person, err := Assemble[viewPerson](assembler, donald)
+		assert.NoError(t, err)
+		assert.Equal(t, 48, person.Age)
+		assert.Equal(t, viewNamed{ID: uint64(stephen), Name: "Stephen", BFF: person.BFF.BFF}, *person.BFF)
+		// The views of each entity are distinct, but shared within the graph.
+		assert.Equal(t, uint64(donald), person.BFF.BFF.ID)
+		assert.Equal(t, 48, person.BFF.BFF.Age)
+		assert.Same(t, person.BFF, person.BFF.BFF.BFF)
+	})
+
+	t.Run("in one assembler", func(t *testing.T) {
+		assembler := NewAssembler(analyzer, res.Snapshot)
+		person, err := Assemble[viewPerson](assembler, donald)
+		assert.NoError(t, err)
+		assert.Equal(t, "Donald", person.Name)
+		named, err := Assemble[viewNamed](assembler, donald)
+		assert.NoError(t, err)
+		assert.Equal(t, "Donald", named.Name)
+		assert.Equal(t, 47, named.BFF.Age)
+		named, err = Assemble[viewNamed](assembler, stephen)
+		assert.NoError(t, err)
+		assert.Equal(t, "Stephen", named.Name)
+	})
+}
+
+func TestAnalyzerAcrossSnapshots(t *testing.T) {
+	type Person struct {
+		Name string `attr:"person/name"`
+		Age  int    `attr:"person/age"`
+	}
+	type Named struct {
+		Name string `attr:"person/name"`
+	}
+
+	analyzer, db := buildComponents(t, Named{})
+	res := db.Write(Request{Claims: []Claim{{E: TempID("1"), A: Ident("person/name"), V: String("Donald")}}})
+	assert.NoError(t, res.Error)
+	donald := res.TempIDs[TempID("1")]
+	person, err := Assemble[Person](NewAssembler(analyzer, res.Snapshot), donald)
+	assert.NoError(t, err)
+	assert.Equal(t, Person{Name: "Donald"}, *person)
+
+	// Attributes declared later resolve through the same analyzer.
+	claims, err := schemas.Analyze(reflect.TypeFor[Person]())
+	assert.NoError(t, err)
+	res = db.Write(Request{Claims: claims})
+	assert.NoError(t, res.Error)
+	res = db.Write(Request{Claims: []Claim{{E: donald, A: Ident("person/age"), V: Int(48)}}})
+	assert.NoError(t, res.Error)
+	person, err = Assemble[Person](NewAssembler(analyzer, res.Snapshot), donald)
+	assert.NoError(t, err)
+	assert.Equal(t, Person{Name: "Donald", Age: 48}, *person)
+}
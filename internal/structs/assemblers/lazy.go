@@ -0,0 +1,66 @@
+package assemblers
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/dball/destructive/internal/structs/models"
+	. "github.com/dball/destructive/internal/types"
+)
+
+// Lazy is a ref field type that holds the referent entity of a T, which is assembled on
+// first access from the snapshot in which the referring struct was assembled, rather than
+// with the referring struct. Copies of a lazy field share the referent.
+type Lazy[T any] struct {
+	ref *lazyRef[T]
+}
+
+var _ models.LazyRef = Lazy[struct{}]{}
+
+type lazyRef[T any] struct {
+	id       ID
+	analyzer models.Analyzer
+	snapshot Snapshot
+	options  Options
+	once     sync.Once
+	entity   *T
+	err      error
+}
+
+// lazyBinder binds a lazy field to its referent.
+type lazyBinder interface {
+	bind(analyzer models.Analyzer, snapshot Snapshot, options Options, id ID)
+}
+
+func (lazy *Lazy[T]) bind(analyzer models.Analyzer, snapshot Snapshot, options Options, id ID) {
+	lazy.ref = &lazyRef[T]{id: id, analyzer: analyzer, snapshot: snapshot, options: options}
+}
+
+// RefID returns the id of the referent entity, or zero if there is none.
+func (lazy Lazy[T]) RefID() (id ID) {
+	if lazy.ref != nil {
+		id = lazy.ref.id
+	}
+	return
+}
+
+// ReferentType returns the struct type of the referent.
+func (lazy Lazy[T]) ReferentType() reflect.Type {
+	return reflect.TypeFor[T]()
+}
+
+// Get returns the referent, assembling it on the first call. The referent is nil if there
+// is none. The referent is assembled independently of the referring struct, so it does not
+// share pointers with it.
+func (lazy Lazy[T]) Get() (entity *T, err error) {
+	ref := lazy.ref
+	if ref == nil {
+		return
+	}
+	ref.once.Do(func() {
+		as := NewAssemblerWith(ref.analyzer, ref.snapshot, ref.options)
+		ref.entity, ref.err = Assemble[T](as, ref.id)
+	})
+	entity, err = ref.entity, ref.err
+	return
+}
@@ -0,0 +1,150 @@
+// Package converters provides for the conversion of structs from one type to another
+// through their datums, without a durable database.
+package converters
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/dball/destructive/internal/database"
+	"github.com/dball/destructive/internal/structs/assemblers"
+	"github.com/dball/destructive/internal/structs/models"
+	"github.com/dball/destructive/internal/structs/schemas"
+	"github.com/dball/destructive/internal/structs/shredder"
+	"github.com/dball/destructive/internal/sys"
+	. "github.com/dball/destructive/internal/types"
+)
+
+// Options are the predicates that the specs and attribute constraints of the structs may
+// name, by their names.
+type Options struct {
+	Predicates      map[string]Predicate
+	ValuePredicates map[string]ValuePredicate
+}
+
+// Convert writes the structs and their schemas to an ephemeral database and assembles an
+// instance of To for each of them, in the same order. The instances share the pointers of
+// the structs they reference in common. The database allocates ids above those the
+// structs give, which it keeps, and entities without given ids have none in the
+// instances. The referents of lookup refs are created as needed. An instance is nil if
+// its struct yields no datums.
+func Convert[To any](analyzer models.Analyzer, froms []any, options Options) (tos []*To, err error) {
+	_, modelErr := analyzer.Analyze(reflect.TypeFor[To]())
+	if modelErr != nil {
+		err = modelErr
+		return
+	}
+	req, ids, err := shredder.NewShredder(analyzer).Shred(shredder.Document{Assertions: froms})
+	if err != nil {
+		return
+	}
+	// The allocated ids must not collide with the given ids.
+	var last ID
+	for _, claim := range req.Claims {
+		if e, ok := claim.E.(ID); ok {
+			last = max(last, e)
+		}
+		if v, ok := claim.V.(ID); ok {
+			last = max(last, v)
+		}
+	}
+	db := database.NewIndexDatabaseAbove(16, 0, 0, last)
+	done := map[reflect.Type]Void{}
+	for _, from := range froms {
+		typ := reflect.TypeOf(from)
+		if typ == nil {
+			continue
+		}
+		if typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		if _, ok := done[typ]; ok {
+			continue
+		}
+		done[typ] = Void{}
+		claims, schemaErr := schemas.AnalyzeWith(analyzer.Registry(), typ)
+		if schemaErr != nil {
+			err = schemaErr
+			return
+		}
+		if res := db.Write(Request{Claims: claims}); res.Error != nil {
+			err = res.Error
+			return
+		}
+	}
+	err = writeReferents(db, req.Claims)
+	if err != nil {
+		return
+	}
+	req.Predicates = options.Predicates
+	req.ValuePredicates = options.ValuePredicates
+	res := db.Write(req)
+	if res.Error != nil {
+		err = res.Error
+		return
+	}
+	// The ephemeral database's attribute ids are its own, so it has its own analyzer.
+	transient := func(id ID) bool { return id > last }
+	as := assemblers.NewAssemblerWith(models.BuildRegistryAnalyzer(analyzer.Registry()), res.Snapshot, assemblers.Options{Transient: transient})
+	tos = make([]*To, len(ids))
+	for i, e := range ids {
+		id, ok := e.(ID)
+		if !ok {
+			id = res.TempIDs[e.(TempID)]
+		}
+		if id == 0 {
+			continue
+		}
+		var to *To
+		to, err = assemblers.Assemble[To](as, id)
+		if err != nil {
+			return
+		}
+		tos[i] = to
+	}
+	return
+}
+
+// writeReferents writes an entity for each lookup ref value of the claims, declaring the
+// lookup attributes the schemas did not as unique identities.
+func writeReferents(db Database, claims []Claim) (err error) {
+	snapshot := db.Read()
+	var attrs, referents []Claim
+	seen := map[LookupRef]Void{}
+	declared := map[Ident]Void{}
+	for _, claim := range claims {
+		ref, ok := claim.V.(LookupRef)
+		if !ok || ref.V == nil {
+			continue
+		}
+		if _, ok := seen[ref]; ok {
+			continue
+		}
+		seen[ref] = Void{}
+		a, ok := ref.A.(Ident)
+		if !ok {
+			continue
+		}
+		if _, ok := declared[a]; !ok && snapshot.ResolveIdent(a) == 0 {
+			declared[a] = Void{}
+			attr := TempID("attr/" + string(a))
+			attrs = append(attrs,
+				Claim{E: attr, A: sys.DbIdent, V: String(a)},
+				Claim{E: attr, A: sys.AttrType, V: sys.ValueType(ref.V)},
+				Claim{E: attr, A: sys.AttrUnique, V: sys.AttrUniqueIdentity},
+			)
+		}
+		referents = append(referents, Claim{E: TempID("referent/" + strconv.Itoa(len(seen))), A: a, V: ref.V.(VRef)})
+	}
+	// The attributes must be declared before their idents may be claimed.
+	for _, claims := range [][]Claim{attrs, referents} {
+		if len(claims) == 0 {
+			continue
+		}
+		if res := db.Write(Request{Claims: claims}); res.Error != nil {
+			err = res.Error
+			return
+		}
+	}
+	return
+}
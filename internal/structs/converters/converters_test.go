@@ -0,0 +1,134 @@
+package converters
+
+import (
+	"testing"
+
+	"github.com/dball/destructive/internal/structs/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvert(t *testing.T) {
+	type Pet struct {
+		Name string `attr:"pet/name"`
+	}
+	type Person struct {
+		ID    uint64         `attr:"sys/db/id"`
+		Name  string         `attr:"person/name"`
+		BFF   *Person        `attr:"person/bff"`
+		Pets  []Pet          `attr:"person/pets"`
+		Toys  map[string]Pet `attr:"person/toys,key=pet/name"`
+		Tags  []string       `attr:"person/tags,value=person/tag"`
+		Notes string
+	}
+	type PetView struct {
+		Name string `attr:"pet/name"`
+	}
+	type PersonView struct {
+		ID   uint64              `attr:"sys/db/id"`
+		Name string              `attr:"person/name"`
+		BFF  *PersonView         `attr:"person/bff"`
+		Pets []PetView           `attr:"person/pets"`
+		Toys map[string]*PetView `attr:"person/toys,key=pet/name"`
+		Tags []string            `attr:"person/tags,value=person/tag"`
+	}
+
+	analyzer := models.BuildCachingAnalyzer()
+
+	t.Run("graph", func(t *testing.T) {
+		donald := &Person{Name: "Donald", Notes: "dropped"}
+		stephen := &Person{ID: 0x200000, Name: "Stephen", BFF: donald}
+		donald.BFF = stephen
+		donald.Pets = []Pet{{Name: "Momo"}, {Name: "Rex"}}
+		donald.Toys = map[string]Pet{"ball": {Name: "ball"}}
+		donald.Tags = []string{"b", "a"}
+		views, err := Convert[PersonView](analyzer, []any{donald, stephen}, Options{})
+		assert.NoError(t, err)
+		if assert.Len(t, views, 2) {
+			view := views[0]
+			assert.Zero(t, view.ID)
+			assert.Equal(t, "Donald", view.Name)
+			assert.Equal(t, []PetView{{Name: "Momo"}, {Name: "Rex"}}, view.Pets)
+			assert.Equal(t, map[string]*PetView{"ball": {Name: "ball"}}, view.Toys)
+			assert.Equal(t, []string{"b", "a"}, view.Tags)
+			// Ids are kept, and referenced structs are shared.
+			assert.Equal(t, uint64(0x200000), view.BFF.ID)
+			assert.Equal(t, "Donald", view.BFF.BFF.Name)
+			assert.Same(t, view.BFF, view.BFF.BFF.BFF)
+			assert.Equal(t, *view.BFF, *views[1])
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		type Note struct {
+			Text string
+		}
+		views, err := Convert[PersonView](analyzer, []any{Person{Notes: "dropped"}, Note{Text: "dropped"}}, Options{})
+		assert.NoError(t, err)
+		if assert.Len(t, views, 2) {
+			// Zero values are recorded, but structs without attr fields have no datums.
+			assert.Equal(t, &PersonView{}, views[0])
+			assert.Nil(t, views[1])
+		}
+	})
+}
+
+func TestConvertRefs(t *testing.T) {
+	type Order struct {
+		Customer string `attr:"order/customer,lookup=person/email"`
+		Seller   uint64 `attr:"order/seller,ref"`
+	}
+	type OrderView struct {
+		Customer *string `attr:"order/customer,lookup=person/email"`
+		Seller   *uint64 `attr:"order/seller,ref"`
+	}
+
+	analyzer := models.BuildCachingAnalyzer()
+	views, err := Convert[OrderView](analyzer, []any{Order{Customer: "a@example.com", Seller: 0x300000}}, Options{})
+	assert.NoError(t, err)
+	customer := "a@example.com"
+	seller := uint64(0x300000)
+	assert.Equal(t, []*OrderView{{Customer: &customer, Seller: &seller}}, views)
+}
+
+func TestConvertIdentities(t *testing.T) {
+	type Person struct {
+		Email string `attr:"person/email,identity"`
+		Name  string `attr:"person/name"`
+	}
+	type Order struct {
+		Customer string `attr:"order/customer,lookup=person/email"`
+	}
+	type PersonView struct {
+		Name string `attr:"person/name"`
+	}
+	type OrderView struct {
+		Customer *PersonView `attr:"order/customer"`
+	}
+
+	analyzer := models.BuildCachingAnalyzer()
+
+	t.Run("replaced", func(t *testing.T) {
+		type Named struct {
+			ID   uint64 `attr:"sys/db/id"`
+			Name string `attr:"person/name"`
+		}
+		froms := []any{Named{ID: 0x200000, Name: "Donald"}, Named{ID: 0x200000, Name: "Stephen"}}
+		views, err := Convert[PersonView](analyzer, froms, Options{})
+		assert.NoError(t, err)
+		// The last claim of a cardinality one attribute replaces the others.
+		if assert.Len(t, views, 2) {
+			assert.Equal(t, views[0], views[1])
+			assert.Equal(t, "Stephen", views[0].Name)
+		}
+	})
+
+	t.Run("lookup", func(t *testing.T) {
+		froms := []any{Order{Customer: "a@example.com"}, Person{Email: "a@example.com", Name: "Donald"}}
+		views, err := Convert[OrderView](analyzer, froms, Options{})
+		assert.NoError(t, err)
+		// The person is merged by its identity into the referent of the lookup ref.
+		if assert.Len(t, views, 2) {
+			assert.Equal(t, &OrderView{Customer: &PersonView{Name: "Donald"}}, views[0])
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package models
+
+import (
+	"encoding"
+	"reflect"
+	"time"
+
+	"github.com/dball/destructive/internal/sys"
+	. "github.com/dball/destructive/internal/types"
+)
+
+// Codec converts between the values of a field type and the values of one of the
+// scalar system types.
+type Codec interface {
+	// AttrType returns the system type of the encoded values.
+	AttrType() ID
+	// Encode converts the field value to a system value.
+	Encode(v reflect.Value) (value Value, err error)
+	// Decode sets the field value, which must be settable, from a system value.
+	Decode(value Value, v reflect.Value) (err error)
+}
+
+// ValueCodec may be implemented by field types to record their values as system values.
+// EncodeValue must return a string, int64, bool, float64 or time.Time, the same for all
+// values of the type including the zero value, from which the attribute type is learned.
+// DecodeValue receives a value of that type and must have a pointer receiver.
+type ValueCodec interface {
+	EncodeValue() (v any, err error)
+	DecodeValue(v any) (err error)
+}
+
+var valueCodecType = reflect.TypeFor[ValueCodec]()
+var textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// RegisterCodec registers the codec for the field type, taking precedence over any
+// codec the type itself implements.
+func (registry *Registry) RegisterCodec(typ reflect.Type, codec Codec) (err error) {
+	if typ == nil || codec == nil || !sys.ValidAttrType(codec.AttrType()) || codec.AttrType() == sys.AttrTypeRef {
+		err = NewError("models.invalidCodec", "type", typ)
+		return
+	}
+	if _, ok := registry.codecs[typ]; ok {
+		err = NewError("models.duplicateCodec", "type", typ)
+		return
+	}
+	registry.codecs[typ] = codec
+	return
+}
+
+// Codec returns the codec for the field type, if any: the registered codec, or else a
+// codec for the type's ValueCodec implementation. Scalar types are not given codecs by
+// this method, but other types that implement encoding.TextMarshaler and
+// encoding.TextUnmarshaler are recorded as strings. A nil registry has only the
+// implemented codecs.
+func (registry *Registry) Codec(typ reflect.Type) (codec Codec, ok bool) {
+	if registry != nil {
+		codec, ok = registry.codecs[typ]
+		if ok {
+			return
+		}
+	}
+	ptrType := reflect.PointerTo(typ)
+	switch {
+	case typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Interface:
+	case typ.Implements(valueCodecType) || ptrType.Implements(valueCodecType):
+		zero, err := receiver(reflect.Zero(typ), valueCodecType).(ValueCodec).EncodeValue()
+		if err != nil {
+			return
+		}
+		attrType := attrTypeOf(zero)
+		if attrType == 0 {
+			return
+		}
+		codec, ok = valueCodec{attrType: attrType}, true
+	case AttrTypeForScalarKind(typ) != 0:
+	case (typ.Implements(textMarshalerType) || ptrType.Implements(textMarshalerType)) && ptrType.Implements(textUnmarshalerType):
+		codec, ok = textCodec{}, true
+	}
+	return
+}
+
+// checkCodec rejects the codec of a type that implements ValueCodec with a DecodeValue
+// method on its value receiver, which would decode into a copy of the field value.
+func checkCodec(codec Codec, typ reflect.Type) (err error) {
+	if _, ok := codec.(valueCodec); !ok {
+		return
+	}
+	if _, ok := typ.MethodByName("DecodeValue"); ok {
+		err = NewError("models.invalidCodec", "type", typ)
+	}
+	return
+}
+
+// receiver returns the value, or a pointer to a copy of it, as the interface which one
+// of them implements.
+func receiver(v reflect.Value, iface reflect.Type) any {
+	if v.Type().Implements(iface) {
+		return v.Interface()
+	}
+	if v.CanAddr() {
+		return v.Addr().Interface()
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr.Interface()
+}
+
+// attrTypeOf returns the attribute type of the go value, if it is one that converts to
+// a system value.
+func attrTypeOf(x any) (attrType ID) {
+	switch x.(type) {
+	case string:
+		attrType = sys.AttrTypeString
+	case int64:
+		attrType = sys.AttrTypeInt
+	case bool:
+		attrType = sys.AttrTypeBool
+	case float64:
+		attrType = sys.AttrTypeFloat
+	case time.Time:
+		attrType = sys.AttrTypeInst
+	}
+	return
+}
+
+// ToValue converts a string, int64, bool, float64 or time.Time to its system value.
+func ToValue(x any) (value Value, ok bool) {
+	ok = true
+	switch x := x.(type) {
+	case string:
+		value = String(x)
+	case int64:
+		value = Int(x)
+	case bool:
+		value = Bool(x)
+	case float64:
+		value = Float(x)
+	case time.Time:
+		value = Inst(x)
+	default:
+		ok = false
+	}
+	return
+}
+
+// FromValue converts a scalar system value to its go value.
+func FromValue(value Value) (x any) {
+	switch value := value.(type) {
+	case String:
+		x = string(value)
+	case Int:
+		x = int64(value)
+	case Bool:
+		x = bool(value)
+	case Float:
+		x = float64(value)
+	case Inst:
+		x = time.Time(value)
+	}
+	return
+}
+
+type valueCodec struct {
+	attrType ID
+}
+
+func (codec valueCodec) AttrType() ID {
+	return codec.attrType
+}
+
+func (codec valueCodec) Encode(v reflect.Value) (value Value, err error) {
+	x, err := receiver(v, valueCodecType).(ValueCodec).EncodeValue()
+	if err != nil {
+		return
+	}
+	value, ok := ToValue(x)
+	if !ok || attrTypeOf(x) != codec.attrType {
+		err = NewError("models.invalidEncodedValue", "type", v.Type(), "value", x)
+	}
+	return
+}
+
+func (codec valueCodec) Decode(value Value, v reflect.Value) (err error) {
+	err = receiver(v, valueCodecType).(ValueCodec).DecodeValue(FromValue(value))
+	return
+}
+
+type textCodec struct{}
+
+func (codec textCodec) AttrType() ID {
+	return sys.AttrTypeString
+}
+
+func (codec textCodec) Encode(v reflect.Value) (value Value, err error) {
+	text, err := receiver(v, textMarshalerType).(encoding.TextMarshaler).MarshalText()
+	if err == nil {
+		value = String(text)
+	}
+	return
+}
+
+func (codec textCodec) Decode(value Value, v reflect.Value) (err error) {
+	s, ok := value.(String)
+	if !ok {
+		err = NewError("models.invalidDecodedValue", "type", v.Type(), "value", value)
+		return
+	}
+	err = v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	return
+}
+
+// FuncCodec is a codec for a field type defined by a pair of functions that convert
+// its values to and from a string, int64, bool, float64 or time.Time.
+type FuncCodec struct {
+	attrType ID
+	encode   func(x any) (y any, err error)
+	decode   func(y any) (x any, err error)
+}
+
+var _ Codec = FuncCodec{}
+
+// NewFuncCodec returns a codec for the field type from the conversion functions. The
+// attribute type is learned by encoding the type's zero value.
+func NewFuncCodec(typ reflect.Type, encode func(x any) (y any, err error), decode func(y any) (x any, err error)) (codec FuncCodec, err error) {
+	zero, err := encode(reflect.Zero(typ).Interface())
+	if err != nil {
+		return
+	}
+	codec = FuncCodec{attrType: attrTypeOf(zero), encode: encode, decode: decode}
+	if codec.attrType == 0 {
+		err = NewError("models.invalidCodec", "type", typ, "value", zero)
+	}
+	return
+}
+
+func (codec FuncCodec) AttrType() ID {
+	return codec.attrType
+}
+
+func (codec FuncCodec) Encode(v reflect.Value) (value Value, err error) {
+	y, err := codec.encode(v.Interface())
+	if err != nil {
+		return
+	}
+	value, ok := ToValue(y)
+	if !ok || attrTypeOf(y) != codec.attrType {
+		err = NewError("models.invalidEncodedValue", "type", v.Type(), "value", y)
+	}
+	return
+}
+
+func (codec FuncCodec) Decode(value Value, v reflect.Value) (err error) {
+	x, err := codec.decode(FromValue(value))
+	if err != nil {
+		return
+	}
+	xv := reflect.ValueOf(x)
+	if !xv.IsValid() || !xv.Type().AssignableTo(v.Type()) {
+		err = NewError("models.invalidDecodedValue", "type", v.Type(), "value", x)
+		return
+	}
+	v.Set(xv)
+	return
+}
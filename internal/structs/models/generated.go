@@ -0,0 +1,58 @@
+package models
+
+import (
+	"reflect"
+	"sync"
+
+	. "github.com/dball/destructive/internal/types"
+)
+
+// Generated holds the functions generated for a struct type, which declare its attributes,
+// and shred and assemble its instances, without reflection. They support structs whose
+// attr fields hold ids, or scalar values or pointers to them.
+type Generated struct {
+	// Attrs are the attributes of the struct's attr fields, other than its id field.
+	Attrs []Attr
+	// Shred calls emit with the ident and value of each attr field of the struct, which
+	// is given as a struct or a pointer to one. Nil pointers and ignored empty values are
+	// omitted, and the id is emitted as an ID. It returns an error if a field's value is
+	// not a valid value.
+	Shred func(x any, emit func(ident Ident, v Value)) (err error)
+	// Assemble sets the attr field of the struct pointer with the ident to the value,
+	// reporting whether the struct has the field and the value has its type.
+	Assemble func(x any, ident Ident, v Value) (ok bool)
+}
+
+var generatedLock sync.RWMutex
+
+// generated are the registered generated functions, indexed by their struct types.
+var generated = map[reflect.Type]*Generated{}
+
+// RegisterGenerated registers the generated functions for the struct type, which the
+// struct models of the type then hold. A struct type may be registered only once.
+func RegisterGenerated(typ reflect.Type, gen Generated) (err error) {
+	if typ == nil || typ.Kind() != reflect.Struct || typ == TimeType {
+		err = NewError("models.invalidGeneratedType", "type", typ)
+		return
+	}
+	if gen.Shred == nil || gen.Assemble == nil {
+		err = NewError("models.invalidGenerated", "type", typ)
+		return
+	}
+	generatedLock.Lock()
+	defer generatedLock.Unlock()
+	if _, ok := generated[typ]; ok {
+		err = NewError("models.duplicateGeneratedType", "type", typ)
+		return
+	}
+	generated[typ] = &gen
+	return
+}
+
+// lookupGenerated returns the generated functions for the struct type, if any.
+func lookupGenerated(typ reflect.Type) (gen *Generated) {
+	generatedLock.RLock()
+	defer generatedLock.RUnlock()
+	gen = generated[typ]
+	return
+}
@@ -3,40 +3,119 @@ package models
 
 import (
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dball/destructive/internal/sys"
 	. "github.com/dball/destructive/internal/types"
 )
 
-// Analyzer analyzes struct types for attr tags and returns models thereof.
+// Analyzer analyzes struct types for attr tags and returns models thereof. Analyzers are
+// safe for concurrent use.
 type Analyzer interface {
 	// Analyze returns a struct model for the given type.
 	Analyze(typ reflect.Type) (model StructModel, err error)
+	// ResolveAttr returns the attr field of the model bound to the attribute with the given
+	// id in the snapshot, if any, by its ident or one of its aliases. The resolutions are
+	// cached by attribute id and alias version, so an analyzer must be used with the
+	// snapshots of only one database.
+	ResolveAttr(model StructModel, a ID, snapshot Snapshot) (attr AttrFieldModel, ok bool)
+	// Registry returns the type bindings available to the models, which may be nil.
+	Registry() *Registry
+}
+
+// resolvedAttr is the key of an attribute id resolved for a struct type in the snapshots
+// of an alias version.
+type resolvedAttr struct {
+	typ          reflect.Type
+	a            ID
+	aliasVersion uint64
 }
 
 type cachingAnalyzer struct {
-	types map[reflect.Type]*StructModel
+	// lock guards the types
+	lock     sync.RWMutex
+	types    map[reflect.Type]StructModel
+	registry *Registry
+	// resolved are the indexes of the attr fields bound to the attribute ids of the struct
+	// types, or -1 for attributes without fields.
+	resolved sync.Map
+	// aliasVersion is the latest alias version of the resolutions, whose predecessors'
+	// are discarded.
+	aliasVersion atomic.Uint64
 }
 
 var _ Analyzer = (*cachingAnalyzer)(nil)
 
 func (analyzer *cachingAnalyzer) Analyze(typ reflect.Type) (model StructModel, err error) {
-	mp := analyzer.types[typ]
-	if mp != nil {
-		model = *mp
+	analyzer.lock.RLock()
+	model, ok := analyzer.types[typ]
+	analyzer.lock.RUnlock()
+	if ok {
 		return
 	}
-	model, err = Analyze(typ)
+	model, err = AnalyzeWith(analyzer.registry, typ)
 	if err != nil {
-		analyzer.types[typ] = &model
+		return
+	}
+	analyzer.lock.Lock()
+	analyzer.types[typ] = model
+	analyzer.lock.Unlock()
+	return
+}
+
+func (analyzer *cachingAnalyzer) ResolveAttr(model StructModel, a ID, snapshot Snapshot) (attr AttrFieldModel, ok bool) {
+	version := snapshot.AliasVersion()
+	key := resolvedAttr{model.Type, a, version}
+	i, cached := analyzer.resolved.Load(key)
+	if !cached {
+		if latest := analyzer.aliasVersion.Load(); version > latest && analyzer.aliasVersion.CompareAndSwap(latest, version) {
+			analyzer.resolved.Clear()
+		}
+		i = resolveAttr(model, a, snapshot)
+		analyzer.resolved.Store(key, i)
+	}
+	if i := i.(int); i >= 0 {
+		attr = model.AttrFields[i]
+		ok = true
+	}
+	return
+}
+
+// resolveAttr returns the index of the attr field of the model bound to the attribute with
+// the given id in the snapshot by its ident or one of its aliases, or -1 if none is.
+func resolveAttr(model StructModel, a ID, snapshot Snapshot) (i int) {
+	i, found := model.attrs[snapshot.ResolveAttrIdent(a)]
+	if found {
+		return
+	}
+	i = -1
+	for j, field := range model.AttrFields {
+		if !field.Reverse && snapshot.ResolveIdent(field.Ident) == a {
+			i = j
+			break
+		}
 	}
 	return
 }
 
+func (analyzer *cachingAnalyzer) Registry() *Registry {
+	return analyzer.registry
+}
+
 // BuildCachingAnalyzer returns an analyzer with a cache of type models.
 func BuildCachingAnalyzer() Analyzer {
-	return &cachingAnalyzer{}
+	return BuildRegistryAnalyzer(nil)
+}
+
+// BuildRegistryAnalyzer returns an analyzer with a cache of type models and the given
+// type bindings.
+func BuildRegistryAnalyzer(registry *Registry) Analyzer {
+	return &cachingAnalyzer{types: map[reflect.Type]StructModel{}, registry: registry}
 }
 
 // StructModel models a struct that has fields bound to attributes, whose instances
@@ -46,17 +125,27 @@ type StructModel struct {
 	Type reflect.Type
 	// AttrFields are the fields bound to attributes, in field order.
 	AttrFields []AttrFieldModel
+	// Generated holds the functions registered for the struct type, if any, which are
+	// preferred to reflection on the fields.
+	Generated *Generated
+	// Spec is the ident of the spec to which the struct's entities conform, if any of its
+	// attr fields are required or its id field names predicates. This is given by the id
+	// field's spec directive, or else is the struct type's package path and name, which
+	// must name only that type.
+	Spec Ident
+	// Preds are the names of the predicates of the struct's spec, given by the id field's
+	// pred directives.
+	Preds []string
+	// attrs are the indexes of the attr fields by their idents, excluding reverse fields.
+	attrs map[Ident]int
 }
 
-// Attr returns the attribute field model with the given ident, if any.
+// Attr returns the attribute field model with the given ident, if any. Reverse fields
+// are not bound to the attributes of the struct's entity and are not returned.
 func (model StructModel) Attr(ident Ident) (attr AttrFieldModel, ok bool) {
-	// TODO could index these by ident
-	for _, a := range model.AttrFields {
-		if a.Ident == ident {
-			attr = a
-			ok = true
-			break
-		}
+	i, ok := model.attrs[ident]
+	if ok {
+		attr = model.AttrFields[i]
 	}
 	return
 }
@@ -69,6 +158,8 @@ type AttrFieldModel struct {
 	Index int
 	// FieldType is the field's go type.
 	FieldType reflect.Type
+	// Kind is the kind of the field's go type.
+	Kind reflect.Kind
 	// Unique is the ID of the uniqueness ident. This may be zero.
 	Unique ID
 	// Type is the ID of the type ident. This may not be zero.
@@ -79,56 +170,164 @@ type AttrFieldModel struct {
 	MapKey Ident
 	// CollValue is the ident for the scalar values in this field's slice entries.
 	CollValue Ident
+	// Ref indicates that the field holds the ids of referent entities rather than the
+	// referent structs.
+	Ref bool
+	// Lookup is the ident of the unique attribute whose value on the referent entity the
+	// field holds in place of the referent struct.
+	Lookup Ident
+	// Reverse indicates that the field holds the referrer entities whose values for the
+	// ref attribute are the struct's entity, rather than the struct entity's own values.
+	Reverse bool
+	// Lazy indicates that the field is a LazyRef, holding the referent id from which its
+	// struct is assembled on demand.
+	Lazy bool
+	// Version indicates that the field holds the entity's version, which a write of the
+	// struct requires to be stored and increments.
+	Version bool
+	// Required indicates that the struct's entities must have a value for the attr.
+	Required bool
+	// Spec is the ident of the struct's spec, given on its id field.
+	Spec Ident
+	// Preds are the names of the predicates of the struct's spec if given on its id field,
+	// or else of the value predicates of the attr.
+	Preds []string
+	// Constraints restrict the values of the attr, as given by the constraint directives
+	// and preds of the field. This is nil if there are none.
+	Constraints *Constraints
+	// bounds are the constraint directives of the field.
+	bounds []string
+	// Codec converts the field's scalar values, or its slice's scalar values, to and from
+	// system values. This is nil for fields whose types are scalar system types.
+	Codec Codec
 }
 
 // IsMap indicates that the field value is a map.
 func (attr AttrFieldModel) IsMap() bool {
-	return attr.FieldType.Kind() == reflect.Map
+	return attr.Kind == reflect.Map
 }
 
 // IsSlice indicates that the field value is a slice.
 func (attr AttrFieldModel) IsSlice() bool {
-	return attr.FieldType.Kind() == reflect.Slice
+	return attr.Kind == reflect.Slice
 }
 
 // IsPointer indicates that the field value is a pointer.
 func (attr AttrFieldModel) IsPointer() bool {
-	return attr.FieldType.Kind() == reflect.Pointer
+	return attr.Kind == reflect.Pointer
+}
+
+// IsInterface indicates that the field value is an interface, whose concrete types
+// are resolved through a registry.
+func (attr AttrFieldModel) IsInterface() bool {
+	return attr.Kind == reflect.Interface
 }
 
+// LazyRef is implemented by field types that hold the id of a referent entity whose struct
+// is assembled on demand rather than with the referring struct.
+type LazyRef interface {
+	// RefID returns the id of the referent entity, or zero if there is none.
+	RefID() ID
+	// ReferentType returns the struct type of the referent.
+	ReferentType() reflect.Type
+}
+
+var lazyRefType = reflect.TypeFor[LazyRef]()
+
 // Analyze builds a struct model for the given struct type.
 func Analyze(typ reflect.Type) (model StructModel, err error) {
+	return AnalyzeWith(nil, typ)
+}
+
+// AnalyzeWith builds a struct model for the given struct type, resolving field codecs
+// through the registry.
+func AnalyzeWith(registry *Registry, typ reflect.Type) (model StructModel, err error) {
 	if typ.Kind() != reflect.Struct {
 		err = NewError("models.notStruct", "type", typ)
 		return
 	}
 	model.Type = typ
+	model.Generated = lookupGenerated(typ)
 	n := typ.NumField()
 	attrFields := make([]AttrFieldModel, 0, n)
+	model.attrs = make(map[Ident]int, n)
 	for i := range n {
 		fieldType := typ.Field(i)
-		attr, fieldErr := parseAttrField(fieldType)
+		attr, fieldErr := parseAttrField(registry, fieldType)
 		if fieldErr != nil {
 			err = fieldErr
 			return
 		}
+		if attr.Ident == "" {
+			continue
+		}
 		attr.Index = i
+		if _, ok := model.attrs[attr.Ident]; !ok && !attr.Reverse {
+			model.attrs[attr.Ident] = len(attrFields)
+		}
 		attrFields = append(attrFields, attr)
 	}
 	model.AttrFields = attrFields
+	specified := false
+	for _, attr := range attrFields {
+		if attr.Ident == sys.DbId {
+			model.Preds = append(model.Preds, attr.Preds...)
+		}
+		specified = specified || attr.Required || len(model.Preds) != 0
+	}
+	if !specified {
+		return
+	}
+	for _, attr := range attrFields {
+		if attr.Spec != "" {
+			model.Spec = attr.Spec
+		}
+	}
+	if model.Spec == "" {
+		model.Spec, err = defaultSpec(typ)
+	}
 	return
 }
 
+// defaultSpecs are the struct types whose specs are named by default, by their idents.
+var defaultSpecs sync.Map
+
+// defaultSpec returns the ident of the spec of a struct type without a spec directive,
+// which is its package path and name. Unnamed types, and distinct types of the same
+// package path and name, such as types declared in functions, must have spec directives
+// so their specs do not merge.
+func defaultSpec(typ reflect.Type) (spec Ident, err error) {
+	if typ.Name() == "" {
+		err = NewError("models.specRequired", "type", typ)
+		return
+	}
+	spec = Ident(typ.PkgPath() + "." + typ.Name())
+	if extant, loaded := defaultSpecs.LoadOrStore(spec, typ); loaded && extant != typ {
+		err = NewError("models.specCollision", "type", typ, "spec", spec)
+		spec = ""
+	}
+	return
+}
+
+// AttrTypeForScalarKind returns the attribute type for the scalar type, or zero if the
+// type is not a scalar. Named types are scalars if their underlying kinds are. Unsigned
+// integers are recorded as ints, and byte arrays as strings.
 func AttrTypeForScalarKind(typ reflect.Type) (attrType ID) {
 	switch typ.Kind() {
 	case reflect.Bool:
 		attrType = sys.AttrTypeBool
-	case reflect.Int:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		attrType = sys.AttrTypeInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		attrType = sys.AttrTypeInt
 	case reflect.String:
 		attrType = sys.AttrTypeString
-	case reflect.Float64:
+	case reflect.Float32, reflect.Float64:
 		attrType = sys.AttrTypeFloat
+	case reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			attrType = sys.AttrTypeString
+		}
 	case reflect.Struct:
 		if TimeType == typ {
 			attrType = sys.AttrTypeInst
@@ -137,7 +336,64 @@ func AttrTypeForScalarKind(typ reflect.Type) (attrType ID) {
 	return
 }
 
-func parseAttrField(field reflect.StructField) (attr AttrFieldModel, err error) {
+func parseAttrField(registry *Registry, field reflect.StructField) (attr AttrFieldModel, err error) {
+	attr, err = parseAttrFieldType(registry, field)
+	if err != nil || attr.Ident == sys.DbId || (len(attr.bounds) == 0 && len(attr.Preds) == 0) {
+		return
+	}
+	attr.Constraints, err = parseConstraints(attr)
+	return
+}
+
+// parseConstraints returns the constraints given by the attr field's constraint
+// directives and preds, which must suit its type.
+func parseConstraints(attr AttrFieldModel) (constraints *Constraints, err error) {
+	constraints = &Constraints{Preds: attr.Preds}
+	for _, bound := range attr.bounds {
+		name, value, _ := strings.Cut(bound, "=")
+		var constraint ID
+		var parseErr error
+		switch {
+		case (name == "min" || name == "max") && attr.Type == sys.AttrTypeInst:
+			var t time.Time
+			t, parseErr = time.Parse(time.RFC3339, value)
+			inst := Inst(t)
+			if name == "min" {
+				constraint, constraints.MinInst = sys.AttrMinInst, &inst
+			} else {
+				constraint, constraints.MaxInst = sys.AttrMaxInst, &inst
+			}
+		case name == "min" || name == "max":
+			var f float64
+			f, parseErr = strconv.ParseFloat(value, 64)
+			x := Float(f)
+			if name == "min" {
+				constraint, constraints.Min = sys.AttrMin, &x
+			} else {
+				constraint, constraints.Max = sys.AttrMax, &x
+			}
+		case name == "minlen" || name == "maxlen":
+			var n int64
+			n, parseErr = strconv.ParseInt(value, 10, 64)
+			x := Int(n)
+			if name == "minlen" {
+				constraint, constraints.MinLength = sys.AttrMinLength, &x
+			} else {
+				constraint, constraints.MaxLength = sys.AttrMaxLength, &x
+			}
+		case name == "regex":
+			constraint = sys.AttrRegex
+			constraints.Regex, parseErr = regexp.Compile(value)
+		}
+		if parseErr != nil || !sys.ValidConstraint(constraint, attr.Type) {
+			err = NewError("models.invalidConstraintDirective", "ident", attr.Ident, "directive", bound)
+			return
+		}
+	}
+	return
+}
+
+func parseAttrFieldType(registry *Registry, field reflect.StructField) (attr AttrFieldModel, err error) {
 	tag, ok := field.Tag.Lookup("attr")
 	if !ok {
 		return
@@ -147,24 +403,132 @@ func parseAttrField(field reflect.StructField) (attr AttrFieldModel, err error)
 		return
 	}
 	attr.FieldType = field.Type
+	attr.Kind = field.Type.Kind()
 	if attr.Ident == sys.DbId {
+		if attr.Required {
+			err = NewError("models.invalidRequiredDirective", "tag", tag)
+		}
 		return
 	}
-	switch field.Type.Kind() {
-	case reflect.Bool:
-		attr.Type = sys.AttrTypeBool
-	case reflect.Int:
+	if attr.Spec != "" {
+		err = NewError("models.invalidSpecDirective", "tag", tag)
+		return
+	}
+	if attr.Version {
+		if attr.Ref || attr.Reverse || attr.Lookup != "" || attr.Unique != 0 || attr.IgnoreEmpty || attr.Required || attr.CollValue != "" || attr.MapKey != "" {
+			err = NewError("models.invalidVersionDirective", "tag", tag)
+			return
+		}
+		switch attr.Kind {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		default:
+			err = NewError("models.invalidVersionType", "tag", tag, "type", field.Type)
+			return
+		}
 		attr.Type = sys.AttrTypeInt
-	case reflect.String:
-		attr.Type = sys.AttrTypeString
-	case reflect.Float64:
-		attr.Type = sys.AttrTypeFloat
-	case reflect.Struct:
-		if TimeType == field.Type {
-			attr.Type = sys.AttrTypeInst
-		} else {
+		return
+	}
+	if attr.Reverse {
+		if attr.Ref || attr.Lookup != "" || attr.Unique != 0 || attr.Required || attr.CollValue != "" || attr.MapKey != "" {
+			err = NewError("models.invalidReverseDirective", "tag", tag)
+			return
+		}
+		structType := field.Type
+		if structType.Kind() == reflect.Slice {
+			structType = structType.Elem()
+		}
+		if structType.Kind() == reflect.Pointer {
+			structType = structType.Elem()
+		}
+		if field.Type.Kind() == reflect.Struct || structType.Kind() != reflect.Struct || structType == TimeType {
+			err = NewError("models.invalidReverseType", "tag", tag, "type", field.Type)
+			return
+		}
+		attr.Type = sys.AttrTypeRef
+		return
+	}
+	if field.Type.Implements(lazyRefType) {
+		if attr.Ref || attr.Lookup != "" || attr.CollValue != "" || attr.MapKey != "" {
+			err = NewError("models.invalidLazyDirective", "tag", tag)
+			return
+		}
+		// Lazy refs are held by value; a pointer to one also implements LazyRef.
+		if field.Type.Kind() != reflect.Struct {
+			err = NewError("models.invalidLazyType", "tag", tag, "type", field.Type)
+			return
+		}
+		referentType := reflect.Zero(field.Type).Interface().(LazyRef).ReferentType()
+		if referentType.Kind() != reflect.Struct || referentType == TimeType {
+			err = NewError("models.invalidLazyType", "tag", tag, "type", field.Type)
+			return
+		}
+		attr.Lazy = true
+		attr.Type = sys.AttrTypeRef
+		return
+	}
+	if attr.Lookup != "" {
+		scalarType := field.Type
+		if scalarType.Kind() == reflect.Pointer {
+			scalarType = scalarType.Elem()
+		}
+		codec, ok := registry.Codec(scalarType)
+		if ok {
+			if err = checkCodec(codec, scalarType); err != nil {
+				return
+			}
+		}
+		switch {
+		case attr.Ref || attr.CollValue != "" || attr.MapKey != "":
+			err = NewError("models.invalidLookupDirective", "tag", tag)
+			return
+		case ok:
+			attr.Codec = codec
+		case AttrTypeForScalarKind(scalarType) == 0:
+			err = NewError("models.invalidLookupType", "tag", tag, "type", field.Type)
+			return
+		}
+		attr.Type = sys.AttrTypeRef
+		return
+	}
+	if attr.Ref {
+		idType := field.Type
+		if idType.Kind() == reflect.Pointer || idType.Kind() == reflect.Slice {
+			idType = idType.Elem()
+		}
+		if idType.Kind() != reflect.Uint64 || attr.CollValue != "" || attr.MapKey != "" {
+			err = NewError("models.invalidRefType", "tag", tag, "type", field.Type)
+			return
+		}
+		attr.Type = sys.AttrTypeRef
+		return
+	}
+	scalarType := field.Type
+	switch {
+	case field.Type.Kind() == reflect.Pointer:
+		scalarType = field.Type.Elem()
+	case field.Type.Kind() == reflect.Slice && attr.CollValue != "":
+		scalarType = field.Type.Elem()
+	}
+	codec, ok := registry.Codec(scalarType)
+	if ok {
+		if err = checkCodec(codec, scalarType); err != nil {
+			return
+		}
+		attr.Codec = codec
+		attr.Type = codec.AttrType()
+		if attr.IsSlice() {
+			// The scalars are recorded on the slice's ref entities.
 			attr.Type = sys.AttrTypeRef
 		}
+		return
+	}
+	attr.Type = AttrTypeForScalarKind(field.Type)
+	if attr.Type != 0 {
+		return
+	}
+	switch field.Type.Kind() {
+	case reflect.Struct:
+		attr.Type = sys.AttrTypeRef
 	case reflect.Map:
 		attr.Type = sys.AttrTypeRef
 		if attr.MapKey == "" {
@@ -172,23 +536,17 @@ func parseAttrField(field reflect.StructField) (attr AttrFieldModel, err error)
 		}
 	case reflect.Slice:
 		attr.Type = sys.AttrTypeRef
+	case reflect.Interface:
+		attr.Type = sys.AttrTypeRef
 	case reflect.Pointer:
 		// This repeats the outer switch, but without the pointer, map or slice cases.
+		attr.Type = AttrTypeForScalarKind(field.Type.Elem())
+		if attr.Type != 0 {
+			return
+		}
 		switch field.Type.Elem().Kind() {
-		case reflect.Bool:
-			attr.Type = sys.AttrTypeBool
-		case reflect.Int:
-			attr.Type = sys.AttrTypeInt
-		case reflect.String:
-			attr.Type = sys.AttrTypeString
-		case reflect.Float64:
-			attr.Type = sys.AttrTypeFloat
 		case reflect.Struct:
-			if TimeType == field.Type.Elem() {
-				attr.Type = sys.AttrTypeInst
-			} else {
-				attr.Type = sys.AttrTypeRef
-			}
+			attr.Type = sys.AttrTypeRef
 		default:
 			err = NewError("models.invalidPointerType", "tag", tag, "type", field.Type, "kind", field.Type.Elem().Kind())
 		}
@@ -198,8 +556,28 @@ func parseAttrField(field reflect.StructField) (attr AttrFieldModel, err error)
 	return
 }
 
+// SplitAttrTag returns the ident and directives of an attr tag, which are separated by
+// commas, and in which an escaped comma, \, stands for a comma, e.g. in regexes.
+func SplitAttrTag(tag string) (parts []string) {
+	var part strings.Builder
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case tag[i] == '\\' && i+1 < len(tag) && tag[i+1] == ',':
+			part.WriteByte(',')
+			i++
+		case tag[i] == ',':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(tag[i])
+		}
+	}
+	parts = append(parts, part.String())
+	return
+}
+
 func parseAttrTag(tag string) (attr AttrFieldModel, err error) {
-	parts := strings.Split(tag, ",")
+	parts := SplitAttrTag(tag)
 	attr.Ident = Ident(parts[0])
 	n := len(parts)
 	for i := 1; i < n; i++ {
@@ -219,12 +597,30 @@ func parseAttrTag(tag string) (attr AttrFieldModel, err error) {
 			attr.Unique = sys.AttrUniqueValue
 		case "ignoreempty":
 			attr.IgnoreEmpty = true
+		case "ref":
+			attr.Ref = true
+		case "reverse":
+			attr.Reverse = true
+		case "version":
+			attr.Version = true
+		case "required":
+			attr.Required = true
 		default:
 			switch {
 			case strings.HasPrefix(part, "key="):
 				attr.MapKey = Ident(part[4:])
 			case strings.HasPrefix(part, "value="):
 				attr.CollValue = Ident(part[6:])
+			case strings.HasPrefix(part, "lookup="):
+				attr.Lookup = Ident(part[7:])
+			case strings.HasPrefix(part, "spec="):
+				attr.Spec = Ident(part[5:])
+			case strings.HasPrefix(part, "pred="):
+				attr.Preds = append(attr.Preds, part[5:])
+			case strings.HasPrefix(part, "min="), strings.HasPrefix(part, "max="),
+				strings.HasPrefix(part, "minlen="), strings.HasPrefix(part, "maxlen="),
+				strings.HasPrefix(part, "regex="):
+				attr.bounds = append(attr.bounds, part)
 			default:
 				err = NewError("models.invalidDirective", "tag", tag)
 				return
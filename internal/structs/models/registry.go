@@ -0,0 +1,105 @@
+package models
+
+import (
+	"reflect"
+
+	. "github.com/dball/destructive/internal/types"
+)
+
+// Registry holds type bindings that extend struct models beyond what can be learned
+// from the struct types alone. Registries are configured before use and are safe for
+// concurrent reads thereafter.
+type Registry struct {
+	// bindings are the registered concrete types, in registration order.
+	bindings []TypeBinding
+	// structs indexes the bindings by their struct types.
+	structs map[reflect.Type]int
+	// discriminators indexes the bindings by their discriminator values.
+	discriminators map[[2]Ident]int
+	// codecs are the registered field type codecs.
+	codecs map[reflect.Type]Codec
+}
+
+// TypeBinding binds a concrete struct type to the ident that is recorded under the
+// discriminator attribute on its entities, allowing its instances to populate
+// interface-typed ref fields.
+type TypeBinding struct {
+	// Type is the struct or struct pointer type as it is stored in interface values.
+	Type reflect.Type
+	// Attr is the ident of the discriminator attribute, which must be a ref attribute.
+	Attr Ident
+	// Ident is the discriminator value for the type.
+	Ident Ident
+}
+
+// StructType returns the struct type of the binding.
+func (binding TypeBinding) StructType() reflect.Type {
+	if binding.Type.Kind() == reflect.Pointer {
+		return binding.Type.Elem()
+	}
+	return binding.Type
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		structs:        map[reflect.Type]int{},
+		discriminators: map[[2]Ident]int{},
+		codecs:         map[reflect.Type]Codec{},
+	}
+}
+
+// Register binds the given struct or struct pointer type to its discriminator. A
+// struct type may be registered only once, and discriminators may not be shared.
+func (registry *Registry) Register(typ reflect.Type, attr Ident, ident Ident) (err error) {
+	binding := TypeBinding{Type: typ, Attr: attr, Ident: ident}
+	if typ == nil || binding.StructType().Kind() != reflect.Struct || binding.StructType() == TimeType {
+		err = NewError("models.invalidRegisteredType", "type", typ)
+		return
+	}
+	if attr == "" || ident == "" {
+		err = NewError("models.invalidDiscriminator", "type", typ, "attr", attr, "ident", ident)
+		return
+	}
+	structType := binding.StructType()
+	if _, ok := registry.structs[structType]; ok {
+		err = NewError("models.duplicateRegisteredType", "type", typ)
+		return
+	}
+	key := [2]Ident{attr, ident}
+	if _, ok := registry.discriminators[key]; ok {
+		err = NewError("models.duplicateDiscriminator", "type", typ, "attr", attr, "ident", ident)
+		return
+	}
+	registry.structs[structType] = len(registry.bindings)
+	registry.discriminators[key] = len(registry.bindings)
+	registry.bindings = append(registry.bindings, binding)
+	return
+}
+
+// Binding returns the binding for the given struct type, if any. A nil registry has
+// no bindings.
+func (registry *Registry) Binding(structType reflect.Type) (binding TypeBinding, ok bool) {
+	if registry == nil {
+		return
+	}
+	i, ok := registry.structs[structType]
+	if ok {
+		binding = registry.bindings[i]
+	}
+	return
+}
+
+// Implementations returns the bindings whose types implement the given interface
+// type, in registration order.
+func (registry *Registry) Implementations(iface reflect.Type) (bindings []TypeBinding) {
+	if registry == nil {
+		return
+	}
+	for _, binding := range registry.bindings {
+		if binding.Type.Implements(iface) {
+			bindings = append(bindings, binding)
+		}
+	}
+	return
+}
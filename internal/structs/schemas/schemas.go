@@ -3,6 +3,7 @@ package schemas
 
 import (
 	"reflect"
+	"slices"
 	"strconv"
 
 	"github.com/dball/destructive/internal/structs/models"
@@ -13,24 +14,97 @@ import (
 // Analyze builds the attribute claims necessary to record datums
 // about the given struct type.
 func Analyze(typ reflect.Type) (claims []Claim, err error) {
+	return AnalyzeWith(nil, typ)
+}
+
+// AnalyzeWith builds the attribute claims necessary to record datums about the given
+// struct type, resolving interface fields and discriminators through the registry.
+func AnalyzeWith(registry *models.Registry, typ reflect.Type) (claims []Claim, err error) {
 	done := map[reflect.Type]Void{typ: {}}
 	todo := map[reflect.Type]Void{typ: {}}
+	// discriminators are the discriminator attr and value idents already declared.
+	discriminators := map[Ident]Void{}
+	// declared are the tempids of the field attrs already declared by their idents, and
+	// reverses are the idents of reverse fields, in order, which are declared only if no
+	// field declares them.
+	declared := map[Ident]TempID{}
+	var reverses []Ident
+	// specs are the models of the types with specs, in order.
+	var specs []models.StructModel
 	var nextID uint64 = 1
 	todo[typ] = Void{}
 	for len(todo) > 0 {
 		for typ := range todo {
-			model, modelErr := models.Analyze(typ)
+			model, modelErr := models.AnalyzeWith(registry, typ)
 			if modelErr != nil {
 				err = modelErr
 				return
 			}
 			typeClaims := make([]Claim, 0, 3*len(model.AttrFields))
+			if model.Spec != "" {
+				specs = append(specs, model)
+			}
+			binding, ok := registry.Binding(typ)
+			if ok {
+				if _, ok := discriminators[binding.Attr]; !ok {
+					e := TempID(strconv.FormatUint(uint64(nextID), 10))
+					nextID++
+					typeClaims = append(typeClaims,
+						Claim{E: e, A: sys.DbIdent, V: String(binding.Attr)},
+						Claim{E: e, A: sys.AttrType, V: sys.AttrTypeRef},
+					)
+					discriminators[binding.Attr] = Void{}
+				}
+				if _, ok := discriminators[binding.Ident]; !ok {
+					e := TempID(strconv.FormatUint(uint64(nextID), 10))
+					nextID++
+					typeClaims = append(typeClaims, Claim{E: e, A: sys.DbIdent, V: String(binding.Ident)})
+					discriminators[binding.Ident] = Void{}
+				}
+			}
+			if model.Generated != nil {
+				for _, attr := range model.Generated.Attrs {
+					e := TempID(strconv.FormatUint(uint64(nextID), 10))
+					nextID++
+					declared[attr.Ident] = e
+					typeClaims = append(typeClaims,
+						Claim{E: e, A: sys.DbIdent, V: String(attr.Ident)},
+						Claim{E: e, A: sys.AttrType, V: attr.Type},
+					)
+					if attr.Unique != 0 {
+						typeClaims = append(typeClaims, Claim{E: e, A: sys.AttrUnique, V: attr.Unique})
+					}
+					// The constraints are given by the fields' directives.
+					if field, ok := model.Attr(attr.Ident); ok && field.Constraints != nil {
+						typeClaims = append(typeClaims, sys.ConstraintClaims(e, field.Constraints)...)
+					}
+				}
+				claims = append(claims, typeClaims...)
+				done[typ] = Void{}
+				delete(todo, typ)
+				continue
+			}
 			for _, field := range model.AttrFields {
 				if field.Ident == Ident("sys/db/id") {
 					continue
 				}
+				if field.Reverse {
+					reverses = append(reverses, field.Ident)
+					referrerType := field.FieldType
+					if referrerType.Kind() == reflect.Slice {
+						referrerType = referrerType.Elem()
+					}
+					if referrerType.Kind() == reflect.Pointer {
+						referrerType = referrerType.Elem()
+					}
+					if _, ok := done[referrerType]; !ok {
+						todo[referrerType] = Void{}
+					}
+					continue
+				}
 				e := TempID(strconv.FormatUint(uint64(nextID), 10))
 				nextID++
+				declared[field.Ident] = e
 				typeClaims = append(typeClaims,
 					Claim{E: e, A: sys.DbIdent, V: String(field.Ident)},
 					Claim{E: e, A: sys.AttrType, V: field.Type},
@@ -38,13 +112,23 @@ func Analyze(typ reflect.Type) (claims []Claim, err error) {
 				if field.Unique != 0 {
 					typeClaims = append(typeClaims, Claim{E: e, A: sys.AttrUnique, V: field.Unique})
 				}
+				if field.Constraints != nil {
+					typeClaims = append(typeClaims, sys.ConstraintClaims(e, field.Constraints)...)
+				}
 				if field.IsMap() || field.IsSlice() {
 					typeClaims = append(typeClaims, Claim{E: e, A: sys.AttrCardinality, V: sys.AttrCardinalityMany})
 				}
-				if field.Type == sys.AttrTypeRef {
+				if field.Type == sys.AttrTypeRef && !field.Ref && field.Lookup == "" {
 					structField := typ.Field(field.Index)
 					fieldType := structField.Type
 					switch {
+					case field.Lazy:
+						fieldType = reflect.Zero(fieldType).Interface().(models.LazyRef).ReferentType()
+					case field.IsInterface():
+						if err = addImplementations(registry, fieldType, done, todo); err != nil {
+							return
+						}
+						continue
 					case field.IsPointer():
 						fieldType = fieldType.Elem()
 					case field.IsMap():
@@ -53,11 +137,20 @@ func Analyze(typ reflect.Type) (claims []Claim, err error) {
 						if fieldType.Kind() == reflect.Pointer {
 							fieldType = fieldType.Elem()
 						}
+						if fieldType.Kind() == reflect.Interface {
+							if err = addImplementations(registry, fieldType, done, todo); err != nil {
+								return
+							}
+							continue
+						}
 					case field.IsSlice():
 						if field.CollValue != "" {
 							ee := TempID(strconv.FormatUint(uint64(nextID), 10))
 							nextID++
 							collAttrType := models.AttrTypeForScalarKind(fieldType.Elem())
+							if field.Codec != nil {
+								collAttrType = field.Codec.AttrType()
+							}
 							typeClaims = append(typeClaims,
 								Claim{E: ee, A: sys.DbIdent, V: String(field.CollValue)},
 								Claim{E: ee, A: sys.AttrType, V: collAttrType},
@@ -65,6 +158,12 @@ func Analyze(typ reflect.Type) (claims []Claim, err error) {
 							continue
 						} else {
 							fieldType = fieldType.Elem()
+							if fieldType.Kind() == reflect.Interface {
+								if err = addImplementations(registry, fieldType, done, todo); err != nil {
+									return
+								}
+								continue
+							}
 						}
 					}
 					_, ok := done[fieldType]
@@ -78,5 +177,80 @@ func Analyze(typ reflect.Type) (claims []Claim, err error) {
 			delete(todo, typ)
 		}
 	}
+	for _, ident := range reverses {
+		if _, ok := declared[ident]; ok {
+			continue
+		}
+		e := TempID(strconv.FormatUint(uint64(nextID), 10))
+		nextID++
+		claims = append(claims,
+			Claim{E: e, A: sys.DbIdent, V: String(ident)},
+			Claim{E: e, A: sys.AttrType, V: sys.AttrTypeRef},
+		)
+		declared[ident] = e
+	}
+	for _, model := range specs {
+		e := TempID(strconv.FormatUint(uint64(nextID), 10))
+		nextID++
+		claims = append(claims, Claim{E: e, A: sys.DbIdent, V: String(model.Spec)})
+		for _, field := range model.AttrFields {
+			if field.Required {
+				claims = append(claims, Claim{E: e, A: sys.SpecAttrs, V: declared[field.Ident]})
+			}
+		}
+		for _, pred := range model.Preds {
+			claims = append(claims, Claim{E: e, A: sys.SpecPreds, V: String(pred)})
+		}
+	}
+	return
+}
+
+// Attrs returns the attributes the claims declare, in the order they are declared. Only
+// the entities given types are attributes.
+func Attrs(claims []Claim) (attrs []Attr) {
+	indexes := map[ERef]int{}
+	for _, claim := range claims {
+		i, ok := indexes[claim.E]
+		if !ok {
+			if claim.A != sys.DbIdent {
+				continue
+			}
+			i = len(attrs)
+			indexes[claim.E] = i
+			attrs = append(attrs, Attr{})
+		}
+		attr := &attrs[i]
+		switch claim.A {
+		case sys.DbIdent:
+			attr.Ident = Ident(claim.V.(String))
+		case sys.AttrType:
+			attr.Type = claim.V.(ID)
+		case sys.AttrCardinality:
+			attr.Cardinality = claim.V.(ID)
+		case sys.AttrUnique:
+			attr.Unique = claim.V.(ID)
+		case sys.AttrRefType:
+			attr.RefType = claim.V.(ID)
+		}
+	}
+	attrs = slices.DeleteFunc(attrs, func(attr Attr) bool { return attr.Type == 0 })
+	return
+}
+
+// addImplementations schedules the registered implementations of the interface type
+// for analysis. An interface with no registered implementations cannot be recorded.
+func addImplementations(registry *models.Registry, iface reflect.Type, done map[reflect.Type]Void, todo map[reflect.Type]Void) (err error) {
+	bindings := registry.Implementations(iface)
+	if len(bindings) == 0 {
+		err = NewError("schemas.unregisteredInterface", "type", iface)
+		return
+	}
+	for _, binding := range bindings {
+		structType := binding.StructType()
+		_, ok := done[structType]
+		if !ok {
+			todo[structType] = Void{}
+		}
+	}
 	return
 }
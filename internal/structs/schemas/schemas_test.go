@@ -1,10 +1,13 @@
 package schemas
 
 import (
+	"net/netip"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/dball/destructive/internal/structs/assemblers"
+	"github.com/dball/destructive/internal/structs/models"
 	"github.com/dball/destructive/internal/sys"
 	. "github.com/dball/destructive/internal/types"
 	"github.com/stretchr/testify/assert"
@@ -175,3 +178,251 @@ func TestSliceWithScalarValues(t *testing.T) {
 	}
 	assert.Equal(t, expected, actual)
 }
+
+type party interface {
+	partyName() string
+}
+
+type partyPerson struct {
+	Name string `attr:"person/name"`
+}
+
+func (p *partyPerson) partyName() string { return p.Name }
+
+func TestInterfaceFields(t *testing.T) {
+	type Account struct {
+		Owner party `attr:"account/owner"`
+	}
+
+	t.Run("registered", func(t *testing.T) {
+		registry := models.NewRegistry()
+		assert.NoError(t, registry.Register(reflect.TypeFor[*partyPerson](), Ident("party/type"), Ident("party/type/person")))
+		actual, err := AnalyzeWith(registry, reflect.TypeFor[Account]())
+		assert.NoError(t, err)
+		expected := []Claim{
+			{E: TempID("1"), A: sys.DbIdent, V: String("account/owner")},
+			{E: TempID("1"), A: sys.AttrType, V: sys.AttrTypeRef},
+			{E: TempID("2"), A: sys.DbIdent, V: String("party/type")},
+			{E: TempID("2"), A: sys.AttrType, V: sys.AttrTypeRef},
+			{E: TempID("3"), A: sys.DbIdent, V: String("party/type/person")},
+			{E: TempID("4"), A: sys.DbIdent, V: String("person/name")},
+			{E: TempID("4"), A: sys.AttrType, V: sys.AttrTypeString},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("unregistered", func(t *testing.T) {
+		_, err := Analyze(reflect.TypeFor[Account]())
+		assert.Error(t, err)
+	})
+}
+
+func TestCodecFields(t *testing.T) {
+	type Host struct {
+		Addr    netip.Addr   `attr:"host/addr"`
+		Aliases []netip.Addr `attr:"host/aliases,value=host/alias"`
+	}
+
+	actual, err := Analyze(reflect.TypeFor[Host]())
+	assert.NoError(t, err)
+	expected := []Claim{
+		{E: TempID("1"), A: sys.DbIdent, V: String("host/addr")},
+		{E: TempID("1"), A: sys.AttrType, V: sys.AttrTypeString},
+		{E: TempID("2"), A: sys.DbIdent, V: String("host/aliases")},
+		{E: TempID("2"), A: sys.AttrType, V: sys.AttrTypeRef},
+		{E: TempID("2"), A: sys.AttrCardinality, V: sys.AttrCardinalityMany},
+		{E: TempID("3"), A: sys.DbIdent, V: String("host/alias")},
+		{E: TempID("3"), A: sys.AttrType, V: sys.AttrTypeString},
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestRefIDFields(t *testing.T) {
+	type Pet struct {
+		Owner   uint64   `attr:"pet/owner,ref"`
+		Sitters []uint64 `attr:"pet/sitters,ref"`
+	}
+
+	actual, err := Analyze(reflect.TypeFor[Pet]())
+	assert.NoError(t, err)
+	expected := []Claim{
+		{E: TempID("1"), A: sys.DbIdent, V: String("pet/owner")},
+		{E: TempID("1"), A: sys.AttrType, V: sys.AttrTypeRef},
+		{E: TempID("2"), A: sys.DbIdent, V: String("pet/sitters")},
+		{E: TempID("2"), A: sys.AttrType, V: sys.AttrTypeRef},
+		{E: TempID("2"), A: sys.AttrCardinality, V: sys.AttrCardinalityMany},
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestReverseFields(t *testing.T) {
+	t.Run("undeclared forward", func(t *testing.T) {
+		type Pet struct {
+			Name string `attr:"pet/name"`
+		}
+		type Person struct {
+			Name string `attr:"person/name"`
+			Pets []Pet  `attr:"pet/owner,reverse"`
+		}
+
+		actual, err := Analyze(reflect.TypeFor[Person]())
+		assert.NoError(t, err)
+		expected := []Claim{
+			{E: TempID("1"), A: sys.DbIdent, V: String("person/name")},
+			{E: TempID("1"), A: sys.AttrType, V: sys.AttrTypeString},
+			{E: TempID("2"), A: sys.DbIdent, V: String("pet/name")},
+			{E: TempID("2"), A: sys.AttrType, V: sys.AttrTypeString},
+			{E: TempID("3"), A: sys.DbIdent, V: String("pet/owner")},
+			{E: TempID("3"), A: sys.AttrType, V: sys.AttrTypeRef},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("declared forward", func(t *testing.T) {
+		type Person struct {
+			Name    string    `attr:"person/name"`
+			Reports []*Person `attr:"person/manager,reverse"`
+			Manager *Person   `attr:"person/manager"`
+		}
+
+		actual, err := Analyze(reflect.TypeFor[Person]())
+		assert.NoError(t, err)
+		expected := []Claim{
+			{E: TempID("1"), A: sys.DbIdent, V: String("person/name")},
+			{E: TempID("1"), A: sys.AttrType, V: sys.AttrTypeString},
+			{E: TempID("2"), A: sys.DbIdent, V: String("person/manager")},
+			{E: TempID("2"), A: sys.AttrType, V: sys.AttrTypeRef},
+		}
+		assert.Equal(t, expected, actual)
+	})
+}
+
+func TestLazyFields(t *testing.T) {
+	type Person struct {
+		Name string `attr:"person/name"`
+	}
+	type Pet struct {
+		Owner assemblers.Lazy[Person] `attr:"pet/owner"`
+	}
+
+	actual, err := Analyze(reflect.TypeFor[Pet]())
+	assert.NoError(t, err)
+	expected := []Claim{
+		{E: TempID("1"), A: sys.DbIdent, V: String("pet/owner")},
+		{E: TempID("1"), A: sys.AttrType, V: sys.AttrTypeRef},
+		{E: TempID("2"), A: sys.DbIdent, V: String("person/name")},
+		{E: TempID("2"), A: sys.AttrType, V: sys.AttrTypeString},
+	}
+	assert.Equal(t, expected, actual)
+
+	t.Run("pointer", func(t *testing.T) {
+		type Pet struct {
+			Owner *assemblers.Lazy[Person] `attr:"pet/owner"`
+		}
+		_, err := Analyze(reflect.TypeFor[Pet]())
+		assert.ErrorContains(t, err, "models.invalidLazyType")
+	})
+}
+
+func TestRequiredFields(t *testing.T) {
+	type Person struct {
+		ID    uint64  `attr:"sys/db/id,spec=person/spec,pred=adult"`
+		Name  string  `attr:"person/name,required"`
+		Email *string `attr:"person/email,required"`
+		Age   int     `attr:"person/age"`
+	}
+
+	actual, err := Analyze(reflect.TypeFor[Person]())
+	assert.NoError(t, err)
+	expected := []Claim{
+		{E: TempID("1"), A: sys.DbIdent, V: String("person/name")},
+		{E: TempID("1"), A: sys.AttrType, V: sys.AttrTypeString},
+		{E: TempID("2"), A: sys.DbIdent, V: String("person/email")},
+		{E: TempID("2"), A: sys.AttrType, V: sys.AttrTypeString},
+		{E: TempID("3"), A: sys.DbIdent, V: String("person/age")},
+		{E: TempID("3"), A: sys.AttrType, V: sys.AttrTypeInt},
+		{E: TempID("4"), A: sys.DbIdent, V: String("person/spec")},
+		{E: TempID("4"), A: sys.SpecAttrs, V: TempID("1")},
+		{E: TempID("4"), A: sys.SpecAttrs, V: TempID("2")},
+		{E: TempID("4"), A: sys.SpecPreds, V: String("adult")},
+	}
+	assert.Equal(t, expected, actual)
+
+	type Pet struct {
+		Name string `attr:"pet/name,required"`
+	}
+	actual, err = Analyze(reflect.TypeFor[Pet]())
+	assert.NoError(t, err)
+	assert.Equal(t, Claim{E: TempID("2"), A: sys.DbIdent, V: String("github.com/dball/destructive/internal/structs/schemas.Pet")}, actual[2])
+
+	// Types that would share or lack a default spec ident must name their specs.
+	{
+		type Pet struct {
+			Name string `attr:"pet/name,required"`
+			Age  int    `attr:"pet/age,required"`
+		}
+		_, err = Analyze(reflect.TypeFor[Pet]())
+		assert.ErrorContains(t, err, "models.specCollision")
+	}
+	_, err = Analyze(reflect.TypeFor[struct {
+		Name string `attr:"pet/name,required"`
+	}]())
+	assert.ErrorContains(t, err, "models.specRequired")
+}
+
+func TestConstrainedFields(t *testing.T) {
+	type Person struct {
+		Name string    `attr:"person/name,minlen=1,maxlen=64,regex=^[A-Z],pred=polite"`
+		Age  int       `attr:"person/age,min=0,max=150"`
+		Born time.Time `attr:"person/born,min=1900-01-01T00:00:00Z"`
+		Code string    `attr:"person/code,regex=^[0-9]{2\\,3}$,maxlen=3"`
+	}
+
+	actual, err := Analyze(reflect.TypeFor[Person]())
+	assert.NoError(t, err)
+	minLength, maxLength := Int(1), Int(64)
+	minAge, maxAge := Float(0), Float(150)
+	minBorn := Inst(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC))
+	expected := []Claim{
+		{E: TempID("1"), A: sys.DbIdent, V: String("person/name")},
+		{E: TempID("1"), A: sys.AttrType, V: sys.AttrTypeString},
+		{E: TempID("1"), A: sys.AttrMinLength, V: minLength},
+		{E: TempID("1"), A: sys.AttrMaxLength, V: maxLength},
+		{E: TempID("1"), A: sys.AttrRegex, V: String("^[A-Z]")},
+		{E: TempID("1"), A: sys.AttrPreds, V: String("polite")},
+		{E: TempID("2"), A: sys.DbIdent, V: String("person/age")},
+		{E: TempID("2"), A: sys.AttrType, V: sys.AttrTypeInt},
+		{E: TempID("2"), A: sys.AttrMin, V: minAge},
+		{E: TempID("2"), A: sys.AttrMax, V: maxAge},
+		{E: TempID("3"), A: sys.DbIdent, V: String("person/born")},
+		{E: TempID("3"), A: sys.AttrType, V: sys.AttrTypeInst},
+		{E: TempID("3"), A: sys.AttrMinInst, V: minBorn},
+		{E: TempID("4"), A: sys.DbIdent, V: String("person/code")},
+		{E: TempID("4"), A: sys.AttrType, V: sys.AttrTypeString},
+		{E: TempID("4"), A: sys.AttrMaxLength, V: Int(3)},
+		{E: TempID("4"), A: sys.AttrRegex, V: String("^[0-9]{2,3}$")},
+	}
+	assert.Equal(t, expected, actual)
+
+	type Invalid struct {
+		Name string `attr:"invalid/name,min=0"`
+	}
+	_, err = Analyze(reflect.TypeFor[Invalid]())
+	assert.Error(t, err)
+}
+
+func TestAttrs(t *testing.T) {
+	type Person struct {
+		ID      uint64   `attr:"sys/db/id,spec=person/spec"`
+		Name    string   `attr:"person/name,identity,required"`
+		Friends []Person `attr:"person/friends"`
+		Age     int      `attr:"person/age,min=0"`
+	}
+	claims, err := Analyze(reflect.TypeFor[Person]())
+	assert.NoError(t, err)
+	assert.Equal(t, []Attr{
+		{Ident: "person/name", Type: sys.AttrTypeString, Unique: sys.AttrUniqueIdentity},
+		{Ident: "person/friends", Type: sys.AttrTypeRef, Cardinality: sys.AttrCardinalityMany},
+		{Ident: "person/age", Type: sys.AttrTypeInt},
+	}, Attrs(claims))
+}
@@ -1,9 +1,11 @@
 package shredder
 
 import (
+	"math"
 	"reflect"
 	"time"
 
+	"github.com/dball/destructive/internal/structs/models"
 	. "github.com/dball/destructive/internal/types"
 )
 
@@ -11,42 +13,143 @@ type values []any
 
 // scalarValue converts a scalar reflect.Value to its system Value, reporting whether
 // the kind was a recognized scalar. Non-scalar kinds (ref structs, pointers, slices,
-// maps) return (nil, false) for the caller to handle.
-func scalarValue(v reflect.Value) (Value, bool) {
+// maps) return (nil, false) for the caller to handle. Unsigned values that an Int
+// cannot represent are rejected.
+func scalarValue(v reflect.Value) (Value, bool, error) {
 	switch v.Kind() {
 	case reflect.Bool:
-		return Bool(v.Bool()), true
-	case reflect.Int:
-		return Int(v.Int()), true
+		return Bool(v.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(v.Int()), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return nil, false, NewError("shredder.overflow", "value", u, "type", v.Type())
+		}
+		return Int(int64(u)), true, nil
 	case reflect.String:
-		return String(v.String()), true
-	case reflect.Float64:
-		return Float(v.Float()), true
+		return String(v.String()), true, nil
+	case reflect.Float32, reflect.Float64:
+		return Float(v.Float()), true, nil
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return String(b), true, nil
+		}
 	case reflect.Struct:
 		if t, ok := v.Interface().(time.Time); ok {
-			return Inst(t), true
+			return Inst(t), true, nil
 		}
 	}
-	return nil, false
+	return nil, false, nil
 }
 
 // elementValue converts a collection element: scalars become their system Value;
 // everything else (struct or pointer refs) is returned raw for ref resolution.
-func elementValue(v reflect.Value) any {
-	if sv, ok := scalarValue(v); ok {
-		return sv
+func elementValue(v reflect.Value) (any, error) {
+	sv, ok, err := scalarValue(v)
+	if err != nil || ok {
+		return sv, err
 	}
-	return v.Interface()
+	return v.Interface(), nil
+}
+
+// referrers returns the structs or struct pointers held in a reverse field, which may be
+// a struct pointer or a slice of structs or struct pointers. Nil pointers are omitted.
+func referrers(fieldValue reflect.Value) (xs []any) {
+	switch fieldValue.Kind() {
+	case reflect.Pointer:
+		if !fieldValue.IsNil() {
+			xs = append(xs, fieldValue.Interface())
+		}
+	case reflect.Slice:
+		n := fieldValue.Len()
+		xs = make([]any, 0, n)
+		for i := range n {
+			v := fieldValue.Index(i)
+			if v.Kind() == reflect.Pointer && v.IsNil() {
+				continue
+			}
+			xs = append(xs, v.Interface())
+		}
+	}
+	return
+}
+
+// refIDs returns the nonzero referent ids held in a ref field, which may be a uint64, a
+// pointer to one, or a slice of them.
+func refIDs(fieldType reflect.Type, fieldValue reflect.Value) (ids []ID) {
+	switch fieldType.Kind() {
+	case reflect.Pointer:
+		if !fieldValue.IsNil() && fieldValue.Elem().Uint() != 0 {
+			ids = []ID{ID(fieldValue.Elem().Uint())}
+		}
+	case reflect.Slice:
+		n := fieldValue.Len()
+		ids = make([]ID, 0, n)
+		for i := range n {
+			id := fieldValue.Index(i).Uint()
+			if id != 0 {
+				ids = append(ids, ID(id))
+			}
+		}
+	default:
+		if fieldValue.Uint() != 0 {
+			ids = []ID{ID(fieldValue.Uint())}
+		}
+	}
+	return
 }
 
-func getFieldValue(pointers map[reflect.Value]TempID, fieldType reflect.Type, fieldValue reflect.Value) (val any, err error) {
+// codecValue encodes the field's scalar values through the codec. Nil pointers are absent
+// values, and slices yield their encoded elements.
+func codecValue(codec models.Codec, fieldType reflect.Type, fieldValue reflect.Value) (val any, err error) {
 	switch fieldType.Kind() {
-	case reflect.Bool, reflect.Int, reflect.String, reflect.Float64:
-		val, _ = scalarValue(fieldValue)
+	case reflect.Pointer:
+		if fieldValue.IsNil() {
+			return
+		}
+		val, err = codec.Encode(fieldValue.Elem())
+	case reflect.Slice:
+		n := fieldValue.Len()
+		vals := make(values, 0, n)
+		for i := range n {
+			elem, elemErr := codec.Encode(fieldValue.Index(i))
+			if elemErr != nil {
+				err = elemErr
+				return
+			}
+			vals = append(vals, elem)
+		}
+		val = vals
+	default:
+		val, err = codec.Encode(fieldValue)
+	}
+	if err != nil {
+		val = nil
+	}
+	return
+}
+
+func getFieldValue(pointers map[reflect.Value]TempID, fieldType reflect.Type, codec models.Codec, fieldValue reflect.Value) (val any, err error) {
+	if codec != nil {
+		val, err = codecValue(codec, fieldType, fieldValue)
+		return
+	}
+	switch fieldType.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64, reflect.Array,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, _, err = scalarValue(fieldValue)
 	case reflect.Struct:
-		if sv, ok := scalarValue(fieldValue); ok {
+		sv, ok, scalarErr := scalarValue(fieldValue)
+		switch {
+		case scalarErr != nil:
+			err = scalarErr
+		case ok:
 			val = sv
-		} else {
+		default:
 			val = fieldValue.Interface()
 		}
 	case reflect.Map:
@@ -58,14 +161,24 @@ func getFieldValue(pointers map[reflect.Value]TempID, fieldType reflect.Type, fi
 			// b. the field appears therein
 			// c. the key and struct field value agree
 			// these may not obtain, revisit after we add more cardinality many field values
-			vals = append(vals, elementValue(iter.Value()))
+			elem, elemErr := elementValue(iter.Value())
+			if elemErr != nil {
+				err = elemErr
+				return
+			}
+			vals = append(vals, elem)
 		}
 		val = vals
 	case reflect.Slice:
 		var vals values
 		n := fieldValue.Len()
 		for i := range n {
-			vals = append(vals, elementValue(fieldValue.Index(i)))
+			elem, elemErr := elementValue(fieldValue.Index(i))
+			if elemErr != nil {
+				err = elemErr
+				return
+			}
+			vals = append(vals, elem)
 		}
 		val = vals
 	case reflect.Pointer:
@@ -73,8 +186,9 @@ func getFieldValue(pointers map[reflect.Value]TempID, fieldType reflect.Type, fi
 			return
 		}
 		elem := fieldValue.Elem()
-		if sv, ok := scalarValue(elem); ok {
-			val = sv
+		sv, ok, scalarErr := scalarValue(elem)
+		if scalarErr != nil || ok {
+			val, err = sv, scalarErr
 			return
 		}
 		// A pointer to a ref struct resolves through the pointers map for cycle detection.
@@ -84,6 +198,20 @@ func getFieldValue(pointers map[reflect.Value]TempID, fieldType reflect.Type, fi
 		} else {
 			val = ptr.Interface()
 		}
+	case reflect.Interface:
+		if fieldValue.IsNil() {
+			return
+		}
+		// The concrete value is a struct or a pointer to a struct, which is resolved
+		// like any other ref.
+		elem := fieldValue.Elem()
+		if elem.Kind() == reflect.Pointer && !elem.IsNil() {
+			if tempid, ok := pointers[elem]; ok {
+				val = tempid
+				return
+			}
+		}
+		val = elem.Interface()
 	default:
 		err = NewError("shredder.invalidFieldType", "type", fieldType)
 	}
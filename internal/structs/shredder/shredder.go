@@ -10,10 +10,21 @@ import (
 	. "github.com/dball/destructive/internal/types"
 )
 
-// Document contains the lists of structs to assert or retract.
+// Document contains the lists of structs to assert or retract, and the patches to apply.
 type Document struct {
 	Retractions []any
 	Assertions  []any
+	Patches     []Patch
+}
+
+// Patch is a partial update of an entity from a struct, which must have an id. Only the
+// named attr fields, and the attr fields that differ from those of the base struct if one
+// is given, are asserted. Those that are nil pointers, slices or maps are retracted
+// instead, apart from reverse fields, which are ignored.
+type Patch struct {
+	Entity any
+	Fields []string
+	Base   any
 }
 
 // Shredder shreds structs into claims. The ids slice will
@@ -40,6 +51,8 @@ type confetti struct {
 	pointers map[reflect.Value]TempID
 	// tempIDs are the registry of temp ids allocated by shredding the document.
 	tempIDs map[TempID]ID
+	// conditions are the version guards of the shredded structs.
+	conditions []Condition
 }
 
 func (s *shredder) nextTempID() TempID {
@@ -75,6 +88,18 @@ func (s *shredder) Shred(doc Document) (req Request, ids []ERef, err error) {
 		ids = append(ids, tempID)
 		req.Claims = append(req.Claims, claims...)
 	}
+	for _, patch := range doc.Patches {
+		var claims []Claim
+		var retraction *Retraction
+		claims, retraction, err = s.patch(&confetti, patch)
+		if err != nil {
+			return
+		}
+		req.Claims = append(req.Claims, claims...)
+		if retraction != nil {
+			req.Retractions = append(req.Retractions, *retraction)
+		}
+	}
 	for i, claim := range req.Claims {
 		e, ok := claim.E.(TempID)
 		if ok {
@@ -99,10 +124,21 @@ func (s *shredder) Shred(doc Document) (req Request, ids []ERef, err error) {
 			ids[i] = id
 		}
 	}
+	req.Conditions = confetti.conditions
 	return
 }
 
 func (s *shredder) assert(confetti *confetti, x any) (e TempID, claims []Claim, err error) {
+	return s.assertFields(confetti, x, nil)
+}
+
+// assertFields asserts the attr fields of the struct whose idents are in the mask, or all
+// of them if the mask is nil. The id and version fields are always considered.
+func (s *shredder) assertFields(confetti *confetti, x any, mask map[Ident]Void) (e TempID, claims []Claim, err error) {
+	if x == nil {
+		err = NewError("shredder.nilStruct")
+		return
+	}
 	typ := reflect.TypeOf(x)
 	var fields reflect.Value
 	var id ID
@@ -136,10 +172,47 @@ func (s *shredder) assert(confetti *confetti, x any) (e TempID, claims []Claim,
 	}
 	claims = make([]Claim, 0, len(model.AttrFields))
 	var refFieldsClaims []Claim
+	binding, ok := s.analyzer.Registry().Binding(typ)
+	if ok {
+		claims = append(claims, Claim{E: e, A: binding.Attr, V: binding.Ident})
+	}
+	if model.Spec != "" {
+		claims = append(claims, Claim{E: e, A: sys.SpecEnsure, V: model.Spec})
+	}
+	if model.Generated != nil {
+		err = model.Generated.Shred(x, func(ident Ident, v Value) {
+			if ident == sys.DbId {
+				if id, _ := v.(ID); id != 0 {
+					confetti.tempIDs[e] = id
+				}
+				return
+			}
+			if mask != nil {
+				if _, ok := mask[ident]; !ok {
+					return
+				}
+			}
+			claims = append(claims, Claim{E: e, A: ident, V: v.(VRef)})
+		})
+		return
+	}
+	var version *models.AttrFieldModel
+	// identities are the lookup refs of the identity values of the struct, to which its
+	// tempid may resolve.
+	var identities []LookupRef
 	for _, attr := range model.AttrFields {
+		if mask != nil && attr.Ident != sys.DbId && !attr.Version {
+			if _, ok := mask[attr.Ident]; !ok {
+				continue
+			}
+		}
 		fieldValue := fields.Field(attr.Index)
+		if attr.Version {
+			version = &attr
+			continue
+		}
 		if attr.Ident == sys.DbId {
-			switch attr.FieldType.Kind() {
+			switch attr.Kind {
 			case reflect.Uint64:
 				fid := ID(fieldValue.Uint())
 				switch {
@@ -156,7 +229,33 @@ func (s *shredder) assert(confetti *confetti, x any) (e TempID, claims []Claim,
 			}
 			continue
 		}
-		val, fieldErr := getFieldValue(confetti.pointers, attr.FieldType, fieldValue)
+		if attr.Ref {
+			for _, id := range refIDs(attr.FieldType, fieldValue) {
+				claims = append(claims, Claim{E: e, A: attr.Ident, V: id})
+			}
+			continue
+		}
+		if attr.Lazy {
+			if id := fieldValue.Interface().(models.LazyRef).RefID(); id != 0 {
+				claims = append(claims, Claim{E: e, A: attr.Ident, V: id})
+			}
+			continue
+		}
+		if attr.Reverse {
+			// The referrers are asserted with their refs to this entity.
+			for _, referrer := range referrers(fieldValue) {
+				var referrerE TempID
+				var referrerClaims []Claim
+				referrerE, referrerClaims, err = s.assert(confetti, referrer)
+				if err != nil {
+					return
+				}
+				refFieldsClaims = append(refFieldsClaims, referrerClaims...)
+				refFieldsClaims = append(refFieldsClaims, Claim{E: referrerE, A: attr.Ident, V: e})
+			}
+			continue
+		}
+		val, fieldErr := getFieldValue(confetti.pointers, attr.FieldType, attr.Codec, fieldValue)
 		if fieldErr != nil {
 			err = fieldErr
 			return
@@ -171,6 +270,11 @@ func (s *shredder) assert(confetti *confetti, x any) (e TempID, claims []Claim,
 			if attr.IgnoreEmpty && v.IsZero() {
 				continue
 			}
+			if attr.Lookup != "" {
+				vref = LookupRef{A: attr.Lookup, V: v}
+			} else if attr.Unique == sys.AttrUniqueIdentity {
+				identities = append(identities, LookupRef{A: attr.Ident, V: v})
+			}
 		case TempID:
 			vref = v
 			// TODO idk if tempid constraints are legit or not
@@ -218,10 +322,142 @@ func (s *shredder) assert(confetti *confetti, x any) (e TempID, claims []Claim,
 		}
 		claims = append(claims, Claim{E: e, A: attr.Ident, V: vref})
 	}
+	if version != nil {
+		// Structs require their versions to be stored, where zero is unstored, for the
+		// entities of their ids or, lacking ids, those their identities may resolve to.
+		v := fields.Field(version.Index).Int()
+		var es []IDRef
+		if id := confetti.tempIDs[e]; id != 0 {
+			es = append(es, id)
+		} else {
+			for _, ref := range identities {
+				es = append(es, ref)
+			}
+		}
+		for _, ref := range es {
+			condition := Condition{E: ref, A: version.Ident}
+			if v != 0 {
+				condition.V = Int(v)
+			}
+			confetti.conditions = append(confetti.conditions, condition)
+		}
+		claims = append(claims, Claim{E: e, A: version.Ident, V: Int(v + 1)})
+	}
 	claims = append(claims, refFieldsClaims...)
 	return
 }
 
+// patch asserts the patched attr fields of the struct, and retracts those that are nil
+// and the stored values of those that are collections.
+func (s *shredder) patch(confetti *confetti, patch Patch) (claims []Claim, retraction *Retraction, err error) {
+	if patch.Entity == nil {
+		err = NewError("shredder.nilStruct")
+		return
+	}
+	fields := reflect.ValueOf(patch.Entity)
+	if fields.Kind() == reflect.Pointer {
+		if fields.IsNil() {
+			err = NewError("shredder.nilStruct")
+			return
+		}
+		fields = fields.Elem()
+	}
+	typ := fields.Type()
+	if typ.Kind() != reflect.Struct {
+		err = NewError("shredder.invalidStruct", "type", typ)
+		return
+	}
+	model, modelErr := s.analyzer.Analyze(typ)
+	if modelErr != nil {
+		err = modelErr
+		return
+	}
+	mask := make(map[Ident]Void, len(patch.Fields))
+	for _, name := range patch.Fields {
+		field, ok := typ.FieldByName(name)
+		var attr models.AttrFieldModel
+		if ok && len(field.Index) == 1 {
+			attr, ok = attrField(model, field.Index[0])
+		}
+		if !ok || len(field.Index) != 1 {
+			err = NewError("shredder.invalidPatchField", "type", typ, "field", name)
+			return
+		}
+		if attr.Ident != sys.DbId {
+			mask[attr.Ident] = Void{}
+		}
+	}
+	if patch.Base != nil {
+		base := reflect.ValueOf(patch.Base)
+		if base.Kind() == reflect.Pointer && !base.IsNil() {
+			base = base.Elem()
+		}
+		if base.Type() != typ {
+			err = NewError("shredder.invalidPatchBase", "type", typ, "baseType", base.Type())
+			return
+		}
+		for _, attr := range model.AttrFields {
+			if attr.Ident == sys.DbId {
+				continue
+			}
+			if !equalFields(fields.Field(attr.Index), base.Field(attr.Index)) {
+				mask[attr.Ident] = Void{}
+			}
+		}
+	}
+	var e TempID
+	e, claims, err = s.assertFields(confetti, patch.Entity, mask)
+	if err != nil {
+		return
+	}
+	id := confetti.tempIDs[e]
+	if id == 0 {
+		err = NewError("shredder.unidentifiedPatch", "type", typ)
+		return
+	}
+	attrs := map[IDRef]Void{}
+	for _, attr := range model.AttrFields {
+		if _, ok := mask[attr.Ident]; !ok || attr.Reverse {
+			continue
+		}
+		switch attr.Kind {
+		case reflect.Slice, reflect.Map:
+			// Collections replace the values stored for them, which are retracted before
+			// the collections' values are asserted.
+			attrs[attr.Ident] = Void{}
+		case reflect.Pointer, reflect.Interface:
+			if fields.Field(attr.Index).IsNil() {
+				attrs[attr.Ident] = Void{}
+			}
+		}
+	}
+	if len(attrs) != 0 {
+		retraction = &Retraction{Constraints: map[IDRef]Void{id: {}}, Attrs: attrs}
+	}
+	return
+}
+
+// equalFields reports whether the field values are deeply equal. Unexported field values
+// are compared shallowly, so their pointers are equal only if they are the same pointers.
+func equalFields(x, y reflect.Value) bool {
+	if x.CanInterface() {
+		return reflect.DeepEqual(x.Interface(), y.Interface())
+	}
+	return x.Comparable() && x.Equal(y)
+}
+
+// attrField returns the model of the attr field at the index, if any.
+func attrField(model models.StructModel, index int) (attr models.AttrFieldModel, ok bool) {
+	for _, attr = range model.AttrFields {
+		if attr.Index == index {
+			ok = true
+			return
+		}
+	}
+	attr = models.AttrFieldModel{}
+	return
+}
+
 func (s *shredder) retract(confetti *confetti, x any) (retraction *Retraction, err error) {
 	constraints := map[IDRef]Void{}
 	var fields reflect.Value
@@ -250,7 +486,7 @@ func (s *shredder) retract(confetti *confetti, x any) (retraction *Retraction, e
 	for _, attr := range model.AttrFields {
 		fieldValue := fields.Field(attr.Index)
 		if attr.Ident == sys.DbId {
-			switch attr.FieldType.Kind() {
+			switch attr.Kind {
 			case reflect.Uint64:
 				if fieldValue.IsZero() {
 					continue
@@ -271,10 +507,23 @@ func (s *shredder) retract(confetti *confetti, x any) (retraction *Retraction, e
 			}
 			continue
 		}
-		if attr.Unique == 0 {
+		if attr.Unique == 0 || attr.Lookup != "" || attr.Reverse {
+			continue
+		}
+		if attr.Ref {
+			ids := refIDs(attr.FieldType, fieldValue)
+			if len(ids) == 1 && !attr.IsSlice() {
+				constraints[LookupRef{A: attr.Ident, V: ids[0]}] = Void{}
+			}
+			continue
+		}
+		if attr.Lazy {
+			if id := fieldValue.Interface().(models.LazyRef).RefID(); id != 0 {
+				constraints[LookupRef{A: attr.Ident, V: id}] = Void{}
+			}
 			continue
 		}
-		vref, fieldErr := getFieldValue(confetti.pointers, attr.FieldType, fieldValue)
+		vref, fieldErr := getFieldValue(confetti.pointers, attr.FieldType, attr.Codec, fieldValue)
 		if fieldErr != nil {
 			err = fieldErr
 			return
@@ -1,10 +1,16 @@
 package shredder
 
 import (
+	"math"
+	"net/netip"
+	"net/url"
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/dball/destructive/internal/structs/assemblers"
 	"github.com/dball/destructive/internal/structs/models"
+	"github.com/dball/destructive/internal/sys"
 	. "github.com/dball/destructive/internal/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -334,3 +340,466 @@ func TestStructSliceFields(t *testing.T) {
 		assert.Equal(t, expected, actual)
 	})
 }
+
+type party interface {
+	partyName() string
+}
+
+type partyPerson struct {
+	Name string `attr:"person/name"`
+}
+
+func (p *partyPerson) partyName() string { return p.Name }
+
+type partyCompany struct {
+	Name string `attr:"company/name"`
+}
+
+func (c partyCompany) partyName() string { return c.Name }
+
+func TestInterfaceFields(t *testing.T) {
+	type Account struct {
+		Owner  party   `attr:"account/owner"`
+		Admins []party `attr:"account/admins"`
+	}
+
+	registry := models.NewRegistry()
+	assert.NoError(t, registry.Register(reflect.TypeFor[*partyPerson](), Ident("party/type"), Ident("party/type/person")))
+	assert.NoError(t, registry.Register(reflect.TypeFor[partyCompany](), Ident("party/type"), Ident("party/type/company")))
+
+	t.Run("records discriminators", func(t *testing.T) {
+		shredder := NewShredder(models.BuildRegistryAnalyzer(registry))
+		account := Account{
+			Owner:  partyCompany{Name: "Acme"},
+			Admins: []party{&partyPerson{Name: "Donald"}},
+		}
+		actual, _, err := shredder.Shred(Document{Assertions: []any{account}})
+		assert.NoError(t, err)
+		expected := Request{
+			Claims: []Claim{
+				{E: TempID("1"), A: Ident("account/owner"), V: TempID("2")},
+				{E: TempID("1"), A: Ident("account/admins"), V: TempID("3")},
+				{E: TempID("2"), A: Ident("party/type"), V: Ident("party/type/company")},
+				{E: TempID("2"), A: Ident("company/name"), V: String("Acme")},
+				{E: TempID("3"), A: Ident("sys/db/rank"), V: Int(0)},
+				{E: TempID("3"), A: Ident("party/type"), V: Ident("party/type/person")},
+				{E: TempID("3"), A: Ident("person/name"), V: String("Donald")},
+			},
+			Retractions: []Retraction{},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("nil interface is absent", func(t *testing.T) {
+		shredder := NewShredder(models.BuildRegistryAnalyzer(registry))
+		actual, _, err := shredder.Shred(Document{Assertions: []any{Account{}}})
+		assert.NoError(t, err)
+		assert.Equal(t, Request{Claims: []Claim{}, Retractions: []Retraction{}}, actual)
+	})
+}
+
+type status string
+
+func TestWideScalarFields(t *testing.T) {
+	type Sensor struct {
+		Status   status   `attr:"sensor/status"`
+		Channel  int8     `attr:"sensor/channel"`
+		Reads    uint64   `attr:"sensor/reads"`
+		Gain     *float32 `attr:"sensor/gain"`
+		Checksum [4]byte  `attr:"sensor/checksum"`
+		Levels   []uint16 `attr:"sensor/levels,value=sensor/level"`
+	}
+
+	t.Run("assert", func(t *testing.T) {
+		shredder := NewShredder(models.BuildCachingAnalyzer())
+		gain := float32(0.5)
+		sensor := Sensor{
+			Status:   status("online"),
+			Channel:  -3,
+			Reads:    42,
+			Gain:     &gain,
+			Checksum: [4]byte{'a', 'b', 'c', 'd'},
+			Levels:   []uint16{7},
+		}
+		actual, _, err := shredder.Shred(Document{Assertions: []any{sensor}})
+		assert.NoError(t, err)
+		expected := Request{
+			Claims: []Claim{
+				{E: TempID("1"), A: Ident("sensor/status"), V: String("online")},
+				{E: TempID("1"), A: Ident("sensor/channel"), V: Int(-3)},
+				{E: TempID("1"), A: Ident("sensor/reads"), V: Int(42)},
+				{E: TempID("1"), A: Ident("sensor/gain"), V: Float(0.5)},
+				{E: TempID("1"), A: Ident("sensor/checksum"), V: String("abcd")},
+				{E: TempID("1"), A: Ident("sensor/levels"), V: TempID("2")},
+				{E: TempID("2"), A: Ident("sys/db/rank"), V: Int(0)},
+				{E: TempID("2"), A: Ident("sensor/level"), V: Int(7)},
+			},
+			Retractions: []Retraction{},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		shredder := NewShredder(models.BuildCachingAnalyzer())
+		_, _, err := shredder.Shred(Document{Assertions: []any{Sensor{Reads: math.MaxUint64}}})
+		assert.Error(t, err)
+	})
+}
+
+type cents struct {
+	amount int64
+}
+
+func (c cents) EncodeValue() (any, error) { return c.amount, nil }
+
+func (c *cents) DecodeValue(v any) error {
+	c.amount = v.(int64)
+	return nil
+}
+
+func TestCodecFields(t *testing.T) {
+	type Host struct {
+		Addr    netip.Addr   `attr:"host/addr"`
+		Backup  *netip.Addr  `attr:"host/backup"`
+		Price   cents        `attr:"host/price"`
+		Home    url.URL      `attr:"host/home"`
+		Aliases []netip.Addr `attr:"host/aliases,value=host/alias"`
+	}
+
+	registry := models.NewRegistry()
+	codec, err := models.NewFuncCodec(reflect.TypeFor[url.URL](),
+		func(x any) (any, error) {
+			u := x.(url.URL)
+			return u.String(), nil
+		},
+		func(y any) (any, error) {
+			u, err := url.Parse(y.(string))
+			if err != nil {
+				return nil, err
+			}
+			return *u, nil
+		},
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, registry.RegisterCodec(reflect.TypeFor[url.URL](), codec))
+
+	shredder := NewShredder(models.BuildRegistryAnalyzer(registry))
+	home, _ := url.Parse("https://example.com/")
+	host := Host{
+		Addr:    netip.MustParseAddr("10.0.0.1"),
+		Price:   cents{amount: 1999},
+		Home:    *home,
+		Aliases: []netip.Addr{netip.MustParseAddr("::1")},
+	}
+	actual, _, err := shredder.Shred(Document{Assertions: []any{host}})
+	assert.NoError(t, err)
+	expected := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("host/addr"), V: String("10.0.0.1")},
+			{E: TempID("1"), A: Ident("host/price"), V: Int(1999)},
+			{E: TempID("1"), A: Ident("host/home"), V: String("https://example.com/")},
+			{E: TempID("1"), A: Ident("host/aliases"), V: TempID("2")},
+			{E: TempID("2"), A: Ident("sys/db/rank"), V: Int(0)},
+			{E: TempID("2"), A: Ident("host/alias"), V: String("::1")},
+		},
+		Retractions: []Retraction{},
+	}
+	assert.Equal(t, expected, actual)
+
+	t.Run("value receiver", func(t *testing.T) {
+		type Till struct {
+			Float valueCents `attr:"till/float"`
+		}
+		_, _, err := shredder.Shred(Document{Assertions: []any{Till{}}})
+		assert.ErrorContains(t, err, "models.invalidCodec")
+	})
+}
+
+// valueCents decodes into a copy of itself, which is rejected.
+type valueCents struct {
+	amount int64
+}
+
+func (c valueCents) EncodeValue() (any, error) { return c.amount, nil }
+
+func (c valueCents) DecodeValue(v any) error {
+	c.amount = v.(int64)
+	return nil
+}
+
+func TestRefIDFields(t *testing.T) {
+	type Pet struct {
+		ID      uint64   `attr:"sys/db/id"`
+		Owner   uint64   `attr:"pet/owner,ref"`
+		Vet     *uint64  `attr:"pet/vet,ref"`
+		Sitters []uint64 `attr:"pet/sitters,ref"`
+		Tag     uint64   `attr:"pet/tag,ref,unique"`
+	}
+
+	t.Run("assert", func(t *testing.T) {
+		shredder := NewShredder(models.BuildCachingAnalyzer())
+		vet := uint64(0x100002)
+		pet := Pet{Owner: 0x100001, Vet: &vet, Sitters: []uint64{0x100003, 0x100004}}
+		actual, _, err := shredder.Shred(Document{Assertions: []any{pet}})
+		assert.NoError(t, err)
+		expected := Request{
+			Claims: []Claim{
+				{E: TempID("1"), A: Ident("pet/owner"), V: ID(0x100001)},
+				{E: TempID("1"), A: Ident("pet/vet"), V: ID(0x100002)},
+				{E: TempID("1"), A: Ident("pet/sitters"), V: ID(0x100003)},
+				{E: TempID("1"), A: Ident("pet/sitters"), V: ID(0x100004)},
+			},
+			Retractions: []Retraction{},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("retract", func(t *testing.T) {
+		shredder := NewShredder(models.BuildCachingAnalyzer())
+		actual, _, err := shredder.Shred(Document{Retractions: []any{Pet{Tag: 0x100005}}})
+		assert.NoError(t, err)
+		expected := []Retraction{
+			{Constraints: map[IDRef]Void{LookupRef{A: Ident("pet/tag"), V: ID(0x100005)}: {}}},
+		}
+		assert.Equal(t, expected, actual.Retractions)
+	})
+}
+
+func TestLookupFields(t *testing.T) {
+	type Order struct {
+		Customer string  `attr:"order/customer,lookup=person/email"`
+		Referrer *string `attr:"order/referrer,lookup=person/email"`
+		Item     int     `attr:"order/item,lookup=item/sku,ignoreempty"`
+	}
+
+	shredder := NewShredder(models.BuildCachingAnalyzer())
+	referrer := "b@example.com"
+	order := Order{Customer: "a@example.com", Referrer: &referrer}
+	actual, _, err := shredder.Shred(Document{Assertions: []any{order}})
+	assert.NoError(t, err)
+	expected := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("order/customer"), V: LookupRef{A: Ident("person/email"), V: String("a@example.com")}},
+			{E: TempID("1"), A: Ident("order/referrer"), V: LookupRef{A: Ident("person/email"), V: String("b@example.com")}},
+		},
+		Retractions: []Retraction{},
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestReverseFields(t *testing.T) {
+	type Pet struct {
+		Name string `attr:"pet/name"`
+	}
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name,identity"`
+		Pets []*Pet `attr:"pet/owner,reverse"`
+		Vet  *Pet   `attr:"pet/vet,reverse"`
+	}
+
+	t.Run("assert", func(t *testing.T) {
+		shredder := NewShredder(models.BuildCachingAnalyzer())
+		momo := &Pet{Name: "Momo"}
+		person := Person{Name: "Donald", Pets: []*Pet{momo, nil, {Name: "Rex"}}, Vet: momo}
+		actual, _, err := shredder.Shred(Document{Assertions: []any{person}})
+		assert.NoError(t, err)
+		expected := Request{
+			Claims: []Claim{
+				{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+				{E: TempID("2"), A: Ident("pet/name"), V: String("Momo")},
+				{E: TempID("2"), A: Ident("pet/owner"), V: TempID("1")},
+				{E: TempID("3"), A: Ident("pet/name"), V: String("Rex")},
+				{E: TempID("3"), A: Ident("pet/owner"), V: TempID("1")},
+				{E: TempID("2"), A: Ident("pet/vet"), V: TempID("1")},
+			},
+			Retractions: []Retraction{},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("retract", func(t *testing.T) {
+		shredder := NewShredder(models.BuildCachingAnalyzer())
+		person := Person{Name: "Donald", Pets: []*Pet{{Name: "Momo"}}}
+		actual, _, err := shredder.Shred(Document{Retractions: []any{person}})
+		assert.NoError(t, err)
+		expected := []Retraction{
+			{Constraints: map[IDRef]Void{LookupRef{A: Ident("person/name"), V: String("Donald")}: {}}},
+		}
+		assert.Equal(t, expected, actual.Retractions)
+	})
+}
+
+func TestLazyFields(t *testing.T) {
+	type Person struct {
+		Name string `attr:"person/name"`
+	}
+	type Pet struct {
+		Name  string                  `attr:"pet/name"`
+		Owner assemblers.Lazy[Person] `attr:"pet/owner"`
+	}
+
+	shredder := NewShredder(models.BuildCachingAnalyzer())
+	actual, _, err := shredder.Shred(Document{Assertions: []any{Pet{Name: "Momo"}}})
+	assert.NoError(t, err)
+	expected := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("pet/name"), V: String("Momo")},
+		},
+		Retractions: []Retraction{},
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func TestPatches(t *testing.T) {
+	type Pet struct {
+		Name string `attr:"pet/name"`
+	}
+	type Person struct {
+		ID       uint64  `attr:"sys/db/id"`
+		Name     string  `attr:"person/name"`
+		Age      int     `attr:"person/age"`
+		Nickname *string `attr:"person/nickname"`
+		Pets     []Pet   `attr:"person/pets"`
+		Notes    string
+	}
+
+	t.Run("fields", func(t *testing.T) {
+		shredder := NewShredder(models.BuildCachingAnalyzer())
+		p := &Person{ID: 23, Name: "Donald", Age: 48}
+		actual, ids, err := shredder.Shred(Document{Patches: []Patch{{Entity: p, Fields: []string{"ID", "Age", "Nickname", "Pets"}}}})
+		assert.NoError(t, err)
+		assert.Empty(t, ids)
+		expected := Request{
+			Claims: []Claim{
+				{E: ID(23), A: Ident("person/age"), V: Int(48)},
+			},
+			Retractions: []Retraction{
+				{
+					Constraints: map[IDRef]Void{ID(23): {}},
+					Attrs:       map[IDRef]Void{Ident("person/nickname"): {}, Ident("person/pets"): {}},
+				},
+			},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("base", func(t *testing.T) {
+		shredder := NewShredder(models.BuildCachingAnalyzer())
+		nickname := "Don"
+		base := Person{ID: 23, Name: "Donald", Age: 48, Nickname: &nickname}
+		p := base
+		p.Name = "Stephen"
+		p.Notes = "ignored"
+		p.Pets = []Pet{{Name: "Momo"}}
+		actual, _, err := shredder.Shred(Document{Patches: []Patch{{Entity: p, Base: &base, Fields: []string{"Age"}}}})
+		assert.NoError(t, err)
+		expected := Request{
+			Claims: []Claim{
+				{E: ID(23), A: Ident("person/name"), V: String("Stephen")},
+				{E: ID(23), A: Ident("person/age"), V: Int(48)},
+				{E: ID(23), A: Ident("person/pets"), V: TempID("2")},
+				{E: TempID("2"), A: Ident("sys/db/rank"), V: Int(0)},
+				{E: TempID("2"), A: Ident("pet/name"), V: String("Momo")},
+			},
+			// The stored pets are replaced.
+			Retractions: []Retraction{
+				{
+					Constraints: map[IDRef]Void{ID(23): {}},
+					Attrs:       map[IDRef]Void{Ident("person/pets"): {}},
+				},
+			},
+		}
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		shredder := NewShredder(models.BuildCachingAnalyzer())
+		_, _, err := shredder.Shred(Document{Patches: []Patch{{Entity: Person{Name: "Donald"}, Fields: []string{"Name"}}}})
+		assert.Error(t, err)
+		_, _, err = shredder.Shred(Document{Patches: []Patch{{Entity: Person{ID: 23}, Fields: []string{"Notes"}}}})
+		assert.Error(t, err)
+		_, _, err = shredder.Shred(Document{Patches: []Patch{{Entity: Person{ID: 23}, Base: Pet{}}}})
+		assert.Error(t, err)
+	})
+}
+
+func TestVersionFields(t *testing.T) {
+	type Person struct {
+		ID      uint64 `attr:"sys/db/id"`
+		Name    string `attr:"person/name"`
+		Version int    `attr:"person/version,version"`
+	}
+
+	shredder := NewShredder(models.BuildCachingAnalyzer())
+	actual, _, err := shredder.Shred(Document{
+		Assertions: []any{Person{Name: "Donald"}, Person{ID: 23, Name: "Stephen"}},
+		Patches:    []Patch{{Entity: Person{ID: 24, Version: 2}}},
+	})
+	assert.NoError(t, err)
+	expected := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+			{E: TempID("1"), A: Ident("person/version"), V: Int(1)},
+			{E: ID(23), A: Ident("person/name"), V: String("Stephen")},
+			{E: ID(23), A: Ident("person/version"), V: Int(1)},
+			{E: ID(24), A: Ident("person/version"), V: Int(3)},
+		},
+		Retractions: []Retraction{},
+		Conditions: []Condition{
+			{E: ID(23), A: Ident("person/version")},
+			{E: ID(24), A: Ident("person/version"), V: Int(2)},
+		},
+	}
+	assert.Equal(t, expected, actual)
+
+	// Structs without ids are guarded by their identities.
+	type Identified struct {
+		Email   string `attr:"person/email,identity"`
+		Version int    `attr:"person/version,version"`
+	}
+	actual, _, err = shredder.Shred(Document{Assertions: []any{Identified{Email: "a@example.com", Version: 3}}})
+	assert.NoError(t, err)
+	expected = Request{
+		Claims: []Claim{
+			{E: TempID("4"), A: Ident("person/email"), V: String("a@example.com")},
+			{E: TempID("4"), A: Ident("person/version"), V: Int(4)},
+		},
+		Retractions: []Retraction{},
+		Conditions: []Condition{
+			{E: LookupRef{A: Ident("person/email"), V: String("a@example.com")}, A: Ident("person/version"), V: Int(3)},
+		},
+	}
+	assert.Equal(t, expected, actual)
+
+	type Invalid struct {
+		Version string `attr:"invalid/version,version"`
+	}
+	_, _, err = shredder.Shred(Document{Assertions: []any{Invalid{}}})
+	assert.Error(t, err)
+}
+
+func TestRequiredFields(t *testing.T) {
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id,spec=person/spec"`
+		Name string `attr:"person/name,required"`
+	}
+
+	shredder := NewShredder(models.BuildCachingAnalyzer())
+	actual, _, err := shredder.Shred(Document{Assertions: []any{Person{Name: "Donald"}}})
+	assert.NoError(t, err)
+	expected := Request{
+		Claims: []Claim{
+			{E: TempID("1"), A: sys.SpecEnsure, V: Ident("person/spec")},
+			{E: TempID("1"), A: Ident("person/name"), V: String("Donald")},
+		},
+		Retractions: []Retraction{},
+	}
+	assert.Equal(t, expected, actual)
+
+	type Invalid struct {
+		Name string `attr:"invalid/name,spec=invalid/spec"`
+	}
+	_, _, err = shredder.Shred(Document{Assertions: []any{Invalid{}}})
+	assert.Error(t, err)
+}
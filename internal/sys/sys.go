@@ -3,6 +3,7 @@ package sys
 import (
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	. "github.com/dball/destructive/internal/types"
 )
@@ -28,6 +29,18 @@ const (
 	AttrRefType          = ID(17)
 	AttrRefTypeDependent = ID(18)
 	DbRank               = ID(19)
+	SpecAttrs            = ID(20)
+	SpecPreds            = ID(21)
+	SpecEnsure           = ID(22)
+	AttrMin              = ID(23)
+	AttrMax              = ID(24)
+	AttrMinLength        = ID(25)
+	AttrMaxLength        = ID(26)
+	AttrRegex            = ID(27)
+	AttrMinInst          = ID(28)
+	AttrMaxInst          = ID(29)
+	AttrPreds            = ID(30)
+	DbAlias              = ID(31)
 	FirstUserID          = ID(0x100000)
 )
 
@@ -60,6 +73,36 @@ var Datums []Datum = []Datum{
 	{E: AttrRefTypeDependent, A: DbIdent, V: String("sys/attr/ref/type/dependent"), T: Tx},
 	{E: DbRank, A: DbIdent, V: String("sys/db/rank"), T: Tx},
 	{E: DbRank, A: AttrType, V: AttrTypeInt, T: Tx},
+	{E: SpecAttrs, A: DbIdent, V: String("sys/spec/attrs"), T: Tx},
+	{E: SpecAttrs, A: AttrType, V: AttrTypeRef, T: Tx},
+	{E: SpecAttrs, A: AttrCardinality, V: AttrCardinalityMany, T: Tx},
+	{E: SpecPreds, A: DbIdent, V: String("sys/spec/preds"), T: Tx},
+	{E: SpecPreds, A: AttrType, V: AttrTypeString, T: Tx},
+	{E: SpecPreds, A: AttrCardinality, V: AttrCardinalityMany, T: Tx},
+	{E: SpecEnsure, A: DbIdent, V: String("sys/spec/ensure"), T: Tx},
+	{E: SpecEnsure, A: AttrType, V: AttrTypeRef, T: Tx},
+	{E: SpecEnsure, A: AttrCardinality, V: AttrCardinalityMany, T: Tx},
+	{E: AttrMin, A: DbIdent, V: String("sys/attr/min"), T: Tx},
+	{E: AttrMin, A: AttrType, V: AttrTypeFloat, T: Tx},
+	{E: AttrMax, A: DbIdent, V: String("sys/attr/max"), T: Tx},
+	{E: AttrMax, A: AttrType, V: AttrTypeFloat, T: Tx},
+	{E: AttrMinLength, A: DbIdent, V: String("sys/attr/length/min"), T: Tx},
+	{E: AttrMinLength, A: AttrType, V: AttrTypeInt, T: Tx},
+	{E: AttrMaxLength, A: DbIdent, V: String("sys/attr/length/max"), T: Tx},
+	{E: AttrMaxLength, A: AttrType, V: AttrTypeInt, T: Tx},
+	{E: AttrRegex, A: DbIdent, V: String("sys/attr/regex"), T: Tx},
+	{E: AttrRegex, A: AttrType, V: AttrTypeString, T: Tx},
+	{E: AttrMinInst, A: DbIdent, V: String("sys/attr/inst/min"), T: Tx},
+	{E: AttrMinInst, A: AttrType, V: AttrTypeInst, T: Tx},
+	{E: AttrMaxInst, A: DbIdent, V: String("sys/attr/inst/max"), T: Tx},
+	{E: AttrMaxInst, A: AttrType, V: AttrTypeInst, T: Tx},
+	{E: AttrPreds, A: DbIdent, V: String("sys/attr/preds"), T: Tx},
+	{E: AttrPreds, A: AttrType, V: AttrTypeString, T: Tx},
+	{E: AttrPreds, A: AttrCardinality, V: AttrCardinalityMany, T: Tx},
+	{E: DbAlias, A: DbIdent, V: String("sys/db/alias"), T: Tx},
+	{E: DbAlias, A: AttrType, V: AttrTypeString, T: Tx},
+	{E: DbAlias, A: AttrCardinality, V: AttrCardinalityMany, T: Tx},
+	{E: DbAlias, A: AttrUnique, V: AttrUniqueIdentity, T: Tx},
 	{E: Tx, A: TxAt, V: Inst(epoch), T: Tx},
 }
 
@@ -72,12 +115,26 @@ var Attrs map[ID]Attr = map[ID]Attr{
 	AttrRefType:     {ID: AttrRefType, Type: AttrTypeRef, Ident: Ident("sys/attr/ref/type")},
 	TxAt:            {ID: TxAt, Type: AttrTypeInst, Ident: Ident("sys/tx/at")},
 	DbRank:          {ID: DbRank, Type: AttrTypeInt, Ident: Ident("sys/db/rank")},
+	SpecAttrs:       {ID: SpecAttrs, Type: AttrTypeRef, Cardinality: AttrCardinalityMany, Ident: Ident("sys/spec/attrs")},
+	SpecPreds:       {ID: SpecPreds, Type: AttrTypeString, Cardinality: AttrCardinalityMany, Ident: Ident("sys/spec/preds")},
+	SpecEnsure:      {ID: SpecEnsure, Type: AttrTypeRef, Cardinality: AttrCardinalityMany, Ident: Ident("sys/spec/ensure")},
+	AttrMin:         {ID: AttrMin, Type: AttrTypeFloat, Ident: Ident("sys/attr/min")},
+	AttrMax:         {ID: AttrMax, Type: AttrTypeFloat, Ident: Ident("sys/attr/max")},
+	AttrMinLength:   {ID: AttrMinLength, Type: AttrTypeInt, Ident: Ident("sys/attr/length/min")},
+	AttrMaxLength:   {ID: AttrMaxLength, Type: AttrTypeInt, Ident: Ident("sys/attr/length/max")},
+	AttrRegex:       {ID: AttrRegex, Type: AttrTypeString, Ident: Ident("sys/attr/regex")},
+	AttrMinInst:     {ID: AttrMinInst, Type: AttrTypeInst, Ident: Ident("sys/attr/inst/min")},
+	AttrMaxInst:     {ID: AttrMaxInst, Type: AttrTypeInst, Ident: Ident("sys/attr/inst/max")},
+	AttrPreds:       {ID: AttrPreds, Type: AttrTypeString, Cardinality: AttrCardinalityMany, Ident: Ident("sys/attr/preds")},
+	DbAlias:         {ID: DbAlias, Type: AttrTypeString, Cardinality: AttrCardinalityMany, Unique: AttrUniqueIdentity, Ident: Ident("sys/db/alias")},
 }
 
 // Idents could also be computed from Datums.
 var Idents map[Ident]ID = map[Ident]ID{
 	Ident("sys/db/ident"):                DbIdent,
 	Ident("sys/attr/unique"):             AttrUnique,
+	Ident("sys/attr/unique/identity"):    AttrUniqueIdentity,
+	Ident("sys/attr/unique/value"):       AttrUniqueValue,
 	Ident("sys/tx/at"):                   TxAt,
 	Ident("sys/attr/type"):               AttrType,
 	Ident("sys/attr/type/ref"):           AttrTypeRef,
@@ -92,6 +149,18 @@ var Idents map[Ident]ID = map[Ident]ID{
 	Ident("sys/attr/ref/type"):           AttrRefType,
 	Ident("sys/attr/ref/type/dependent"): AttrRefTypeDependent,
 	Ident("sys/db/rank"):                 DbRank,
+	Ident("sys/spec/attrs"):              SpecAttrs,
+	Ident("sys/spec/preds"):              SpecPreds,
+	Ident("sys/spec/ensure"):             SpecEnsure,
+	Ident("sys/attr/min"):                AttrMin,
+	Ident("sys/attr/max"):                AttrMax,
+	Ident("sys/attr/length/min"):         AttrMinLength,
+	Ident("sys/attr/length/max"):         AttrMaxLength,
+	Ident("sys/attr/regex"):              AttrRegex,
+	Ident("sys/attr/inst/min"):           AttrMinInst,
+	Ident("sys/attr/inst/max"):           AttrMaxInst,
+	Ident("sys/attr/preds"):              AttrPreds,
+	Ident("sys/db/alias"):                DbAlias,
 }
 
 func ValidValue(typ ID, value Value) (ok bool) {
@@ -112,6 +181,122 @@ func ValidValue(typ ID, value Value) (ok bool) {
 	return
 }
 
+// ValueType returns the type of the attributes whose values are like the value, or zero
+// if there is none.
+func ValueType(value Value) (typ ID) {
+	switch value.(type) {
+	case ID:
+		typ = AttrTypeRef
+	case String:
+		typ = AttrTypeString
+	case Int:
+		typ = AttrTypeInt
+	case Bool:
+		typ = AttrTypeBool
+	case Inst:
+		typ = AttrTypeInst
+	case Float:
+		typ = AttrTypeFloat
+	}
+	return
+}
+
+// ConstraintAttr reports whether the attribute constrains the values of attributes.
+func ConstraintAttr(id ID) bool {
+	switch id {
+	case AttrMin, AttrMax, AttrMinLength, AttrMaxLength, AttrRegex, AttrMinInst, AttrMaxInst, AttrPreds:
+		return true
+	}
+	return false
+}
+
+// ValidConstraint reports whether the constraint attribute may constrain the values of
+// attributes of the type.
+func ValidConstraint(constraint ID, typ ID) bool {
+	switch constraint {
+	case AttrMin, AttrMax:
+		return typ == AttrTypeInt || typ == AttrTypeFloat
+	case AttrMinLength, AttrMaxLength, AttrRegex:
+		return typ == AttrTypeString
+	case AttrMinInst, AttrMaxInst:
+		return typ == AttrTypeInst
+	case AttrPreds:
+		return true
+	}
+	return false
+}
+
+// ConstrainedValue returns the constraint attribute whose bound the value violates, or
+// zero if it violates none. Predicates are not checked.
+func ConstrainedValue(constraints *Constraints, value Value) (violated ID) {
+	if constraints == nil {
+		return
+	}
+	switch value := value.(type) {
+	case Int:
+		violated = constrainedNumber(constraints, Float(value))
+	case Float:
+		violated = constrainedNumber(constraints, value)
+	case String:
+		n := Int(utf8.RuneCountInString(string(value)))
+		switch {
+		case constraints.MinLength != nil && n < *constraints.MinLength:
+			violated = AttrMinLength
+		case constraints.MaxLength != nil && n > *constraints.MaxLength:
+			violated = AttrMaxLength
+		case constraints.Regex != nil && !constraints.Regex.MatchString(string(value)):
+			violated = AttrRegex
+		}
+	case Inst:
+		switch {
+		case constraints.MinInst != nil && time.Time(value).Before(time.Time(*constraints.MinInst)):
+			violated = AttrMinInst
+		case constraints.MaxInst != nil && time.Time(value).After(time.Time(*constraints.MaxInst)):
+			violated = AttrMaxInst
+		}
+	}
+	return
+}
+
+func constrainedNumber(constraints *Constraints, value Float) (violated ID) {
+	switch {
+	case constraints.Min != nil && value < *constraints.Min:
+		violated = AttrMin
+	case constraints.Max != nil && value > *constraints.Max:
+		violated = AttrMax
+	}
+	return
+}
+
+// ConstraintClaims returns the claims asserting the constraints of the attribute.
+func ConstraintClaims(e ERef, constraints *Constraints) (claims []Claim) {
+	if constraints.Min != nil {
+		claims = append(claims, Claim{E: e, A: AttrMin, V: *constraints.Min})
+	}
+	if constraints.Max != nil {
+		claims = append(claims, Claim{E: e, A: AttrMax, V: *constraints.Max})
+	}
+	if constraints.MinLength != nil {
+		claims = append(claims, Claim{E: e, A: AttrMinLength, V: *constraints.MinLength})
+	}
+	if constraints.MaxLength != nil {
+		claims = append(claims, Claim{E: e, A: AttrMaxLength, V: *constraints.MaxLength})
+	}
+	if constraints.Regex != nil {
+		claims = append(claims, Claim{E: e, A: AttrRegex, V: String(constraints.Regex.String())})
+	}
+	if constraints.MinInst != nil {
+		claims = append(claims, Claim{E: e, A: AttrMinInst, V: *constraints.MinInst})
+	}
+	if constraints.MaxInst != nil {
+		claims = append(claims, Claim{E: e, A: AttrMaxInst, V: *constraints.MaxInst})
+	}
+	for _, pred := range constraints.Preds {
+		claims = append(claims, Claim{E: e, A: AttrPreds, V: String(pred)})
+	}
+	return
+}
+
 func ValidUnique(id ID) bool {
 	switch id {
 	case AttrUniqueIdentity:
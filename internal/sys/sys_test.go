@@ -36,6 +36,16 @@ func TestValidValue(t *testing.T) {
 	}
 }
 
+func TestValueType(t *testing.T) {
+	assert.Equal(t, AttrTypeRef, ValueType(ID(7)))
+	assert.Equal(t, AttrTypeString, ValueType(String("x")))
+	assert.Equal(t, AttrTypeInt, ValueType(Int(1)))
+	assert.Equal(t, AttrTypeBool, ValueType(Bool(true)))
+	assert.Equal(t, AttrTypeInst, ValueType(Inst(time.UnixMilli(0).UTC())))
+	assert.Equal(t, AttrTypeFloat, ValueType(Float(1.5)))
+	assert.Equal(t, ID(0), ValueType(nil))
+}
+
 func TestValidUnique(t *testing.T) {
 	assert.True(t, ValidUnique(AttrUniqueIdentity))
 	assert.True(t, ValidUnique(AttrUniqueValue))
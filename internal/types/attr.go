@@ -1,5 +1,7 @@
 package types
 
+import "regexp"
+
 // Attr is a convenient represention of the attributes of an attribute.
 type Attr struct {
 	// ID is the internal identifier of an attribute.
@@ -14,4 +16,27 @@ type Attr struct {
 	Unique ID `attr:"sys/db/unique"`
 	// RefType specifies the reference type, if this is a special reference type.
 	RefType ID `attr:"sys/attr/ref/type"`
+	// Constraints restrict the values that may be asserted for the attribute, if any.
+	Constraints *Constraints
+}
+
+// Constraints restrict the values that may be asserted for an attribute. Nil bounds are
+// not enforced.
+type Constraints struct {
+	// Min is the least value of an int or float attribute.
+	Min *Float
+	// Max is the greatest value of an int or float attribute.
+	Max *Float
+	// MinLength is the least number of characters in a value of a string attribute.
+	MinLength *Int
+	// MaxLength is the greatest number of characters in a value of a string attribute.
+	MaxLength *Int
+	// Regex is the pattern that must match the values of a string attribute.
+	Regex *regexp.Regexp
+	// MinInst is the earliest value of an inst attribute.
+	MinInst *Inst
+	// MaxInst is the latest value of an inst attribute.
+	MaxInst *Inst
+	// Preds are the names of the value predicates the values must satisfy.
+	Preds []string
 }
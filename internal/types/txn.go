@@ -44,17 +44,106 @@ type Claim struct {
 
 // Retraction is a retraction of all attribute values for an entity as well as
 // all of its dependent references, recursively. The constraints must resolve to a
-// single id or the retraction is rejected.
+// single id or the retraction is rejected. If attrs are given, only the values of
+// those attributes are retracted. The values are retracted before the request's claims
+// are applied, so the claims may assert them anew.
 type Retraction struct {
 	Constraints map[IDRef]Void
+	Attrs       map[IDRef]Void
 }
 
+// Condition requires the value of a cardinality one attribute of an entity to be the
+// given value, or to be absent if the value is nil, before a request is applied. A lookup
+// ref to no entity refers to one without values.
+type Condition struct {
+	E IDRef
+	A IDRef
+	V Value
+}
+
+// Computation computes claims from the state of the database before the request that
+// contains it is applied, atomically with the request.
+type Computation interface {
+	IsComputation()
+}
+
+// CAS is a compare-and-swap of the value of a cardinality one attribute of an entity,
+// which asserts the new value if the old value is stored, or if none is and the old value
+// is nil, and rejects the request otherwise.
+type CAS struct {
+	E   IDRef
+	A   IDRef
+	Old Value
+	New VRef
+}
+
+// Increment adds the delta to the value of a cardinality one int attribute of an entity,
+// which is zero if none is stored. The increments of a value in a request accumulate.
+type Increment struct {
+	E     IDRef
+	A     IDRef
+	Delta Int
+}
+
+// Function computes a request from a snapshot of the database before the request that
+// calls it is applied.
+type Function func(snapshot Snapshot, args []any) (req Request, err error)
+
+// Call is a call of a function whose request is applied with the request that contains
+// the call, which the function's error rejects. The tempids of the function's request are
+// local to the call.
+type Call struct {
+	Fn   Function
+	Args []any
+}
+
+func (CAS) IsComputation()       {}
+func (Increment) IsComputation() {}
+func (Call) IsComputation()      {}
+
+// Transaction is the set of changes a transaction makes to a database.
+type Transaction struct {
+	// ID is the id of the transaction.
+	ID ID
+	// Before is the value of the database before the transaction.
+	Before Snapshot
+	// After is the value of the database after the transaction.
+	After Snapshot
+	// Asserted are the datums the transaction adds, in the order they are applied.
+	Asserted []Datum
+	// Retracted are the datums the transaction removes, in the order they are removed.
+	Retracted []Datum
+}
+
+// Validator checks a transaction before it is committed, and rejects it with its error.
+type Validator func(tx Transaction) (err error)
+
+// Predicate checks an entity that conforms to a spec naming it, in a snapshot of the
+// database after a transaction, and rejects the transaction with its error.
+type Predicate func(snapshot Snapshot, e ID) (err error)
+
+// ValuePredicate checks a value asserted for an attribute whose constraints name it, and
+// rejects the value with its error.
+type ValuePredicate func(value Value) (err error)
+
 // Request is a set of claims and constraints on their temporary ids.
 type Request struct {
 	// The list of claims.
 	Claims []Claim
 	// The list of retractions.
 	Retractions []Retraction
+	// The list of conditions, all of which must hold for the request to be applied.
+	Conditions []Condition
+	// The list of computations, whose claims are applied with the request.
+	Computations []Computation
+	// The list of validators, which are given the transaction in order if it changes the
+	// database.
+	Validators []Validator
+	// The predicates the specs of the entities the request changes may name, by their names.
+	Predicates map[string]Predicate
+	// The value predicates the constraints of the attributes the request asserts may name,
+	// by their names.
+	ValuePredicates map[string]ValuePredicate
 }
 
 // Response is the result of trying to apply a request to the database.
@@ -66,6 +155,15 @@ type Response struct {
 	// Snapshot is the value of the database after applying the datums, or
 	// which rejected the datums.
 	Snapshot Snapshot
+	// Before is the value of the database before applying the datums, if successful.
+	Before Snapshot
+	// Asserted are the datums added to the database in the order they were applied, if
+	// successful. Claims of datums already present are omitted.
+	Asserted []Datum
+	// Retracted are the datums removed from the database in the order they were removed,
+	// if successful, including those of retracted entities and the values replaced by
+	// cardinality one assertions. Their transaction ids are that of the response.
+	Retracted []Datum
 	// Error describes why the datums could not be applied.
 	Error error
 }
@@ -76,6 +174,9 @@ type Database interface {
 	Read() Snapshot
 	// Write tries to apply the request to the database.
 	Write(req Request) Response
+	// With tries to apply the request to a copy of a snapshot of the database, leaving
+	// the database as it is.
+	With(snapshot Snapshot, req Request) Response
 }
 
 // Snapshot is an immutable set of datums. Snapshots are safe for concurrent use.
@@ -91,4 +192,8 @@ type Snapshot interface {
 	ResolveIdent(ident Ident) (id ID)
 	// ResolveAttrIdent resolves an attribute id to an ident.
 	ResolveAttrIdent(id ID) (ident Ident)
+	// AliasVersion returns a version of the idents and aliases of the snapshot's attributes,
+	// which changes whenever they do, so resolutions of attributes by their idents and
+	// aliases may be cached by it.
+	AliasVersion() (version uint64)
 }
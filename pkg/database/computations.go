@@ -0,0 +1,109 @@
+package database
+
+import (
+	"github.com/dball/destructive/internal/structs/models"
+	"github.com/dball/destructive/internal/types"
+)
+
+// Swap is a compare-and-swap of the value of a cardinality one attribute of an entity.
+// Values are strings, int64s or ints, bools, float64s, time.Times, or uint64 ids for ref
+// attributes.
+type Swap struct {
+	// ID is the id of the entity.
+	ID uint64
+	// Attr is the ident of the attribute.
+	Attr string
+	// Old is the value that must be stored, or nil if none may be.
+	Old any
+	// New is the value to store.
+	New any
+}
+
+// Increment adds to the value of a cardinality one int attribute of an entity, which is
+// zero if none is stored. The increments of a value in a request accumulate.
+type Increment struct {
+	// ID is the id of the entity.
+	ID uint64
+	// Attr is the ident of the attribute.
+	Attr string
+	// Delta is the amount to add.
+	Delta int64
+}
+
+// Function computes a request from a snapshot of the database before the request that
+// calls it is written, atomically with that request. An error rejects the write. The
+// attributes of the structs in the computed request must already be declared, e.g. by
+// having written structs of their types.
+type Function func(snapshot *Snapshot, args ...any) (req Request, err error)
+
+// Call is a call of a function given in the database's config.
+type Call struct {
+	// Fn is the name of the function.
+	Fn string
+	// Args are given to the function after the snapshot.
+	Args []any
+}
+
+// computations returns the internal computations of the request's swaps, increments and
+// calls, in that order. The snapshots given to the functions have the analyzer.
+func (db *localDatabase) computations(req Request, analyzer models.Analyzer) (computations []types.Computation, err error) {
+	n := len(req.Swaps) + len(req.Increments) + len(req.Calls)
+	if n == 0 {
+		return
+	}
+	computations = make([]types.Computation, 0, n)
+	for _, swap := range req.Swaps {
+		cas := types.CAS{E: types.ID(swap.ID), A: types.Ident(swap.Attr)}
+		ok := true
+		if swap.Old != nil {
+			cas.Old, ok = toValue(swap.Old)
+		}
+		var v types.Value
+		if ok {
+			v, ok = toValue(swap.New)
+		}
+		if !ok {
+			err = types.NewError("database.invalidSwapValue", "swap", swap)
+			return
+		}
+		cas.New = v.(types.VRef)
+		computations = append(computations, cas)
+	}
+	for _, increment := range req.Increments {
+		computations = append(computations, types.Increment{
+			E:     types.ID(increment.ID),
+			A:     types.Ident(increment.Attr),
+			Delta: types.Int(increment.Delta),
+		})
+	}
+	for _, call := range req.Calls {
+		fn, ok := db.functions[call.Fn]
+		if !ok {
+			err = types.NewError("database.invalidFunction", "fn", call.Fn)
+			return
+		}
+		computations = append(computations, types.Call{
+			Fn: func(snap types.Snapshot, args []any) (ireq types.Request, err error) {
+				req, err := fn(&Snapshot{snap: snap, analyzer: analyzer, db: db}, args...)
+				if err != nil {
+					return
+				}
+				ireq, _, err = db.shred(req, analyzer)
+				return
+			},
+			Args: call.Args,
+		})
+	}
+	return
+}
+
+// toValue converts a go value to a system value, treating uint64s as ids.
+func toValue(x any) (value types.Value, ok bool) {
+	switch x := x.(type) {
+	case uint64:
+		return types.ID(x), true
+	case int:
+		return types.Int(x), true
+	}
+	return models.ToValue(x)
+}
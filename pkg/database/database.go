@@ -2,9 +2,11 @@
 package database
 
 import (
+	"fmt"
 	"reflect"
 
 	"github.com/dball/destructive/internal/structs/assemblers"
+	"github.com/dball/destructive/internal/structs/converters"
 	"github.com/dball/destructive/internal/structs/models"
 	"github.com/dball/destructive/internal/types"
 )
@@ -15,18 +17,56 @@ type Database interface {
 	Read() *Snapshot
 	// Write atomically applies the changes in the request to the database.
 	Write(req Request) Response
+	// Subscribe returns a channel of the reports of the transactions subsequently
+	// committed to the database, in commit order, as selected by the filter, and a
+	// function that cancels the subscription and closes the channel. Transactions that
+	// change nothing are not reported.
+	Subscribe(filter Filter) (reports <-chan TxReport, cancel func())
 }
 
 // Snapshot is an immutable set of data.
 type Snapshot struct {
 	snap     types.Snapshot
 	analyzer models.Analyzer
+	// db is the database from which the snapshot was read.
+	db *localDatabase
+}
+
+// With returns the response of writing the request to a copy of the database as of the
+// snapshot, whose snapshot shows the effects the request would have. The database is
+// left as it is, as is the snapshot, from which any number of requests may be tried.
+func (snapshot *Snapshot) With(req Request) (res Response) {
+	db := snapshot.db
+	snap := snapshot.snap
+	// Analyzers cache the structs' fields by attribute ids and alias versions, which the
+	// copy may allocate to attributes the database does not have.
+	analyzer := models.BuildRegistryAnalyzer(snapshot.analyzer.Registry())
+	return db.write(req, analyzer, func(ireq types.Request) (ires types.Response) {
+		ires = db.db.With(snap, ireq)
+		if ires.Error == nil {
+			snap = ires.Snapshot
+		}
+		return
+	})
 }
 
 type typedSnapshot[T any] struct {
 	snapshot *Snapshot
+	options  AssemblyOptions
+}
+
+// AssemblyOptions control the extent of the structs built by a typed snapshot.
+type AssemblyOptions struct {
+	// MaxDepth, if positive, limits the depth of the referent structs built from the
+	// found struct, which is at depth zero. The ref fields of structs at the maximum
+	// depth are left empty, apart from ref ids, lookup refs and lazy refs.
+	MaxDepth int
 }
 
+// Lazy is a ref field type holding a referent T that is built on the first call to its
+// Get method, from the snapshot in which the referring struct was built.
+type Lazy[T any] = assemblers.Lazy[T]
+
 // TypedSnapshot is an immutable set of data that can build instances of specific
 // struct types.
 type TypedSnapshot[T any] interface {
@@ -34,16 +74,92 @@ type TypedSnapshot[T any] interface {
 }
 
 func (ts *typedSnapshot[T]) Find(id uint64) (entity *T) {
-	assembler := assemblers.NewAssembler(ts.snapshot.analyzer, ts.snapshot.snap)
+	options := assemblers.Options{MaxDepth: ts.options.MaxDepth}
+	assembler := assemblers.NewAssemblerWith(ts.snapshot.analyzer, ts.snapshot.snap, options)
 	// TODO log the error or something?
 	entity, _ = assemblers.Assemble[T](assembler, types.ID(id))
 	return
 }
 
 func BuildTypedSnapshot[T any](snapshot *Snapshot) (ts TypedSnapshot[T], err error) {
-	_, err = models.Analyze(reflect.TypeFor[T]())
+	return BuildTypedSnapshotWith[T](snapshot, AssemblyOptions{})
+}
+
+// BuildTypedSnapshotWith returns a typed snapshot whose structs are built to the extent
+// given by the options.
+func BuildTypedSnapshotWith[T any](snapshot *Snapshot, options AssemblyOptions) (ts TypedSnapshot[T], err error) {
+	_, err = snapshot.analyzer.Analyze(reflect.TypeFor[T]())
+	if err == nil {
+		ts = &typedSnapshot[T]{snapshot: snapshot, options: options}
+	}
+	return
+}
+
+// Populate fills the given struct with the entity with the given id, resetting its attr
+// fields first, and reports whether the entity was found. Fields without attr tags are
+// left as they are, so a struct may be reused across snapshots.
+func Populate[T any](snapshot *Snapshot, id uint64, entity *T) (found bool, err error) {
+	assembler := assemblers.NewAssembler(snapshot.analyzer, snapshot.snap)
+	return assemblers.Populate(assembler, types.ID(id), entity)
+}
+
+// Convert returns a To built from the datums of the given struct, as if the struct were
+// written to an empty database and loaded as a To, though no database is written. The
+// structs it references are converted in turn, sharing pointers as the given structs do.
+// The id fields of the To hold the ids of the given structs, if any. Interface fields,
+// codecs and predicates need the config of a database, with which ConvertWith converts.
+func Convert[From, To any](from From) (to To, err error) {
+	tos, err := ConvertSlice[From, To]([]From{from})
 	if err == nil {
-		ts = &typedSnapshot[T]{snapshot: snapshot}
+		to = tos[0]
+	}
+	return
+}
+
+// ConvertSlice converts the structs as Convert does, in the same order. Structs the given
+// structs reference in common are converted once.
+func ConvertSlice[From, To any](froms []From) (tos []To, err error) {
+	return convertSlice[From, To](models.BuildCachingAnalyzer(), converters.Options{}, froms)
+}
+
+// ConvertWith converts the struct as Convert does, with the type bindings, codecs and
+// predicates of the database, which is left as it is.
+func ConvertWith[From, To any](db Database, from From) (to To, err error) {
+	tos, err := ConvertSliceWith[From, To](db, []From{from})
+	if err == nil {
+		to = tos[0]
+	}
+	return
+}
+
+// ConvertSliceWith converts the structs as ConvertWith does, in the same order.
+func ConvertSliceWith[From, To any](db Database, froms []From) (tos []To, err error) {
+	snapshot := db.Read()
+	// Analyzers cache the structs' fields by attribute ids, and the ephemeral database's
+	// are its own, so it has its own analyzer.
+	analyzer := models.BuildRegistryAnalyzer(snapshot.analyzer.Registry())
+	options := converters.Options{
+		Predicates:      snapshot.db.internalPredicates(analyzer),
+		ValuePredicates: snapshot.db.internalValuePredicates(),
+	}
+	return convertSlice[From, To](analyzer, options, froms)
+}
+
+func convertSlice[From, To any](analyzer models.Analyzer, options converters.Options, froms []From) (tos []To, err error) {
+	xs := make([]any, len(froms))
+	for i, from := range froms {
+		xs[i] = from
+	}
+	ptrs, err := converters.Convert[To](analyzer, xs, options)
+	if err != nil {
+		err = writeError(err)
+		return
+	}
+	tos = make([]To, len(ptrs))
+	for i, ptr := range ptrs {
+		if ptr != nil {
+			tos[i] = *ptr
+		}
 	}
 	return
 }
@@ -57,9 +173,78 @@ type Request struct {
 	// Retractions is a list of entities whose attributes will be retracted from the
 	// database after a successful write.
 	Retractions []any
+	// Patches is a list of partial updates of entities, which leave the attributes of
+	// their entities' other fields as they are.
+	Patches []Patch
+	// Swaps is a list of compare-and-swaps of attribute values, which reject the write if
+	// any of their old values are not stored.
+	Swaps []Swap
+	// Increments is a list of additions to int attribute values.
+	Increments []Increment
+	// Calls is a list of calls of the database's functions, whose requests are written
+	// with this request.
+	Calls []Call
 	// Transaction is an entity which, if given, provides attr tag fields that will be
 	// asserted on the transaction of a successful write.
 	Transaction any
+	// Renames is a list of attribute renames. The prior idents of renamed attributes
+	// remain as their aliases, so structs and requests may continue to use them.
+	Renames []Rename
+	// RetiredAliases is a list of attribute aliases that will no longer resolve after a
+	// successful write.
+	RetiredAliases []string
+	// Migrations is a list of changes of the cardinality or uniqueness of attributes,
+	// which struct fields that declare them differently would be rejected for making.
+	Migrations []Migration
+	// RelaxedSpecs is a list of the idents of specs whose required attributes and
+	// predicates are retracted before the request's structs declare theirs. Structs add to
+	// the specs they name, so removing a required or pred directive relaxes a spec only
+	// once it is relaxed. The relaxations are written with the request.
+	RelaxedSpecs []string
+	// RelaxedAttrs is a list of the idents of attributes whose constraints are retracted
+	// before the request's structs declare theirs. Structs add to the constraints of their
+	// fields' attributes, so removing a constraint directive relaxes an attribute only once
+	// it is relaxed.
+	RelaxedAttrs []string
+}
+
+// Rename changes the ident of an attribute, which is named by its ident or one of its
+// aliases.
+type Rename struct {
+	// Attr is the current ident or an alias of the attribute.
+	Attr string
+	// Ident is the new ident of the attribute, which may be one of its aliases.
+	Ident string
+}
+
+// Migration changes the cardinality or uniqueness of an attribute, which is named by its
+// ident or one of its aliases. The write is rejected if the attribute's values do not
+// permit the change.
+type Migration struct {
+	// Attr is the ident or an alias of the attribute.
+	Attr string
+	// Cardinality, if given, is the new cardinality of the attribute,
+	// sys/attr/cardinality/one or sys/attr/cardinality/many.
+	Cardinality string
+	// Unique, if given, is the new uniqueness of the attribute, sys/attr/unique/identity
+	// or sys/attr/unique/value.
+	Unique string
+	// NotUnique indicates that the attribute's values will no longer be unique.
+	NotUnique bool
+}
+
+// Patch is a partial update of an entity from a struct, which must have a nonzero id
+// field. Only the named attr fields, and the attr fields that differ from those of the
+// base struct if one is given, are written. Those that are nil pointers, slices or maps
+// are retracted, apart from reverse fields, which are ignored.
+type Patch struct {
+	// Entity is the struct, or a pointer to it.
+	Entity any
+	// Fields are the names of the struct fields to write.
+	Fields []string
+	// Base, if given, is a struct of the same type, typically as it was read, whose attr
+	// fields that differ from the entity's are written.
+	Base any
 }
 
 // Response specifies the results of trying to write a request to a database.
@@ -73,4 +258,106 @@ type Response struct {
 	Error error
 	// IDs contains the list of ids of the asserted entities in the same order.
 	IDs []uint64
+	// Before is the immutable set of data before the request was written, if successful.
+	Before *Snapshot
+	// Asserted are the datums the request added, in the order they were added, if
+	// successful. Values that were already present are omitted.
+	Asserted []Datum
+	// Retracted are the datums the request removed, in the order they were removed, if
+	// successful, including the values replaced by cardinality one assertions.
+	Retracted []Datum
+}
+
+// Datum is a value of an attribute of an entity, as asserted or retracted by a
+// transaction.
+type Datum struct {
+	// E is the id of the entity.
+	E uint64
+	// A is the ident of the attribute.
+	A string
+	// V is a string, int64, bool, float64 or time.Time, or a uint64 id for ref
+	// attributes.
+	V any
+	// T is the id of the transaction.
+	T uint64
+}
+
+// ConflictError is the error of a write rejected because the stored version of an
+// entity differs from the version of its struct, typically because the entity has been
+// written since the struct was loaded.
+type ConflictError struct {
+	// ID is the id of the entity, which is zero for a struct without an id whose identity
+	// refers to no entity.
+	ID uint64
+	// Attr is the ident of the version attribute.
+	Attr string
+	// Expected is the version of the struct, where zero indicates no stored version.
+	Expected int64
+	// Actual is the stored version, where zero indicates no stored version.
+	Actual int64
+}
+
+func (err ConflictError) Error() string {
+	return fmt.Sprintf("database.conflict: %d %s expected %d actual %d", err.ID, err.Attr, err.Expected, err.Actual)
+}
+
+// SpecError is the error of a write rejected because an entity whose datums it changed
+// would not conform to a spec, by lacking a required attribute or failing a predicate.
+type SpecError struct {
+	// ID is the id of the entity.
+	ID uint64
+	// Spec is the ident of the spec.
+	Spec string
+	// Attr is the ident of the missing attribute, if any.
+	Attr string
+	// Pred is the name of the failed or unknown predicate, if any.
+	Pred string
+	// Err is the error of the failed predicate, if any.
+	Err error
+}
+
+func (err SpecError) Error() string {
+	switch {
+	case err.Attr != "":
+		return fmt.Sprintf("database.spec: %d %s requires %s", err.ID, err.Spec, err.Attr)
+	case err.Err != nil:
+		return fmt.Sprintf("database.spec: %d %s fails %s: %v", err.ID, err.Spec, err.Pred, err.Err)
+	}
+	return fmt.Sprintf("database.spec: %d %s names unknown predicate %s", err.ID, err.Spec, err.Pred)
+}
+
+func (err SpecError) Unwrap() error {
+	return err.Err
+}
+
+// ConstraintError is the error of a write rejected because a value it asserted violates
+// a constraint of its attribute, or fails one of its value predicates.
+type ConstraintError struct {
+	// ID is the id of the entity.
+	ID uint64
+	// Attr is the ident of the attribute.
+	Attr string
+	// Value is the rejected value.
+	Value any
+	// Constraint is the ident of the violated constraint attribute, if any, e.g.
+	// sys/attr/max.
+	Constraint string
+	// Pred is the name of the failed or unknown value predicate, if any.
+	Pred string
+	// Err is the error of the failed value predicate, if any.
+	Err error
+}
+
+func (err ConstraintError) Error() string {
+	switch {
+	case err.Constraint != "":
+		return fmt.Sprintf("database.constraint: %d %s %v violates %s", err.ID, err.Attr, err.Value, err.Constraint)
+	case err.Err != nil:
+		return fmt.Sprintf("database.constraint: %d %s %v fails %s: %v", err.ID, err.Attr, err.Value, err.Pred, err.Err)
+	}
+	return fmt.Sprintf("database.constraint: %d %s %v names unknown predicate %s", err.ID, err.Attr, err.Value, err.Pred)
+}
+
+func (err ConstraintError) Unwrap() error {
+	return err.Err
 }
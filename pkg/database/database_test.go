@@ -1,8 +1,14 @@
 package database
 
 import (
+	"errors"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"sync"
 	"testing"
 
+	"github.com/dball/destructive/internal/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -76,3 +82,1437 @@ func TestDatabase(t *testing.T) {
 		assert.Nil(t, person)
 	})
 }
+
+type party interface {
+	partyName() string
+}
+
+type partyPerson struct {
+	ID   uint64 `attr:"sys/db/id"`
+	Name string `attr:"person/name"`
+}
+
+func (p *partyPerson) partyName() string { return p.Name }
+
+type partyCompany struct {
+	ID   uint64 `attr:"sys/db/id"`
+	Name string `attr:"company/name"`
+}
+
+func (c *partyCompany) partyName() string { return c.Name }
+
+func TestInterfaceFields(t *testing.T) {
+	type Account struct {
+		ID    uint64 `attr:"sys/db/id"`
+		Owner party  `attr:"account/owner"`
+	}
+
+	db, err := NewDatabaseWith(Config{Types: []TypeBinding{
+		{Type: reflect.TypeFor[*partyPerson](), Attr: "party/type", Ident: "party/type/person"},
+		{Type: reflect.TypeFor[*partyCompany](), Attr: "party/type", Ident: "party/type/company"},
+	}})
+	assert.NoError(t, err)
+	res := db.Write(Request{Assertions: []any{
+		Account{Owner: &partyPerson{Name: "Donald"}},
+	}})
+	assert.NoError(t, res.Error)
+	personal := res.IDs[0]
+	res = db.Write(Request{Assertions: []any{
+		Account{Owner: &partyCompany{Name: "Acme"}},
+	}})
+	assert.NoError(t, res.Error)
+	corporate := res.IDs[0]
+
+	snapshot, err := BuildTypedSnapshot[Account](res.Snap)
+	assert.NoError(t, err)
+	account := snapshot.Find(personal)
+	assert.Equal(t, "Donald", account.Owner.partyName())
+	assert.IsType(t, &partyPerson{}, account.Owner)
+	account = snapshot.Find(corporate)
+	assert.Equal(t, "Acme", account.Owner.partyName())
+	assert.IsType(t, &partyCompany{}, account.Owner)
+
+	_, err = NewDatabaseWith(Config{Types: []TypeBinding{
+		{Type: reflect.TypeFor[string](), Attr: "party/type", Ident: "party/type/string"},
+	}})
+	assert.Error(t, err)
+}
+
+func TestCodecFields(t *testing.T) {
+	type Site struct {
+		ID   uint64     `attr:"sys/db/id"`
+		Addr netip.Addr `attr:"site/addr"`
+		Home url.URL    `attr:"site/home"`
+	}
+
+	db, err := NewDatabaseWith(Config{Codecs: []Codec{
+		{
+			Type: reflect.TypeFor[url.URL](),
+			Encode: func(x any) (any, error) {
+				u := x.(url.URL)
+				return u.String(), nil
+			},
+			Decode: func(y any) (any, error) {
+				u, err := url.Parse(y.(string))
+				if err != nil {
+					return nil, err
+				}
+				return *u, nil
+			},
+		},
+	}})
+	assert.NoError(t, err)
+	home, err := url.Parse("https://example.com/home")
+	assert.NoError(t, err)
+	site := Site{Addr: netip.MustParseAddr("192.168.1.1"), Home: *home}
+	res := db.Write(Request{Assertions: []any{site}})
+	assert.NoError(t, res.Error)
+	snapshot, err := BuildTypedSnapshot[Site](res.Snap)
+	assert.NoError(t, err)
+	site.ID = res.IDs[0]
+	assert.Equal(t, site, *snapshot.Find(res.IDs[0]))
+}
+
+func TestLookupFields(t *testing.T) {
+	type Person struct {
+		ID    uint64 `attr:"sys/db/id"`
+		Email string `attr:"person/email,identity"`
+	}
+	type Order struct {
+		ID       uint64 `attr:"sys/db/id"`
+		Customer string `attr:"order/customer,lookup=person/email"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Email: "a@example.com"}}})
+	assert.NoError(t, res.Error)
+	person := res.IDs[0]
+	res = db.Write(Request{Assertions: []any{Order{Customer: "a@example.com"}}})
+	assert.NoError(t, res.Error)
+	order := res.IDs[0]
+	assert.True(t, res.Snap.snap.Has(types.Claim{E: types.ID(order), A: types.Ident("order/customer"), V: types.ID(person)}))
+	snapshot, err := BuildTypedSnapshot[Order](res.Snap)
+	assert.NoError(t, err)
+	assert.Equal(t, Order{ID: order, Customer: "a@example.com"}, *snapshot.Find(order))
+
+	res = db.Write(Request{Assertions: []any{Order{Customer: "b@example.com"}}})
+	assert.Error(t, res.Error)
+}
+
+func TestReverseFields(t *testing.T) {
+	type Pet struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"pet/name"`
+	}
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name,identity"`
+		Pets []Pet  `attr:"pet/owner,reverse"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{
+		Person{Name: "Donald", Pets: []Pet{{Name: "Momo"}, {Name: "Rex"}}},
+	}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+	res = db.Write(Request{Assertions: []any{
+		Person{Name: "Stephen", Pets: []Pet{{Name: "Fifi"}}},
+	}})
+	assert.NoError(t, res.Error)
+
+	snapshot, err := BuildTypedSnapshot[Person](res.Snap)
+	assert.NoError(t, err)
+	person := snapshot.Find(donald)
+	assert.Equal(t, "Donald", person.Name)
+	if assert.Len(t, person.Pets, 2) {
+		assert.Equal(t, "Momo", person.Pets[0].Name)
+		assert.Equal(t, "Rex", person.Pets[1].Name)
+		assert.NotZero(t, person.Pets[0].ID)
+	}
+}
+
+func TestAssemblyOptions(t *testing.T) {
+	type Person struct {
+		ID   uint64  `attr:"sys/db/id"`
+		Name string  `attr:"person/name,identity"`
+		BFF  *Person `attr:"person/bff"`
+	}
+	type Pet struct {
+		ID    uint64  `attr:"sys/db/id"`
+		Name  string  `attr:"pet/name"`
+		Owner *Person `attr:"pet/owner"`
+	}
+	type LazyPet struct {
+		ID    uint64       `attr:"sys/db/id"`
+		Name  string       `attr:"pet/name"`
+		Owner Lazy[Person] `attr:"pet/owner"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{
+		Person{Name: "Donald", BFF: &Person{Name: "Stephen", BFF: &Person{Name: "Diane"}}},
+	}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+	res = db.Write(Request{Assertions: []any{Pet{Name: "Momo", Owner: &Person{Name: "Donald"}}}})
+	assert.NoError(t, res.Error)
+	momo := res.IDs[0]
+
+	people, err := BuildTypedSnapshotWith[Person](res.Snap, AssemblyOptions{MaxDepth: 1})
+	assert.NoError(t, err)
+	person := people.Find(donald)
+	assert.Equal(t, "Stephen", person.BFF.Name)
+	assert.Nil(t, person.BFF.BFF)
+
+	lazyPets, err := BuildTypedSnapshot[LazyPet](res.Snap)
+	assert.NoError(t, err)
+	lazyPet := lazyPets.Find(momo)
+	assert.Equal(t, donald, uint64(lazyPet.Owner.RefID()))
+	owner, err := lazyPet.Owner.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "Stephen", owner.BFF.Name)
+
+	// A lazy ref is recorded as it was loaded.
+	lazyPet.Name = "Momo II"
+	res = db.Write(Request{Assertions: []any{*lazyPet}})
+	assert.NoError(t, res.Error)
+	pets, err := BuildTypedSnapshot[Pet](res.Snap)
+	assert.NoError(t, err)
+	pet := pets.Find(momo)
+	assert.Equal(t, "Momo II", pet.Name)
+	assert.Equal(t, "Donald", pet.Owner.Name)
+}
+
+func TestPopulate(t *testing.T) {
+	type Person struct {
+		ID    uint64  `attr:"sys/db/id"`
+		Name  string  `attr:"person/name,identity"`
+		Age   int     `attr:"person/age"`
+		BFF   *Person `attr:"person/bff"`
+		Notes string
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 48}}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+
+	person := Person{Notes: "view"}
+	found, err := Populate(res.Snap, donald, &person)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Person{ID: donald, Name: "Donald", Age: 48, Notes: "view"}, person)
+
+	res = db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 49, BFF: &Person{Name: "Stephen"}}}})
+	assert.NoError(t, res.Error)
+	found, err = Populate(res.Snap, donald, &person)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 49, person.Age)
+	assert.Equal(t, "Stephen", person.BFF.Name)
+	assert.Equal(t, "view", person.Notes)
+}
+
+func TestPatches(t *testing.T) {
+	type Person struct {
+		ID       uint64  `attr:"sys/db/id"`
+		Name     string  `attr:"person/name"`
+		Age      int     `attr:"person/age"`
+		Nickname *string `attr:"person/nickname"`
+	}
+
+	db := NewDatabase(Config{})
+	nickname := "Don"
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 48, Nickname: &nickname}}})
+	assert.NoError(t, res.Error)
+	id := res.IDs[0]
+	ts, err := BuildTypedSnapshot[Person](res.Snap)
+	assert.NoError(t, err)
+	stale := *ts.Find(id)
+
+	// A concurrent edit changes the age.
+	res = db.Write(Request{Patches: []Patch{{Entity: Person{ID: id, Age: 49}, Fields: []string{"Age"}}}})
+	assert.NoError(t, res.Error)
+	assert.Empty(t, res.IDs)
+
+	// The stale struct changes only the name and nickname.
+	edited := stale
+	edited.Name = "Stephen"
+	edited.Nickname = nil
+	res = db.Write(Request{Patches: []Patch{{Entity: &edited, Base: stale}}})
+	assert.NoError(t, res.Error)
+	ts, err = BuildTypedSnapshot[Person](res.Snap)
+	assert.NoError(t, err)
+	assert.Equal(t, &Person{ID: id, Name: "Stephen", Age: 49}, ts.Find(id))
+
+	res = db.Write(Request{Patches: []Patch{{Entity: Person{Name: "Donald"}, Fields: []string{"Name"}}}})
+	assert.Error(t, res.Error)
+}
+
+func TestPatchCollections(t *testing.T) {
+	type Toy struct {
+		Name  string `attr:"toy/name"`
+		Color string `attr:"toy/color"`
+	}
+	type Person struct {
+		ID   uint64         `attr:"sys/db/id"`
+		Name string         `attr:"person/name"`
+		Tags []string       `attr:"person/tags,value=person/tag"`
+		Toys map[string]Toy `attr:"person/toys,key=toy/name"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{
+		Name: "Donald",
+		Tags: []string{"a", "b"},
+		Toys: map[string]Toy{"ball": {Name: "ball", Color: "red"}, "bat": {Name: "bat", Color: "brown"}},
+	}}})
+	assert.NoError(t, res.Error)
+	id := res.IDs[0]
+
+	// Patched collections replace the stored values.
+	res = db.Write(Request{Patches: []Patch{{
+		Entity: Person{ID: id, Tags: []string{"c"}, Toys: map[string]Toy{"ball": {Name: "ball", Color: "blue"}}},
+		Fields: []string{"Tags", "Toys"},
+	}}})
+	assert.NoError(t, res.Error)
+	ts, err := BuildTypedSnapshot[Person](res.Snap)
+	assert.NoError(t, err)
+	expected := Person{ID: id, Name: "Donald", Tags: []string{"c"}, Toys: map[string]Toy{"ball": {Name: "ball", Color: "blue"}}}
+	assert.Equal(t, &expected, ts.Find(id))
+}
+
+func TestPatchCollectionsKeepingValues(t *testing.T) {
+	type Pal struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"pal/name"`
+	}
+	type Person struct {
+		ID   uint64   `attr:"sys/db/id"`
+		Name string   `attr:"person/name"`
+		Pets []uint64 `attr:"person/pets,ref"`
+		Pals []Pal    `attr:"person/pals"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Pal{Name: "cat"}, Pal{Name: "dog"}, Pal{Name: "Ada"}, Pal{Name: "Bob"}}})
+	assert.NoError(t, res.Error)
+	cat, dog, ada, bob := res.IDs[0], res.IDs[1], res.IDs[2], res.IDs[3]
+	res = db.Write(Request{Assertions: []any{Person{Name: "Donald", Pets: []uint64{cat}, Pals: []Pal{{ID: ada, Name: "Ada"}}}}})
+	assert.NoError(t, res.Error)
+	id := res.IDs[0]
+
+	// The stored values that remain in the patched collections are kept.
+	res = db.Write(Request{Patches: []Patch{{
+		Entity: Person{ID: id, Pets: []uint64{cat, dog}, Pals: []Pal{{ID: ada, Name: "Ada"}, {ID: bob, Name: "Bob"}}},
+		Fields: []string{"Pets", "Pals"},
+	}}})
+	assert.NoError(t, res.Error)
+	ts, err := BuildTypedSnapshot[Person](res.Snap)
+	assert.NoError(t, err)
+	expected := Person{ID: id, Name: "Donald", Pets: []uint64{cat, dog}, Pals: []Pal{{ID: ada, Name: "Ada"}, {ID: bob, Name: "Bob"}}}
+	assert.Equal(t, &expected, ts.Find(id))
+}
+
+func TestVersionFields(t *testing.T) {
+	type Person struct {
+		ID      uint64 `attr:"sys/db/id"`
+		Name    string `attr:"person/name"`
+		Version int    `attr:"person/version,version"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald"}}})
+	assert.NoError(t, res.Error)
+	id := res.IDs[0]
+	ts, err := BuildTypedSnapshot[Person](res.Snap)
+	assert.NoError(t, err)
+	loaded := ts.Find(id)
+	assert.Equal(t, &Person{ID: id, Name: "Donald", Version: 1}, loaded)
+
+	edited := *loaded
+	edited.Name = "Stephen"
+	res = db.Write(Request{Assertions: []any{edited}})
+	assert.NoError(t, res.Error)
+
+	// The loaded struct is now stale.
+	res = db.Write(Request{Patches: []Patch{{Entity: Person{ID: id, Name: "Don", Version: 1}, Fields: []string{"Name"}}}})
+	var conflict ConflictError
+	if assert.ErrorAs(t, res.Error, &conflict) {
+		assert.Equal(t, ConflictError{ID: id, Attr: "person/version", Expected: 1, Actual: 2}, conflict)
+	}
+	ts, err = BuildTypedSnapshot[Person](db.Read())
+	assert.NoError(t, err)
+	assert.Equal(t, &Person{ID: id, Name: "Stephen", Version: 2}, ts.Find(id))
+}
+
+func TestVersionFieldsUpsert(t *testing.T) {
+	type Person struct {
+		ID      uint64 `attr:"sys/db/id"`
+		Name    string `attr:"person/name,identity"`
+		Age     int    `attr:"person/age"`
+		Version int    `attr:"person/version,version"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 48}}})
+	assert.NoError(t, res.Error)
+	id := res.IDs[0]
+
+	// A struct without an id is guarded against the entity its identity refers to.
+	res = db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 99}}})
+	var conflict ConflictError
+	if assert.ErrorAs(t, res.Error, &conflict) {
+		assert.Equal(t, ConflictError{ID: id, Attr: "person/version", Expected: 0, Actual: 1}, conflict)
+	}
+	res = db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 49, Version: 1}}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []uint64{id}, res.IDs)
+
+	// New entities have no stored versions.
+	res = db.Write(Request{Assertions: []any{Person{Name: "Stephen", Version: 1}}})
+	if assert.ErrorAs(t, res.Error, &conflict) {
+		assert.Equal(t, ConflictError{Attr: "person/version", Expected: 1}, conflict)
+	}
+	res = db.Write(Request{Assertions: []any{Person{Name: "Stephen"}}})
+	assert.NoError(t, res.Error)
+
+	ts, err := BuildTypedSnapshot[Person](res.Snap)
+	assert.NoError(t, err)
+	assert.Equal(t, &Person{ID: id, Name: "Donald", Age: 49, Version: 2}, ts.Find(id))
+}
+
+func TestComputations(t *testing.T) {
+	type Account struct {
+		ID      uint64 `attr:"sys/db/id"`
+		Owner   string `attr:"account/owner,identity"`
+		Status  string `attr:"account/status"`
+		Balance int    `attr:"account/balance"`
+	}
+
+	// transfer moves an amount between the accounts of the owners if the payer can
+	// afford it.
+	transfer := func(snapshot *Snapshot, args ...any) (req Request, err error) {
+		payer, payee, amount := args[0].(uint64), args[1].(uint64), args[2].(int64)
+		ts, err := BuildTypedSnapshot[Account](snapshot)
+		if err != nil {
+			return
+		}
+		if int64(ts.Find(payer).Balance) < amount {
+			err = errors.New("insufficient funds")
+			return
+		}
+		req.Increments = []Increment{
+			{ID: payer, Attr: "account/balance", Delta: -amount},
+			{ID: payee, Attr: "account/balance", Delta: amount},
+		}
+		return
+	}
+	db := NewDatabase(Config{Functions: map[string]Function{"transfer": transfer}})
+	res := db.Write(Request{Assertions: []any{
+		Account{Owner: "Donald", Status: "open", Balance: 100},
+		Account{Owner: "Stephen", Status: "open"},
+	}})
+	assert.NoError(t, res.Error)
+	donald, stephen := res.IDs[0], res.IDs[1]
+
+	res = db.Write(Request{
+		Swaps:      []Swap{{ID: stephen, Attr: "account/status", Old: "open", New: "frozen"}},
+		Increments: []Increment{{ID: donald, Attr: "account/balance", Delta: 10}},
+		Calls:      []Call{{Fn: "transfer", Args: []any{donald, stephen, int64(30)}}},
+	})
+	assert.NoError(t, res.Error)
+	ts, err := BuildTypedSnapshot[Account](res.Snap)
+	assert.NoError(t, err)
+	assert.Equal(t, &Account{ID: donald, Owner: "Donald", Status: "open", Balance: 80}, ts.Find(donald))
+	assert.Equal(t, &Account{ID: stephen, Owner: "Stephen", Status: "frozen", Balance: 30}, ts.Find(stephen))
+
+	res = db.Write(Request{Swaps: []Swap{{ID: stephen, Attr: "account/status", Old: "open", New: "closed"}}})
+	assert.Error(t, res.Error)
+	res = db.Write(Request{Calls: []Call{{Fn: "transfer", Args: []any{stephen, donald, int64(31)}}}})
+	assert.EqualError(t, res.Error, "insufficient funds")
+	res = db.Write(Request{Calls: []Call{{Fn: "embezzle"}}})
+	assert.Error(t, res.Error)
+}
+
+func TestWith(t *testing.T) {
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name,identity"`
+		Age  int    `attr:"person/age"`
+	}
+	type Pet struct {
+		ID    uint64  `attr:"sys/db/id"`
+		Name  string  `attr:"pet/name"`
+		Owner *Person `attr:"pet/owner"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 48}}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+	snapshot := res.Snap
+
+	// The pet's attributes are declared only in the speculative snapshot.
+	res = snapshot.With(Request{Assertions: []any{&Pet{Name: "Momo", Owner: &Person{ID: donald, Name: "Donald", Age: 49}}}})
+	assert.NoError(t, res.Error)
+	momo := res.IDs[0]
+	pets, err := BuildTypedSnapshot[Pet](res.Snap)
+	assert.NoError(t, err)
+	assert.Equal(t, &Pet{ID: momo, Name: "Momo", Owner: &Person{ID: donald, Name: "Donald", Age: 49}}, pets.Find(momo))
+	res = res.Snap.With(Request{Patches: []Patch{{Entity: Person{ID: donald, Age: 50}, Fields: []string{"Age"}}}})
+	assert.NoError(t, res.Error)
+	pets, err = BuildTypedSnapshot[Pet](res.Snap)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, pets.Find(momo).Owner.Age)
+
+	people, err := BuildTypedSnapshot[Person](db.Read())
+	assert.NoError(t, err)
+	assert.Equal(t, &Person{ID: donald, Name: "Donald", Age: 48}, people.Find(donald))
+	pets, err = BuildTypedSnapshot[Pet](db.Read())
+	assert.NoError(t, err)
+	assert.Nil(t, pets.Find(momo))
+
+	// The database may allocate the speculative ids to other attributes.
+	type Toy struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"toy/name"`
+	}
+	res = db.Write(Request{Assertions: []any{Toy{Name: "ball"}}})
+	assert.NoError(t, res.Error)
+	toys, err := BuildTypedSnapshot[Toy](res.Snap)
+	assert.NoError(t, err)
+	assert.Equal(t, &Toy{ID: res.IDs[0], Name: "ball"}, toys.Find(res.IDs[0]))
+}
+
+func TestResponseDeltas(t *testing.T) {
+	type Person struct {
+		ID   uint64  `attr:"sys/db/id"`
+		Name string  `attr:"person/name,identity"`
+		Age  int     `attr:"person/age"`
+		BFF  *Person `attr:"person/bff"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 48}}})
+	assert.NoError(t, res.Error)
+	donald, t1 := res.IDs[0], res.Asserted[0].T
+	assert.Equal(t, []Datum{
+		{E: donald, A: "person/name", V: "Donald", T: t1},
+		{E: donald, A: "person/age", V: int64(48), T: t1},
+	}, res.Asserted)
+	assert.Empty(t, res.Retracted)
+
+	res = db.Write(Request{Assertions: []any{Person{ID: donald, Name: "Donald", Age: 49, BFF: &Person{Name: "Stephen", Age: 50}}}})
+	assert.NoError(t, res.Error)
+	if !assert.Len(t, res.Asserted, 4) {
+		return
+	}
+	t2, stephen := res.Asserted[0].T, res.Asserted[2].E
+	assert.Equal(t, []Datum{
+		{E: donald, A: "person/age", V: int64(49), T: t2},
+		{E: donald, A: "person/bff", V: stephen, T: t2},
+		{E: stephen, A: "person/name", V: "Stephen", T: t2},
+		{E: stephen, A: "person/age", V: int64(50), T: t2},
+	}, res.Asserted)
+	assert.Equal(t, []Datum{
+		{E: donald, A: "person/age", V: int64(48), T: t2},
+	}, res.Retracted)
+	before, err := BuildTypedSnapshot[Person](res.Before)
+	assert.NoError(t, err)
+	assert.Equal(t, &Person{ID: donald, Name: "Donald", Age: 48}, before.Find(donald))
+}
+
+func TestSubscribe(t *testing.T) {
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name,identity"`
+		Age  int    `attr:"person/age"`
+	}
+
+	db := NewDatabase(Config{})
+	all, cancelAll := db.Subscribe(Filter{})
+	ages, cancelAges := db.Subscribe(Filter{Attrs: []string{"person/age"}})
+	defer cancelAges()
+	slow, _ := db.Subscribe(Filter{Buffer: 1})
+	dropped, cancelDropped := db.Subscribe(Filter{Buffer: 1, Policy: Drop})
+	defer cancelDropped()
+
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 48}}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+	byID, cancelByID := db.Subscribe(Filter{IDs: []uint64{donald}})
+	defer cancelByID()
+	res = db.Write(Request{Patches: []Patch{{Entity: Person{ID: donald, Age: 49}, Fields: []string{"Age"}}}})
+	assert.NoError(t, res.Error)
+	res = db.Write(Request{Assertions: []any{Person{Name: "Stephen"}}})
+	assert.NoError(t, res.Error)
+
+	// The schema and the three writes are reported in order.
+	var reports []TxReport
+	for range 4 {
+		reports = append(reports, <-all)
+	}
+	for i := 1; i < len(reports); i++ {
+		assert.Greater(t, reports[i].ID, reports[i-1].ID)
+	}
+	assert.Equal(t, []Datum{{E: donald, A: "person/age", V: int64(49), T: reports[2].ID}}, reports[2].Asserted)
+	assert.Equal(t, []Datum{{E: donald, A: "person/age", V: int64(48), T: reports[2].ID}}, reports[2].Retracted)
+	before, err := BuildTypedSnapshot[Person](reports[2].Before)
+	assert.NoError(t, err)
+	assert.Equal(t, 48, before.Find(donald).Age)
+	after, err := BuildTypedSnapshot[Person](reports[2].After)
+	assert.NoError(t, err)
+	assert.Equal(t, 49, after.Find(donald).Age)
+	cancelAll()
+	_, ok := <-all
+	assert.False(t, ok)
+	cancelAll()
+
+	// Only the reports with selected datums are delivered, with only those datums.
+	report := <-ages
+	assert.Equal(t, []Datum{{E: donald, A: "person/age", V: int64(48), T: report.ID}}, report.Asserted)
+	report = <-ages
+	assert.Equal(t, []Datum{{E: donald, A: "person/age", V: int64(49), T: report.ID}}, report.Asserted)
+	report = <-ages
+	assert.Equal(t, "person/age", report.Asserted[0].A)
+	assert.NotEqual(t, donald, report.Asserted[0].E)
+	assert.Empty(t, ages)
+	report = <-byID
+	assert.Equal(t, reports[2].ID, report.ID)
+	assert.Empty(t, byID)
+
+	// The slow subscriber is disconnected, and the dropping one keeps its first report.
+	assert.Equal(t, reports[0].ID, (<-slow).ID)
+	_, ok = <-slow
+	assert.False(t, ok)
+	assert.Equal(t, reports[0].ID, (<-dropped).ID)
+	assert.Empty(t, dropped)
+	res = db.Write(Request{Assertions: []any{Person{Name: "Mickey"}}})
+	assert.NoError(t, res.Error)
+	assert.Len(t, dropped, 1)
+}
+
+func TestSubscribeBlocking(t *testing.T) {
+	type Person struct {
+		Name string `attr:"person/name,identity"`
+	}
+
+	db := NewDatabase(Config{})
+	reports, cancel := db.Subscribe(Filter{Buffer: 1, Policy: Block})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, name := range []string{"Donald", "Stephen", "Mickey"} {
+			assert.NoError(t, db.Write(Request{Assertions: []any{Person{Name: name}}}).Error)
+		}
+	}()
+	var names []any
+	for range 4 {
+		report := <-reports
+		for _, datum := range report.Asserted {
+			if datum.A == "person/name" {
+				names = append(names, datum.V)
+			}
+		}
+	}
+	<-done
+	assert.Equal(t, []any{"Donald", "Stephen", "Mickey"}, names)
+
+	// Cancelling releases a blocked write.
+	assert.NoError(t, db.Write(Request{Assertions: []any{Person{Name: "Goofy"}}}).Error)
+	go cancel()
+	assert.NoError(t, db.Write(Request{Assertions: []any{Person{Name: "Pluto"}}}).Error)
+}
+
+func TestConvert(t *testing.T) {
+	type Pet struct {
+		Name string `attr:"pet/name"`
+	}
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name"`
+		Pets []Pet  `attr:"person/pets"`
+	}
+	type Named struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name"`
+	}
+
+	named, err := Convert[Person, Named](Person{ID: 0x200000, Name: "Donald", Pets: []Pet{{Name: "Momo"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, Named{ID: 0x200000, Name: "Donald"}, named)
+
+	people, err := ConvertSlice[Named, Person]([]Named{{Name: "Donald"}, {Name: "Stephen"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Person{{Name: "Donald"}, {Name: "Stephen"}}, people)
+}
+
+func TestConvertWith(t *testing.T) {
+	type Account struct {
+		Owner party `attr:"account/owner"`
+	}
+	type Owned struct {
+		Owner *partyPerson `attr:"account/owner"`
+	}
+
+	_, err := Convert[Account, Owned](Account{Owner: &partyPerson{Name: "Donald"}})
+	assert.Error(t, err)
+
+	db, err := NewDatabaseWith(Config{Types: []TypeBinding{
+		{Type: reflect.TypeFor[*partyPerson](), Attr: "party/type", Ident: "party/type/person"},
+	}})
+	assert.NoError(t, err)
+	owned, err := ConvertWith[Account, Owned](db, Account{Owner: &partyPerson{Name: "Donald"}})
+	assert.NoError(t, err)
+	assert.Equal(t, Owned{Owner: &partyPerson{Name: "Donald"}}, owned)
+	// The database is left as it is.
+	assert.Empty(t, db.Read().Schema())
+}
+
+type generatedSensor struct {
+	ID    uint64   `attr:"sys/db/id"`
+	Name  string   `attr:"sensor/name,identity"`
+	Reads int      `attr:"sensor/reads"`
+	Level *float64 `attr:"sensor/level"`
+	Label *string  `attr:"sensor/label,ignoreempty"`
+}
+
+// generatedSensorCalls counts the calls to the generated functions of generatedSensor.
+var generatedSensorCalls struct{ shred, assemble int }
+
+func init() {
+	// This is the output of destructive-gen, instrumented.
+	RegisterGenerated(GeneratedStruct[generatedSensor]{
+		Attrs: []GeneratedAttr{
+			{Ident: "sensor/name", Type: "sys/attr/type/string", Unique: "sys/attr/unique/identity"},
+			{Ident: "sensor/reads", Type: "sys/attr/type/int"},
+			{Ident: "sensor/level", Type: "sys/attr/type/float"},
+			{Ident: "sensor/label", Type: "sys/attr/type/string"},
+		},
+		Shred: func(x *generatedSensor, emit func(ident string, v any)) {
+			generatedSensorCalls.shred++
+			emit("sys/db/id", x.ID)
+			emit("sensor/name", x.Name)
+			emit("sensor/reads", int64(x.Reads))
+			if x.Level != nil {
+				emit("sensor/level", *x.Level)
+			}
+			if x.Label != nil && *x.Label != "" {
+				emit("sensor/label", *x.Label)
+			}
+		},
+		Assemble: func(x *generatedSensor, ident string, v any) (ok bool) {
+			generatedSensorCalls.assemble++
+			switch ident {
+			case "sys/db/id":
+				x.ID, ok = v.(uint64)
+			case "sensor/name":
+				x.Name, ok = v.(string)
+			case "sensor/reads":
+				var y int64
+				if y, ok = v.(int64); ok {
+					x.Reads = int(y)
+				}
+			case "sensor/level":
+				var y float64
+				if y, ok = v.(float64); ok {
+					x.Level = &y
+				}
+			case "sensor/label":
+				var y string
+				if y, ok = v.(string); ok {
+					x.Label = &y
+				}
+			}
+			return
+		},
+	})
+}
+
+func TestGeneratedStructs(t *testing.T) {
+	type Site struct {
+		Name    string            `attr:"site/name"`
+		Sensors []generatedSensor `attr:"site/sensors"`
+	}
+
+	generatedSensorCalls.shred, generatedSensorCalls.assemble = 0, 0
+	db := NewDatabase(Config{})
+	level := 0.5
+	empty := ""
+	res := db.Write(Request{Assertions: []any{
+		generatedSensor{Name: "a", Reads: 3, Level: &level, Label: &empty},
+		&Site{Name: "home", Sensors: []generatedSensor{{Name: "b"}}},
+	}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, 2, generatedSensorCalls.shred)
+	a := res.IDs[0]
+
+	sensors, err := BuildTypedSnapshot[generatedSensor](res.Snap)
+	assert.NoError(t, err)
+	sensor := sensors.Find(a)
+	assert.Equal(t, generatedSensor{ID: a, Name: "a", Reads: 3, Level: &level}, *sensor)
+	assert.Positive(t, generatedSensorCalls.assemble)
+
+	sites, err := BuildTypedSnapshot[Site](res.Snap)
+	assert.NoError(t, err)
+	site := sites.Find(res.IDs[1])
+	if assert.Len(t, site.Sensors, 1) {
+		assert.Equal(t, "b", site.Sensors[0].Name)
+		assert.NotZero(t, site.Sensors[0].ID)
+	}
+
+	// The generated struct records its id, so it updates its entity.
+	sensor.Reads = 4
+	res = db.Write(Request{Assertions: []any{sensor}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []uint64{a}, res.IDs)
+}
+
+type generatedProbe struct {
+	ID    uint64 `attr:"sys/db/id"`
+	Name  string `attr:"probe/name"`
+	Depth int    `attr:"probe/depth"`
+}
+
+func init() {
+	// This is the output of destructive-gen before the Depth field was added.
+	RegisterGenerated(GeneratedStruct[generatedProbe]{
+		Attrs: []GeneratedAttr{
+			{Ident: "probe/name", Type: "sys/attr/type/string"},
+		},
+		Shred: func(x *generatedProbe, emit func(ident string, v any)) {
+			emit("sys/db/id", x.ID)
+			emit("probe/name", x.Name)
+		},
+		Assemble: func(x *generatedProbe, ident string, v any) (ok bool) {
+			switch ident {
+			case "sys/db/id":
+				x.ID, ok = v.(uint64)
+			case "probe/name":
+				x.Name, ok = v.(string)
+			}
+			return
+		},
+	})
+}
+
+func TestStaleGeneratedStructs(t *testing.T) {
+	type Probe struct {
+		Name  string `attr:"probe/name"`
+		Depth int    `attr:"probe/depth"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Probe{Name: "a", Depth: 3}}})
+	assert.NoError(t, res.Error)
+	id := res.IDs[0]
+
+	// The fields the generated functions decline are assembled by reflection.
+	probes, err := BuildTypedSnapshot[generatedProbe](res.Snap)
+	assert.NoError(t, err)
+	assert.Equal(t, generatedProbe{ID: id, Name: "a", Depth: 3}, *probes.Find(id))
+}
+
+type generatedGauge struct {
+	ID      uint64 `attr:"sys/db/id"`
+	Reading int    `attr:"gauge/reading"`
+}
+
+func init() {
+	// This is a faulty edit of the output of destructive-gen.
+	RegisterGenerated(GeneratedStruct[generatedGauge]{
+		Attrs: []GeneratedAttr{
+			{Ident: "gauge/reading", Type: "sys/attr/type/int"},
+		},
+		Shred: func(x *generatedGauge, emit func(ident string, v any)) {
+			emit("sys/db/id", x.ID)
+			emit("gauge/reading", complex(float64(x.Reading), 0))
+		},
+		Assemble: func(x *generatedGauge, ident string, v any) (ok bool) {
+			return
+		},
+	})
+}
+
+func TestInvalidGeneratedValues(t *testing.T) {
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{generatedGauge{Reading: 1}}})
+	assert.ErrorContains(t, res.Error, "database.generated.invalidValue")
+}
+
+func TestConcurrentUse(t *testing.T) {
+	type Person struct {
+		ID   uint64  `attr:"sys/db/id"`
+		Name string  `attr:"person/name,identity"`
+		BFF  *Person `attr:"person/bff"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", BFF: &Person{Name: "Stephen"}}}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				res := db.Write(Request{Assertions: []any{Person{Name: "Diane"}}})
+				assert.NoError(t, res.Error)
+				return
+			}
+			people, err := BuildTypedSnapshot[Person](db.Read())
+			assert.NoError(t, err)
+			person := people.Find(donald)
+			assert.Equal(t, "Stephen", person.BFF.Name)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConvertInvalidType(t *testing.T) {
+	type Person struct {
+		Name string `attr:"person/name"`
+	}
+	type Invalid struct {
+		Names chan string `attr:"person/name"`
+	}
+
+	_, err := Convert[Person, Invalid](Person{Name: "Donald"})
+	assert.Error(t, err)
+	_, err = Convert[Invalid, Person](Invalid{})
+	assert.Error(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name,identity"`
+		Team string `attr:"person/team"`
+		Age  int    `attr:"person/age"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Team: "red", Age: 48}}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+
+	reds, redUpdates, cancelReds, err := WatchDatums(db, Pattern{A: "person/team", V: "red"}, WatchOptions{})
+	assert.NoError(t, err)
+	defer cancelReds()
+	assert.Len(t, reds, 1)
+	assert.NotZero(t, reds[0].T)
+	assert.Equal(t, []Datum{{E: donald, A: "person/team", V: "red", T: reds[0].T}}, reds)
+	people, peopleUpdates, cancelPeople, err := WatchStructs[Person](db, Pattern{A: "person/team", V: "red"}, WatchOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []*Person{{ID: donald, Name: "Donald", Team: "red", Age: 48}}, people)
+	ages, ageUpdates, cancelAges, err := WatchDatums(db, Pattern{E: donald, A: "person/age"}, WatchOptions{})
+	assert.NoError(t, err)
+	defer cancelAges()
+	assert.Equal(t, int64(48), ages[0].V)
+
+	// A new member is added, and an unrelated entity is not reported.
+	res = db.Write(Request{Assertions: []any{Person{Name: "Stephen", Team: "red"}, Person{Name: "Mickey", Team: "blue"}}})
+	assert.NoError(t, res.Error)
+	stephen := res.IDs[0]
+	update := <-redUpdates
+	assert.Greater(t, update.ID, reds[0].T)
+	assert.Equal(t, []Datum{{E: stephen, A: "person/team", V: "red", T: update.ID}}, update.Added)
+	assert.Empty(t, update.Removed)
+	stephenT := update.Added[0].T
+	personUpdate := <-peopleUpdates
+	assert.Equal(t, []*Person{{ID: stephen, Name: "Stephen", Team: "red"}}, personUpdate.Added)
+	assert.Empty(t, personUpdate.Removed)
+
+	// A change to a member's other attributes changes its row but not the datum results.
+	res = db.Write(Request{Patches: []Patch{{Entity: Person{ID: donald, Age: 49}, Fields: []string{"Age"}}}})
+	assert.NoError(t, res.Error)
+	personUpdate = <-peopleUpdates
+	assert.Equal(t, []*Person{{ID: donald, Name: "Donald", Team: "red", Age: 48}}, personUpdate.Removed)
+	assert.Equal(t, []*Person{{ID: donald, Name: "Donald", Team: "red", Age: 49}}, personUpdate.Added)
+	update = <-ageUpdates
+	assert.Equal(t, []Datum{{E: donald, A: "person/age", V: int64(48), T: ages[0].T}}, update.Removed)
+	assert.Equal(t, []Datum{{E: donald, A: "person/age", V: int64(49), T: update.ID}}, update.Added)
+
+	// A member that leaves is removed, with the row as it was.
+	res = db.Write(Request{Patches: []Patch{{Entity: Person{ID: stephen, Team: "blue"}, Fields: []string{"Team"}}}})
+	assert.NoError(t, res.Error)
+	update = <-redUpdates
+	assert.Equal(t, []Datum{{E: stephen, A: "person/team", V: "red", T: stephenT}}, update.Removed)
+	assert.Empty(t, update.Added)
+	assert.Empty(t, redUpdates)
+	personUpdate = <-peopleUpdates
+	assert.Equal(t, []*Person{{ID: stephen, Name: "Stephen", Team: "red"}}, personUpdate.Removed)
+	assert.Empty(t, personUpdate.Added)
+	assert.Empty(t, peopleUpdates)
+	assert.Empty(t, ageUpdates)
+
+	// Canceling closes the channel.
+	cancelPeople()
+	_, ok := <-peopleUpdates
+	assert.False(t, ok)
+
+	// Invalid pattern values are rejected.
+	_, _, _, err = WatchDatums(db, Pattern{V: struct{}{}}, WatchOptions{})
+	assert.Error(t, err)
+}
+
+func TestWatchRenamedAttrs(t *testing.T) {
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name,identity"`
+		Team string `attr:"person/team"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Team: "red"}}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+	reds, updates, cancel, err := WatchDatums(db, Pattern{A: "person/team", V: "red"}, WatchOptions{})
+	assert.NoError(t, err)
+	defer cancel()
+	assert.Len(t, reds, 1)
+
+	res = db.Write(Request{Renames: []Rename{{Attr: "person/team", Ident: "person/side"}}})
+	assert.NoError(t, res.Error)
+	// The retracted datum is reported by the attribute's new ident, but removes the row
+	// of the old one.
+	res = db.Write(Request{Patches: []Patch{{Entity: Person{ID: donald, Team: "blue"}, Fields: []string{"Team"}}}})
+	assert.NoError(t, res.Error)
+	update := <-updates
+	assert.Equal(t, reds, update.Removed)
+	assert.Empty(t, update.Added)
+}
+
+func TestWatchUnbuildableStructs(t *testing.T) {
+	type Reading struct {
+		ID    uint64 `attr:"sys/db/id"`
+		Name  string `attr:"reading/name,identity"`
+		Value int    `attr:"reading/value"`
+	}
+	type SmallReading struct {
+		ID    uint64 `attr:"sys/db/id"`
+		Name  string `attr:"reading/name,identity"`
+		Value int8   `attr:"reading/value"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Reading{Name: "a", Value: 1}, Reading{Name: "b", Value: 300}}})
+	assert.NoError(t, res.Error)
+	a := res.IDs[0]
+
+	// The reading whose value overflows its field is left out of the results.
+	readings, updates, cancel, err := WatchStructs[SmallReading](db, Pattern{A: "reading/name"}, WatchOptions{})
+	assert.NoError(t, err)
+	defer cancel()
+	assert.Equal(t, []*SmallReading{{ID: a, Name: "a", Value: 1}}, readings)
+
+	// A row that can no longer be built is removed.
+	res = db.Write(Request{Assertions: []any{Reading{ID: a, Name: "a", Value: 400}}})
+	assert.NoError(t, res.Error)
+	update := <-updates
+	assert.Equal(t, readings, update.Removed)
+	assert.Empty(t, update.Added)
+}
+
+func TestHooks(t *testing.T) {
+	type Account struct {
+		ID      uint64 `attr:"sys/db/id"`
+		Name    string `attr:"account/name,identity"`
+		Balance int    `attr:"account/balance"`
+	}
+
+	var ids []uint64
+	var listened []TxReport
+	errOverdrawn := errors.New("overdrawn")
+	// Rejects transactions that leave the accounts with a negative total balance.
+	validator := func(report TxReport) error {
+		ts, err := BuildTypedSnapshot[Account](report.After)
+		if err != nil {
+			return err
+		}
+		total := 0
+		for _, id := range ids {
+			total += ts.Find(id).Balance
+		}
+		if total < 0 {
+			return errOverdrawn
+		}
+		return nil
+	}
+	db := NewDatabase(Config{
+		Validators: []Validator{validator},
+		Listeners:  []Listener{func(report TxReport) { listened = append(listened, report) }},
+	})
+	res := db.Write(Request{Assertions: []any{Account{Name: "a", Balance: 10}, Account{Name: "b", Balance: 5}}})
+	assert.NoError(t, res.Error)
+	ids = res.IDs
+	a, b := ids[0], ids[1]
+	// The schema and the accounts are reported.
+	assert.Len(t, listened, 2)
+	assert.Len(t, listened[1].Asserted, 4)
+
+	res = db.Write(Request{Increments: []Increment{{ID: a, Attr: "account/balance", Delta: -20}}})
+	assert.ErrorIs(t, res.Error, errOverdrawn)
+	assert.Len(t, listened, 2)
+	ts, err := BuildTypedSnapshot[Account](db.Read())
+	assert.NoError(t, err)
+	assert.Equal(t, 10, ts.Find(a).Balance)
+
+	res = db.Write(Request{Increments: []Increment{
+		{ID: a, Attr: "account/balance", Delta: -12},
+		{ID: b, Attr: "account/balance", Delta: -3},
+	}})
+	assert.NoError(t, res.Error)
+	assert.Len(t, listened, 3)
+	assert.Equal(t, res.Asserted, listened[2].Asserted)
+
+	// Speculative writes are validated but not reported.
+	res = db.Read().With(Request{Increments: []Increment{{ID: b, Attr: "account/balance", Delta: -1}}})
+	assert.ErrorIs(t, res.Error, errOverdrawn)
+	res = db.Read().With(Request{Increments: []Increment{{ID: b, Attr: "account/balance", Delta: 1}}})
+	assert.NoError(t, res.Error)
+	assert.Len(t, listened, 3)
+}
+
+func TestListenerSubscriptions(t *testing.T) {
+	type Person struct {
+		Name string `attr:"person/name,identity"`
+	}
+
+	// The listener subscribes, watches and cancels, which deliveries must not block.
+	var db Database
+	var subscribed []<-chan TxReport
+	listener := func(report TxReport) {
+		reports, cancel := db.Subscribe(Filter{})
+		subscribed = append(subscribed, reports)
+		cancel()
+		_, _, cancel, err := WatchDatums(db, Pattern{A: "person/name"}, WatchOptions{})
+		assert.NoError(t, err)
+		cancel()
+	}
+	db = NewDatabase(Config{Listeners: []Listener{listener}})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald"}}})
+	assert.NoError(t, res.Error)
+	// The schema and the person are reported.
+	assert.Len(t, subscribed, 2)
+	for _, reports := range subscribed {
+		_, ok := <-reports
+		assert.False(t, ok)
+	}
+}
+
+func TestSpecs(t *testing.T) {
+	type Person struct {
+		ID    uint64  `attr:"sys/db/id,spec=person/spec,pred=adult"`
+		Name  string  `attr:"person/name,identity,required"`
+		Email *string `attr:"person/email,required"`
+		Age   int     `attr:"person/age"`
+	}
+
+	errMinor := errors.New("minor")
+	db := NewDatabase(Config{Predicates: map[string]Predicate{
+		"adult": func(snapshot *Snapshot, id uint64) error {
+			ts, err := BuildTypedSnapshot[Person](snapshot)
+			if err != nil {
+				return err
+			}
+			if ts.Find(id).Age < 18 {
+				return errMinor
+			}
+			return nil
+		},
+	}})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 48}}})
+	var specErr SpecError
+	assert.ErrorAs(t, res.Error, &specErr)
+	assert.Equal(t, "person/spec", specErr.Spec)
+	assert.Equal(t, "person/email", specErr.Attr)
+
+	email := "donald@example.com"
+	res = db.Write(Request{Assertions: []any{Person{Name: "Donald", Email: &email, Age: 12}}})
+	assert.ErrorIs(t, res.Error, errMinor)
+	assert.ErrorAs(t, res.Error, &specErr)
+	assert.Equal(t, "adult", specErr.Pred)
+
+	res = db.Write(Request{Assertions: []any{Person{Name: "Donald", Email: &email, Age: 48}}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+
+	// Subsequent writes must leave the entity conforming.
+	res = db.Write(Request{Patches: []Patch{{Entity: Person{ID: donald}, Fields: []string{"Email"}}}})
+	assert.ErrorAs(t, res.Error, &specErr)
+	assert.Equal(t, donald, specErr.ID)
+	res = db.Write(Request{Patches: []Patch{{Entity: Person{ID: donald, Age: 49}, Fields: []string{"Age"}}}})
+	assert.NoError(t, res.Error)
+	res = db.Write(Request{Retractions: []any{Person{ID: donald, Name: "Donald"}}})
+	assert.NoError(t, res.Error)
+}
+
+func TestConstraints(t *testing.T) {
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name,identity,minlen=1,regex=^[A-Z],pred=polite"`
+		Age  int    `attr:"person/age,min=0,max=150"`
+	}
+
+	errRude := errors.New("rude")
+	db := NewDatabase(Config{ValuePredicates: map[string]ValuePredicate{
+		"polite": func(value any) error {
+			if value == "Grumpy" {
+				return errRude
+			}
+			return nil
+		},
+	}})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Age: 48}}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+
+	var constraintErr ConstraintError
+	res = db.Write(Request{Assertions: []any{Person{ID: donald, Name: "Donald", Age: 151}}})
+	assert.ErrorAs(t, res.Error, &constraintErr)
+	assert.Equal(t, ConstraintError{ID: donald, Attr: "person/age", Value: int64(151), Constraint: "sys/attr/max"}, constraintErr)
+	res = db.Write(Request{Assertions: []any{Person{Name: "stephen"}}})
+	assert.ErrorAs(t, res.Error, &constraintErr)
+	assert.Equal(t, "sys/attr/regex", constraintErr.Constraint)
+	res = db.Write(Request{Assertions: []any{Person{Name: "Grumpy"}}})
+	assert.ErrorIs(t, res.Error, errRude)
+	assert.ErrorAs(t, res.Error, &constraintErr)
+	assert.Equal(t, "polite", constraintErr.Pred)
+	assert.Equal(t, "Grumpy", constraintErr.Value)
+}
+
+func TestRelaxations(t *testing.T) {
+	type Person struct {
+		ID    uint64  `attr:"sys/db/id,spec=person/spec"`
+		Name  string  `attr:"person/name,identity"`
+		Email *string `attr:"person/email,required"`
+		Age   int     `attr:"person/age,max=150"`
+	}
+	// Relaxed is Person without its email requirement or age constraint, and with a new
+	// nickname requirement.
+	type Relaxed struct {
+		ID       uint64  `attr:"sys/db/id,spec=person/spec"`
+		Name     string  `attr:"person/name,identity"`
+		Nickname *string `attr:"person/nickname,required"`
+		Age      int     `attr:"person/age"`
+	}
+
+	db := NewDatabase(Config{})
+	email := "donald@example.com"
+	res := db.Write(Request{Assertions: []any{Person{Name: "Donald", Email: &email, Age: 48}}})
+	assert.NoError(t, res.Error)
+	donald := res.IDs[0]
+
+	// Structs add to the specs and constraints they name.
+	nickname := "Don"
+	var specErr SpecError
+	res = db.Write(Request{Assertions: []any{Relaxed{Name: "Stephen", Nickname: &nickname, Age: 40}}})
+	assert.ErrorAs(t, res.Error, &specErr)
+	assert.Equal(t, "person/email", specErr.Attr)
+	email = "stephen@example.com"
+	var constraintErr ConstraintError
+	res = db.Write(Request{Assertions: []any{Person{Name: "Stephen", Email: &email, Age: 151}}})
+	assert.ErrorAs(t, res.Error, &constraintErr)
+
+	// The relaxed spec requires only what the request's structs declare.
+	relaxed := Request{
+		Assertions:   []any{Relaxed{Name: "Stephen", Age: 151}},
+		RelaxedSpecs: []string{"person/spec"},
+		RelaxedAttrs: []string{"person/age"},
+	}
+	res = db.Write(relaxed)
+	assert.ErrorAs(t, res.Error, &specErr)
+	assert.Equal(t, "person/nickname", specErr.Attr)
+	relaxed.Assertions = []any{Relaxed{Name: "Stephen", Nickname: &nickname, Age: 151}}
+
+	// The relaxations are written with the request, so they fail with it.
+	failing := relaxed
+	failing.Swaps = []Swap{{ID: donald, Attr: "person/age", Old: 47, New: 49}}
+	res = db.Write(failing)
+	assert.Error(t, res.Error)
+	assert.Equal(t, 1, db.Read().snap.Count(types.Claim{A: types.Ident("sys/attr/max")}))
+
+	res = db.Write(relaxed)
+	assert.NoError(t, res.Error)
+	assert.Equal(t, 0, db.Read().snap.Count(types.Claim{A: types.Ident("sys/attr/max")}))
+
+	res = db.Write(Request{RelaxedSpecs: []string{"nope/spec"}})
+	assert.Error(t, res.Error)
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	type Item struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"item/name,identity"`
+		Part uint64 `attr:"item/parts,ref"`
+		Code string `attr:"item/code"`
+	}
+	type Assembly struct {
+		ID    uint64   `attr:"sys/db/id"`
+		Name  string   `attr:"item/name,identity"`
+		Parts []uint64 `attr:"item/parts,ref"`
+		Code  string   `attr:"item/code,unique"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{
+		Item{Name: "head", Code: "H"},
+		Item{Name: "handle", Code: "H"},
+	}})
+	assert.NoError(t, res.Error)
+	head, handle := res.IDs[0], res.IDs[1]
+
+	// Structs may not change the attributes they redeclare.
+	res = db.Write(Request{Assertions: []any{Assembly{Name: "hammer", Parts: []uint64{head, handle}, Code: "HA"}}})
+	assert.ErrorContains(t, res.Error, "ChangeDisallowed")
+
+	// The code values must be unique before the code attr may become unique.
+	migrations := []Migration{
+		{Attr: "item/parts", Cardinality: "sys/attr/cardinality/many"},
+		{Attr: "item/code", Unique: "sys/attr/unique/value"},
+	}
+	res = db.Write(Request{Migrations: migrations})
+	assert.ErrorContains(t, res.Error, "database.write.attrUniqueMigrationImpossible")
+	res = db.Write(Request{Assertions: []any{Item{ID: handle, Name: "handle", Code: "G"}}, Migrations: migrations})
+	assert.NoError(t, res.Error)
+	res = db.Write(Request{Assertions: []any{Assembly{Name: "hammer", Parts: []uint64{head, handle}, Code: "HA"}}})
+	assert.NoError(t, res.Error)
+	hammer := res.IDs[0]
+
+	ts, err := BuildTypedSnapshot[Assembly](db.Read())
+	assert.NoError(t, err)
+	assert.Equal(t, &Assembly{ID: hammer, Name: "hammer", Parts: []uint64{head, handle}, Code: "HA"}, ts.Find(hammer))
+	res = db.Write(Request{Assertions: []any{Assembly{ID: head, Name: "head", Code: "HA"}}})
+	assert.ErrorContains(t, res.Error, "database.write.uniqueValueCollision")
+
+	res = db.Write(Request{Migrations: []Migration{{Attr: "item/code", NotUnique: true}}})
+	assert.NoError(t, res.Error)
+	res = db.Write(Request{Assertions: []any{Item{ID: head, Name: "head", Code: "HA"}}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []Attribute{
+		{ID: uint64(res.Snap.snap.ResolveIdent("item/code")), Ident: "item/code", Type: "sys/attr/type/string", Cardinality: "sys/attr/cardinality/one"},
+	}, res.Snap.Schema()[:1])
+}
+
+func TestAliases(t *testing.T) {
+	type Person struct {
+		ID   uint64 `attr:"sys/db/id"`
+		Name string `attr:"person/name,identity"`
+	}
+	type RenamedPerson struct {
+		ID       uint64 `attr:"sys/db/id"`
+		FullName string `attr:"person/full-name,identity"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Ada"}}})
+	assert.NoError(t, res.Error)
+	ada := res.IDs[0]
+	reports, cancel := db.Subscribe(Filter{Attrs: []string{"person/name"}, Buffer: 8})
+	defer cancel()
+
+	res = db.Write(Request{Renames: []Rename{{Attr: "person/name", Ident: "person/full-name"}}})
+	assert.NoError(t, res.Error)
+	res = db.Write(Request{Assertions: []any{Person{ID: ada, Name: "Ada Lovelace"}}})
+	assert.NoError(t, res.Error)
+	report := <-reports
+	assert.Equal(t, []Datum{{E: ada, A: "person/full-name", V: "Ada Lovelace", T: report.ID}}, report.Asserted)
+
+	old, err := BuildTypedSnapshot[Person](db.Read())
+	assert.NoError(t, err)
+	assert.Equal(t, &Person{ID: ada, Name: "Ada Lovelace"}, old.Find(ada))
+	renamed, err := BuildTypedSnapshot[RenamedPerson](db.Read())
+	assert.NoError(t, err)
+	assert.Equal(t, &RenamedPerson{ID: ada, FullName: "Ada Lovelace"}, renamed.Find(ada))
+	res = db.Write(Request{Assertions: []any{RenamedPerson{FullName: "Ada Lovelace"}}})
+	assert.NoError(t, res.Error)
+	assert.Equal(t, []uint64{ada}, res.IDs)
+
+	res = db.Write(Request{RetiredAliases: []string{"person/name"}})
+	assert.NoError(t, res.Error)
+	assert.Len(t, res.Retracted, 1)
+	assert.Equal(t, "sys/db/alias", res.Retracted[0].A)
+	assert.Equal(t, "person/name", res.Retracted[0].V)
+	res = db.Write(Request{RetiredAliases: []string{"person/name"}})
+	assert.Error(t, res.Error)
+
+	// Structs tagged with a retired alias no longer read the attribute.
+	old, err = BuildTypedSnapshot[Person](db.Read())
+	assert.NoError(t, err)
+	assert.Equal(t, &Person{ID: ada}, old.Find(ada))
+	renamed, err = BuildTypedSnapshot[RenamedPerson](db.Read())
+	assert.NoError(t, err)
+	assert.Equal(t, &RenamedPerson{ID: ada, FullName: "Ada Lovelace"}, renamed.Find(ada))
+}
+
+func TestSchema(t *testing.T) {
+	type Person struct {
+		ID      uint64   `attr:"sys/db/id"`
+		Name    string   `attr:"person/name,identity"`
+		Email   string   `attr:"person/email"`
+		Friends []uint64 `attr:"person/friends,ref"`
+	}
+	type NewPerson struct {
+		ID      uint64 `attr:"sys/db/id"`
+		Name    string `attr:"person/full-name,identity"`
+		Email   string `attr:"person/email,unique"`
+		Friends uint64 `attr:"person/friends,ref"`
+		Age     int    `attr:"person/age"`
+		Nick    int    `attr:"person/nick"`
+	}
+
+	db := NewDatabase(Config{})
+	res := db.Write(Request{Assertions: []any{Person{Name: "Ada"}}})
+	assert.NoError(t, res.Error)
+	res = db.Write(Request{
+		Assertions: []any{struct {
+			Nick string `attr:"person/nick"`
+		}{Nick: "Countess"}},
+		Renames: []Rename{{Attr: "person/name", Ident: "person/full-name"}},
+	})
+	assert.NoError(t, res.Error)
+	snapshot := db.Read()
+
+	schema := snapshot.Schema()
+	idents := make([]string, len(schema))
+	for i, attr := range schema {
+		idents[i] = attr.Ident
+	}
+	assert.Equal(t, []string{"person/email", "person/friends", "person/full-name", "person/nick"}, idents)
+	name := schema[2]
+	assert.Positive(t, name.ID)
+	assert.Equal(t, Attribute{
+		ID:          name.ID,
+		Ident:       "person/full-name",
+		Aliases:     []string{"person/name"},
+		Type:        "sys/attr/type/string",
+		Cardinality: "sys/attr/cardinality/one",
+		Unique:      "sys/attr/unique/identity",
+	}, name)
+	assert.Equal(t, "sys/attr/cardinality/many", schema[1].Cardinality)
+
+	diffs, err := DiffSchema[Person](snapshot)
+	assert.NoError(t, err)
+	assert.Empty(t, diffs)
+	diffs, err = DiffSchema[NewPerson](snapshot)
+	assert.NoError(t, err)
+	conflicts := map[string][]string{}
+	for _, diff := range diffs {
+		if diff.Missing {
+			conflicts[diff.Declared.Ident] = []string{"missing"}
+			continue
+		}
+		conflicts[diff.Declared.Ident] = diff.Conflicts
+	}
+	assert.Equal(t, map[string][]string{
+		"person/email":   {"unique"},
+		"person/friends": {"cardinality"},
+		"person/age":     {"missing"},
+		"person/nick":    {"type"},
+	}, conflicts)
+}
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/dball/destructive/internal/structs/models"
+	"github.com/dball/destructive/internal/types"
+)
+
+// TxReport reports the changes a committed transaction made to a database.
+type TxReport struct {
+	// ID is the id of the transaction.
+	ID uint64
+	// Before is the immutable set of data before the transaction.
+	Before *Snapshot
+	// After is the immutable set of data after the transaction.
+	After *Snapshot
+	// Asserted are the datums the transaction added, in the order they were added.
+	Asserted []Datum
+	// Retracted are the datums the transaction removed, in the order they were removed.
+	Retracted []Datum
+}
+
+// SlowConsumerPolicy determines what happens to a report for a subscription whose buffer
+// is full.
+type SlowConsumerPolicy int
+
+const (
+	// Disconnect ends the subscription, closing its channel. The subscriber may resubscribe
+	// and read the database to catch up.
+	Disconnect SlowConsumerPolicy = iota
+	// Block waits for the subscriber to receive the report, delaying the delivery of the
+	// reports of subsequent transactions, and so the return of their writes, until it does.
+	Block
+	// Drop discards the report.
+	Drop
+)
+
+// Filter selects the reports delivered to a subscription, and determines how they are
+// buffered.
+type Filter struct {
+	// Attrs, if given, are the idents of the attributes whose datums are reported.
+	Attrs []string
+	// IDs, if given, are the ids of the entities whose datums are reported.
+	IDs []uint64
+	// Buffer is the number of reports the subscription holds for the subscriber, which is
+	// 64 by default.
+	Buffer int
+	// Policy determines what happens to reports when the buffer is full.
+	Policy SlowConsumerPolicy
+}
+
+// subscription is the state of a subscriber to a database's transactions.
+type subscription struct {
+	attrs   map[string]types.Void
+	ids     map[uint64]types.Void
+	policy  SlowConsumerPolicy
+	reports chan TxReport
+	// done is closed when the subscription is cancelled, releasing blocked deliveries.
+	done chan types.Void
+	once sync.Once
+	// lock serializes deliveries with the end of the subscription.
+	lock  sync.Mutex
+	ended bool
+}
+
+// feed delivers the reports of committed transactions to subscribers in commit order.
+type feed struct {
+	// lock guards the subscriptions and serializes commits, which deliver their reports
+	// after releasing it, so listeners and subscribers may subscribe and cancel.
+	lock          sync.Mutex
+	subscriptions map[*subscription]types.Void
+	// delivered is closed when the last commit with reports has delivered them, which the
+	// next commit awaits to deliver its own.
+	delivered chan types.Void
+}
+
+func (db *localDatabase) Subscribe(filter Filter) (reports <-chan TxReport, cancel func()) {
+	reports, _, cancel = db.subscribe(filter)
+	return
+}
+
+// subscribe subscribes to the database as Subscribe does, also returning the snapshot
+// of the database as of the subscription, from which its reports follow.
+func (db *localDatabase) subscribe(filter Filter) (reports <-chan TxReport, snapshot *Snapshot, cancel func()) {
+	size := filter.Buffer
+	if size <= 0 {
+		size = 64
+	}
+	sub := &subscription{
+		policy:  filter.Policy,
+		reports: make(chan TxReport, size),
+		done:    make(chan types.Void),
+	}
+	if len(filter.Attrs) != 0 {
+		sub.attrs = make(map[string]types.Void, len(filter.Attrs))
+		for _, attr := range filter.Attrs {
+			sub.attrs[attr] = types.Void{}
+		}
+	}
+	if len(filter.IDs) != 0 {
+		sub.ids = make(map[uint64]types.Void, len(filter.IDs))
+		for _, id := range filter.IDs {
+			sub.ids[id] = types.Void{}
+		}
+	}
+	db.feed.lock.Lock()
+	if db.feed.subscriptions == nil {
+		db.feed.subscriptions = map[*subscription]types.Void{}
+	}
+	db.feed.subscriptions[sub] = types.Void{}
+	// Commits hold the lock while they write, so the subscription receives the reports of
+	// those after the snapshot.
+	snapshot = db.Read()
+	db.feed.lock.Unlock()
+	reports = sub.reports
+	cancel = func() {
+		sub.once.Do(func() {
+			close(sub.done)
+			sub.lock.Lock()
+			db.feed.end(sub)
+			sub.lock.Unlock()
+		})
+	}
+	return
+}
+
+// commit writes the request to the database and delivers its report to the listeners
+// and the subscribers as of the write, after the reports of the prior commits.
+func (db *localDatabase) commit(ireq types.Request) (ires types.Response) {
+	db.feed.lock.Lock()
+	ires = db.db.Write(ireq)
+	if ires.Error != nil || (len(db.feed.subscriptions) == 0 && len(db.listeners) == 0) ||
+		(len(ires.Asserted) == 0 && len(ires.Retracted) == 0) {
+		db.feed.lock.Unlock()
+		return
+	}
+	subs := slices.Collect(maps.Keys(db.feed.subscriptions))
+	prior := db.feed.delivered
+	delivered := make(chan types.Void)
+	db.feed.delivered = delivered
+	db.feed.lock.Unlock()
+	defer close(delivered)
+	report := db.report(types.Transaction{
+		ID:        ires.ID,
+		Before:    ires.Before,
+		After:     ires.Snapshot,
+		Asserted:  ires.Asserted,
+		Retracted: ires.Retracted,
+	}, db.analyzer)
+	if prior != nil {
+		<-prior
+	}
+	for _, listener := range db.listeners {
+		listener(report)
+	}
+	for _, sub := range subs {
+		filtered, ok := sub.filter(report)
+		if ok {
+			db.feed.deliver(sub, filtered)
+		}
+	}
+	return
+}
+
+// report returns the report of the transaction, whose snapshots have the analyzer.
+func (db *localDatabase) report(tx types.Transaction, analyzer models.Analyzer) TxReport {
+	return TxReport{
+		ID:        uint64(tx.ID),
+		Before:    &Snapshot{snap: tx.Before, analyzer: analyzer, db: db},
+		After:     &Snapshot{snap: tx.After, analyzer: analyzer, db: db},
+		Asserted:  publicDatums(tx.After, tx.Asserted),
+		Retracted: publicDatums(tx.After, tx.Retracted),
+	}
+}
+
+// deliver sends the report to the subscriber according to its policy, unless the
+// subscription has ended.
+func (feed *feed) deliver(sub *subscription, report TxReport) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if sub.ended {
+		return
+	}
+	select {
+	case sub.reports <- report:
+		return
+	default:
+	}
+	switch sub.policy {
+	case Block:
+		select {
+		case sub.reports <- report:
+		case <-sub.done:
+		}
+	case Drop:
+	default:
+		feed.end(sub)
+	}
+}
+
+// end removes the subscription and closes its channel, if it has not already been
+// ended. The subscription's lock must be held.
+func (feed *feed) end(sub *subscription) {
+	if sub.ended {
+		return
+	}
+	sub.ended = true
+	close(sub.reports)
+	feed.lock.Lock()
+	delete(feed.subscriptions, sub)
+	feed.lock.Unlock()
+}
+
+// filter returns the report with only the datums selected by the subscription, and
+// whether any were.
+func (sub *subscription) filter(report TxReport) (filtered TxReport, ok bool) {
+	if sub.attrs == nil && sub.ids == nil {
+		return report, true
+	}
+	selected := func(datum Datum) bool {
+		if sub.attrs != nil {
+			if _, ok := sub.attrs[datum.A]; !ok {
+				// The filter may name the attribute by one of its aliases.
+				aliased := false
+				for attr := range sub.attrs {
+					if sameAttr(report.After.snap, attr, datum.A) {
+						aliased = true
+						break
+					}
+				}
+				if !aliased {
+					return false
+				}
+			}
+		}
+		if sub.ids != nil {
+			if _, ok := sub.ids[datum.E]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	filtered = report
+	filtered.Asserted = slices.DeleteFunc(slices.Clone(report.Asserted), func(datum Datum) bool { return !selected(datum) })
+	filtered.Retracted = slices.DeleteFunc(slices.Clone(report.Retracted), func(datum Datum) bool { return !selected(datum) })
+	ok = len(filtered.Asserted) != 0 || len(filtered.Retracted) != 0
+	return
+}
+
+// sameAttr reports whether the idents name the same attribute in the snapshot, where
+// either may be an alias.
+func sameAttr(snap types.Snapshot, a, b string) bool {
+	if a == b {
+		return true
+	}
+	id := snap.ResolveIdent(types.Ident(a))
+	return id != 0 && id == snap.ResolveIdent(types.Ident(b))
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"reflect"
+
+	"github.com/dball/destructive/internal/structs/models"
+	"github.com/dball/destructive/internal/sys"
+	"github.com/dball/destructive/internal/types"
+)
+
+// GeneratedStruct holds the functions generated by destructive-gen for a struct type,
+// which the database prefers to reflection when recording and loading its instances.
+// Values are strings, int64s, bools, float64s or time.Times, and ids are uint64s.
+type GeneratedStruct[T any] struct {
+	// Attrs are the attributes of the struct's attr fields, other than its id field.
+	Attrs []GeneratedAttr
+	// Shred calls emit with the ident and value of each attr field of the struct. Nil
+	// pointers and ignored empty values are omitted. Writes of structs for which it emits
+	// other values fail.
+	Shred func(x *T, emit func(ident string, v any))
+	// Assemble sets the attr field with the ident to the value, reporting whether the
+	// struct has the field and the value has its type. The database sets the fields by
+	// reflection when it does not.
+	Assemble func(x *T, ident string, v any) (ok bool)
+}
+
+// GeneratedAttr declares an attribute of a generated struct.
+type GeneratedAttr struct {
+	// Ident is the ident of the attribute.
+	Ident string
+	// Type is the ident of the attribute's type, e.g. "sys/attr/type/string".
+	Type string
+	// Unique is the ident of the attribute's uniqueness, e.g. "sys/attr/unique/identity",
+	// if any.
+	Unique string
+}
+
+// RegisterGenerated registers the generated functions for the struct type T, for all
+// databases. It is called by generated code, and panics if T is not a struct type or is
+// already registered.
+func RegisterGenerated[T any](gen GeneratedStruct[T]) {
+	attrs := make([]types.Attr, len(gen.Attrs))
+	for i, attr := range gen.Attrs {
+		attrs[i] = types.Attr{
+			Ident:  types.Ident(attr.Ident),
+			Type:   sys.Idents[types.Ident(attr.Type)],
+			Unique: sys.Idents[types.Ident(attr.Unique)],
+		}
+	}
+	generated := models.Generated{Attrs: attrs}
+	if gen.Shred != nil {
+		generated.Shred = func(x any, emit func(ident types.Ident, v types.Value)) (err error) {
+			ptr, ok := x.(*T)
+			if !ok {
+				value := x.(T)
+				ptr = &value
+			}
+			gen.Shred(ptr, func(ident string, v any) {
+				if id, ok := v.(uint64); ok && ident == sys.DbId {
+					emit(types.Ident(ident), types.ID(id))
+					return
+				}
+				value, ok := models.ToValue(v)
+				switch {
+				case ok:
+					emit(types.Ident(ident), value)
+				case err == nil:
+					err = types.NewError("database.generated.invalidValue", "type", reflect.TypeFor[T](), "ident", ident, "v", v)
+				}
+			})
+			return
+		}
+	}
+	if gen.Assemble != nil {
+		generated.Assemble = func(x any, ident types.Ident, v types.Value) bool {
+			if id, ok := v.(types.ID); ok {
+				return ident == sys.DbId && gen.Assemble(x.(*T), string(ident), uint64(id))
+			}
+			return gen.Assemble(x.(*T), string(ident), models.FromValue(v))
+		}
+	}
+	err := models.RegisterGenerated(reflect.TypeFor[T](), generated)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"github.com/dball/destructive/internal/structs/models"
+	"github.com/dball/destructive/internal/types"
+)
+
+// Validator checks the report of a transaction before it is committed, whose After
+// snapshot is the database as the transaction would leave it. An error rejects the write
+// and is its response's error. Validators run while writes are locked, so they may
+// enforce invariants that span entities, but must not write to the database. They are
+// given the transactions of requests, including speculative ones, but not those that
+// declare the attributes of structs or change nothing.
+type Validator func(report TxReport) (err error)
+
+// Listener is given the report of each transaction after it is committed, in commit
+// order, before its write returns. Listeners run after writes are unlocked, so they may
+// read the database, subscribe to it and cancel subscriptions and queries, but they must
+// not write to it, since a write waits for the reports of prior transactions to be
+// delivered. Transactions that change nothing are not reported.
+type Listener func(report TxReport)
+
+// Predicate checks an entity that conforms to a spec naming it, in the snapshot of the
+// database as a transaction would leave it. An error rejects the write, whose response's
+// error is a SpecError that wraps it. Predicates run while writes are locked, so they
+// must not write to the database.
+type Predicate func(snapshot *Snapshot, id uint64) (err error)
+
+// ValuePredicate checks a value asserted for an attribute whose constraints name it, which
+// is given as in datums. An error rejects the write, whose response's error is a
+// ConstraintError that wraps it.
+type ValuePredicate func(value any) (err error)
+
+// internalValidators returns the database's validators as internal validators whose
+// reports' snapshots have the analyzer.
+func (db *localDatabase) internalValidators(analyzer models.Analyzer) (validators []types.Validator) {
+	if len(db.validators) == 0 {
+		return
+	}
+	validators = make([]types.Validator, len(db.validators))
+	for i, validator := range db.validators {
+		validators[i] = func(tx types.Transaction) error {
+			return validator(db.report(tx, analyzer))
+		}
+	}
+	return
+}
+
+// internalPredicates returns the database's predicates as internal predicates whose
+// snapshots have the analyzer.
+func (db *localDatabase) internalPredicates(analyzer models.Analyzer) (predicates map[string]types.Predicate) {
+	if len(db.predicates) == 0 {
+		return
+	}
+	predicates = make(map[string]types.Predicate, len(db.predicates))
+	for name, predicate := range db.predicates {
+		predicates[name] = func(snap types.Snapshot, e types.ID) error {
+			return predicate(&Snapshot{snap: snap, analyzer: analyzer, db: db}, uint64(e))
+		}
+	}
+	return
+}
+
+// internalValuePredicates returns the database's value predicates as internal value
+// predicates.
+func (db *localDatabase) internalValuePredicates() (predicates map[string]types.ValuePredicate) {
+	if len(db.valuePredicates) == 0 {
+		return
+	}
+	predicates = make(map[string]types.ValuePredicate, len(db.valuePredicates))
+	for name, predicate := range db.valuePredicates {
+		predicates[name] = func(value types.Value) error {
+			return predicate(publicValue(value))
+		}
+	}
+	return
+}
@@ -1,12 +1,15 @@
 package database
 
 import (
+	"maps"
 	"reflect"
+	"slices"
 
 	"github.com/dball/destructive/internal/database"
 	"github.com/dball/destructive/internal/structs/models"
 	"github.com/dball/destructive/internal/structs/schemas"
 	"github.com/dball/destructive/internal/structs/shredder"
+	"github.com/dball/destructive/internal/sys"
 	"github.com/dball/destructive/internal/types"
 )
 
@@ -14,6 +17,51 @@ type Config struct {
 	Degree     int
 	AttrsSize  int
 	IdentsSize int
+	// Types are the concrete struct types that may populate interface-typed ref fields.
+	Types []TypeBinding
+	// Codecs convert field types that are not otherwise supported to system values.
+	Codecs []Codec
+	// Functions are the functions that requests may call, by their names.
+	Functions map[string]Function
+	// Validators check the transactions of requests before they are committed.
+	Validators []Validator
+	// Listeners are given the reports of transactions after they are committed.
+	Listeners []Listener
+	// Predicates are the predicates that specs may name, by their names.
+	Predicates map[string]Predicate
+	// ValuePredicates are the value predicates that attribute constraints may name, by their
+	// names.
+	ValuePredicates map[string]ValuePredicate
+}
+
+// ValueCodec may be implemented by field types to record their values as a string,
+// int64, bool, float64 or time.Time. The type returned by EncodeValue for the zero
+// value determines the attribute type, and DecodeValue, which must have a pointer
+// receiver, receives values of that type.
+type ValueCodec = models.ValueCodec
+
+// Codec converts the values of a field type to and from a string, int64, bool, float64
+// or time.Time, for types that cannot implement ValueCodec, e.g. those defined in other
+// packages. The type returned by Encode for the zero value determines the attribute type.
+type Codec struct {
+	// Type is the field type.
+	Type reflect.Type
+	// Encode converts a field value to a system value.
+	Encode func(x any) (y any, err error)
+	// Decode converts a system value to a field value.
+	Decode func(y any) (x any, err error)
+}
+
+// TypeBinding binds a concrete struct type to the ident recorded on its entities under
+// a discriminator ref attribute, which the database uses to choose the concrete type
+// when populating interface-typed ref fields.
+type TypeBinding struct {
+	// Type is the struct or struct pointer type as it is stored in interface values.
+	Type reflect.Type
+	// Attr is the ident of the discriminator attribute.
+	Attr string
+	// Ident is the discriminator value for the type.
+	Ident string
 }
 
 var defaultConfig Config = Config{
@@ -22,7 +70,20 @@ var defaultConfig Config = Config{
 	IdentsSize: 1024,
 }
 
+// NewDatabase returns a new database, as NewDatabaseWith does, for configs whose type
+// bindings and codecs are known to be valid. It panics otherwise, as regexp.MustCompile
+// does.
 func NewDatabase(config Config) Database {
+	db, err := NewDatabaseWith(config)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// NewDatabaseWith returns a new database, or an error if the config's type bindings or
+// codecs are invalid.
+func NewDatabaseWith(config Config) (db Database, err error) {
 	degree := config.Degree
 	if degree == 0 {
 		degree = defaultConfig.Degree
@@ -33,17 +94,46 @@ func NewDatabase(config Config) Database {
 	}
 	identsSize := config.IdentsSize
 	if identsSize == 0 {
-		attrsSize = defaultConfig.IdentsSize
+		identsSize = defaultConfig.IdentsSize
 	}
-	return &localDatabase{
-		db:       database.NewIndexDatabase(degree, attrsSize, identsSize),
-		analyzer: models.BuildCachingAnalyzer(),
+	registry := models.NewRegistry()
+	for _, binding := range config.Types {
+		err = registry.Register(binding.Type, types.Ident(binding.Attr), types.Ident(binding.Ident))
+		if err != nil {
+			return
+		}
+	}
+	for _, c := range config.Codecs {
+		var codec models.Codec
+		codec, err = models.NewFuncCodec(c.Type, c.Encode, c.Decode)
+		if err == nil {
+			err = registry.RegisterCodec(c.Type, codec)
+		}
+		if err != nil {
+			return
+		}
+	}
+	db = &localDatabase{
+		db:              database.NewIndexDatabase(degree, attrsSize, identsSize),
+		analyzer:        models.BuildRegistryAnalyzer(registry),
+		functions:       maps.Clone(config.Functions),
+		validators:      slices.Clone(config.Validators),
+		listeners:       slices.Clone(config.Listeners),
+		predicates:      maps.Clone(config.Predicates),
+		valuePredicates: maps.Clone(config.ValuePredicates),
 	}
+	return
 }
 
 type localDatabase struct {
-	db       types.Database
-	analyzer models.Analyzer
+	db              types.Database
+	analyzer        models.Analyzer
+	functions       map[string]Function
+	validators      []Validator
+	listeners       []Listener
+	predicates      map[string]Predicate
+	valuePredicates map[string]ValuePredicate
+	feed            feed
 }
 
 var _ Database = (*localDatabase)(nil)
@@ -52,18 +142,34 @@ func (db *localDatabase) Read() *Snapshot {
 	return &Snapshot{
 		snap:     db.db.Read(),
 		analyzer: db.analyzer,
+		db:       db,
 	}
 }
 
 func (db *localDatabase) Write(req Request) (res Response) {
-	for _, assertion := range req.Assertions {
+	return db.write(req, db.analyzer, db.commit)
+}
+
+// write writes the request's schema and then the request itself with the internal write
+// function, returning snapshots with the analyzer.
+func (db *localDatabase) write(req Request, analyzer models.Analyzer, write func(ireq types.Request) types.Response) (res Response) {
+	entities := make([]any, 0, len(req.Assertions)+len(req.Patches))
+	entities = append(entities, req.Assertions...)
+	for _, patch := range req.Patches {
+		// The shredder rejects nil patch entities.
+		if patch.Entity != nil {
+			entities = append(entities, patch.Entity)
+		}
+	}
+	var schema []types.Claim
+	for _, assertion := range entities {
 		typ := reflect.TypeOf(assertion)
 		if typ.Kind() == reflect.Pointer {
 			typ = typ.Elem()
 		}
 		// TODO we should keep a registry of types whose attrs
 		// have been asserted in the database already.
-		claims, err := schemas.Analyze(typ)
+		claims, err := schemas.AnalyzeWith(db.analyzer.Registry(), typ)
 		if err != nil {
 			res.Error = err
 			return
@@ -73,29 +179,43 @@ func (db *localDatabase) Write(req Request) (res Response) {
 		// For the subsequent data claims, perhaps the internal db write
 		// should accommodate a separate "ddl" claims form and handle
 		// a total reversion if the data claims have an error?
-		ires := db.db.Write(types.Request{Claims: claims})
+		ires := write(types.Request{Claims: claims})
 		if ires.Error != nil {
 			res.Error = ires.Error
 			return
 		}
+		schema = append(schema, claims...)
 	}
-	ireq, ids, err := shredder.NewShredder(db.analyzer).Shred(shredder.Document{
-		Retractions: req.Retractions,
-		Assertions:  req.Assertions,
-	})
+	ireq, ids, err := db.shred(req, analyzer)
 	if err != nil {
 		res.Error = err
 		return
 	}
-	ires := db.db.Write(ireq)
+	if len(req.RelaxedSpecs) != 0 || len(req.RelaxedAttrs) != 0 {
+		// The relaxations are written with the request, so they commit or fail together.
+		ireq.Retractions = append(ireq.Retractions, relaxations(req)...)
+		ireq.Claims = append(ireq.Claims, relaxedClaims(req, schema)...)
+	}
+	ireq.Validators = db.internalValidators(analyzer)
+	ireq.Predicates = db.internalPredicates(analyzer)
+	ireq.ValuePredicates = db.internalValuePredicates()
+	ires := write(ireq)
 	if ires.Error != nil {
-		res.Error = ires.Error
+		res.Error = writeError(ires.Error)
 		return
 	}
 	res.Snap = &Snapshot{
 		snap:     ires.Snapshot,
-		analyzer: db.analyzer,
+		analyzer: analyzer,
+		db:       db,
+	}
+	res.Before = &Snapshot{
+		snap:     ires.Before,
+		analyzer: analyzer,
+		db:       db,
 	}
+	res.Asserted = publicDatums(ires.Snapshot, ires.Asserted)
+	res.Retracted = publicDatums(ires.Snapshot, ires.Retracted)
 	n := len(req.Assertions)
 	res.IDs = make([]uint64, 0, n)
 	for i := range n {
@@ -111,3 +231,169 @@ func (db *localDatabase) Write(req Request) (res Response) {
 	// TODO how to assign txn id to the transaction entity, if any?
 	return
 }
+
+// shred returns the internal request for the request, and the ids or tempids of its
+// assertions in the same order. The snapshots given to called functions have the analyzer.
+func (db *localDatabase) shred(req Request, analyzer models.Analyzer) (ireq types.Request, ids []types.ERef, err error) {
+	patches := make([]shredder.Patch, len(req.Patches))
+	for i, patch := range req.Patches {
+		patches[i] = shredder.Patch(patch)
+	}
+	ireq, ids, err = shredder.NewShredder(db.analyzer).Shred(shredder.Document{
+		Retractions: req.Retractions,
+		Assertions:  req.Assertions,
+		Patches:     patches,
+	})
+	if err != nil {
+		return
+	}
+	for _, rename := range req.Renames {
+		ireq.Claims = append(ireq.Claims, types.Claim{E: types.Ident(rename.Attr), A: sys.DbIdent, V: types.String(rename.Ident)})
+	}
+	for _, alias := range req.RetiredAliases {
+		ireq.Claims = append(ireq.Claims, types.Claim{E: types.Ident(alias), A: sys.DbAlias, V: types.String(alias), Retract: true})
+	}
+	for _, migration := range req.Migrations {
+		attr := types.Ident(migration.Attr)
+		if migration.Cardinality != "" {
+			ireq.Claims = append(ireq.Claims, types.Claim{E: attr, A: sys.AttrCardinality, V: types.Ident(migration.Cardinality)})
+		}
+		if migration.Unique != "" {
+			ireq.Claims = append(ireq.Claims, types.Claim{E: attr, A: sys.AttrUnique, V: types.Ident(migration.Unique)})
+		}
+		if migration.NotUnique {
+			// Only the attribute's uniqueness is retracted.
+			for _, unique := range []types.ID{sys.AttrUniqueIdentity, sys.AttrUniqueValue} {
+				ireq.Claims = append(ireq.Claims, types.Claim{E: attr, A: sys.AttrUnique, V: unique, Retract: true})
+			}
+		}
+	}
+	ireq.Computations, err = db.computations(req, analyzer)
+	return
+}
+
+// relaxations returns the retractions of the datums of the request's relaxed specs and
+// attributes that the structs that name them declare.
+func relaxations(req Request) (retractions []types.Retraction) {
+	for _, spec := range req.RelaxedSpecs {
+		retractions = append(retractions, types.Retraction{
+			Constraints: map[types.IDRef]types.Void{types.Ident(spec): {}},
+			Attrs:       map[types.IDRef]types.Void{sys.SpecAttrs: {}, sys.SpecPreds: {}},
+		})
+	}
+	constraints := map[types.IDRef]types.Void{}
+	for id := range sys.Attrs {
+		if sys.ConstraintAttr(id) {
+			constraints[id] = types.Void{}
+		}
+	}
+	for _, attr := range req.RelaxedAttrs {
+		retractions = append(retractions, types.Retraction{
+			Constraints: map[types.IDRef]types.Void{types.Ident(attr): {}},
+			Attrs:       constraints,
+		})
+	}
+	return
+}
+
+// relaxedClaims returns the claims of the schema that declare the required attributes and
+// predicates of the request's relaxed specs and the constraints of its relaxed attributes,
+// by their idents, which are asserted anew once the relaxations retract them.
+func relaxedClaims(req Request, schema []types.Claim) (claims []types.Claim) {
+	relaxed := map[types.Ident]types.Void{}
+	for _, ident := range slices.Concat(req.RelaxedSpecs, req.RelaxedAttrs) {
+		relaxed[types.Ident(ident)] = types.Void{}
+	}
+	idents := map[types.TempID]types.Ident{}
+	for _, claim := range schema {
+		if claim.A == sys.DbIdent {
+			idents[claim.E.(types.TempID)] = types.Ident(claim.V.(types.String))
+		}
+	}
+	for _, claim := range schema {
+		a, ok := claim.A.(types.ID)
+		if !ok || (a != sys.SpecAttrs && a != sys.SpecPreds && !sys.ConstraintAttr(a)) {
+			continue
+		}
+		e := idents[claim.E.(types.TempID)]
+		if _, ok := relaxed[e]; !ok {
+			continue
+		}
+		if v, ok := claim.V.(types.TempID); ok {
+			claim.V = idents[v]
+		}
+		claims = append(claims, types.Claim{E: e, A: a, V: claim.V})
+	}
+	return
+}
+
+// publicDatums returns the public forms of the datums of the snapshot.
+func publicDatums(snap types.Snapshot, datums []types.Datum) (public []Datum) {
+	public = make([]Datum, len(datums))
+	for i, datum := range datums {
+		public[i] = Datum{E: uint64(datum.E), A: string(snap.ResolveAttrIdent(datum.A)), V: publicValue(datum.V), T: uint64(datum.T)}
+	}
+	return
+}
+
+// publicValue returns the public form of a system value, which is a uint64 for ids.
+func publicValue(value types.Value) any {
+	if id, ok := value.(types.ID); ok {
+		return uint64(id)
+	}
+	return models.FromValue(value)
+}
+
+// writeError returns the public form of an error from the internal database, which is
+// a ConflictError for failed version guards, a SpecError for nonconforming entities and a
+// ConstraintError for constrained values.
+func writeError(err error) error {
+	ierr, ok := err.(types.Error)
+	if !ok {
+		return err
+	}
+	switch ierr.Code {
+	case "database.write.conditionFailed":
+		conflict := ConflictError{Attr: string(ierr.Context["a"].(types.Ident))}
+		conflict.ID = uint64(ierr.Context["e"].(types.ID))
+		if v, ok := ierr.Context["expected"].(types.Int); ok {
+			conflict.Expected = int64(v)
+		}
+		if v, ok := ierr.Context["actual"].(types.Int); ok {
+			conflict.Actual = int64(v)
+		}
+		return conflict
+	case "database.write.missingRequiredAttr", "database.write.invalidPredicate", "database.write.predicateFailed":
+		spec := SpecError{
+			ID:   uint64(ierr.Context["e"].(types.ID)),
+			Spec: string(ierr.Context["spec"].(types.Ident)),
+		}
+		if attr, ok := ierr.Context["attr"].(types.Ident); ok {
+			spec.Attr = string(attr)
+		}
+		if pred, ok := ierr.Context["pred"].(string); ok {
+			spec.Pred = pred
+		}
+		if cause, ok := ierr.Context["error"].(error); ok {
+			spec.Err = cause
+		}
+		return spec
+	case "database.write.constraintViolated", "database.write.invalidValuePredicate", "database.write.valuePredicateFailed":
+		constraint := ConstraintError{
+			ID:    uint64(ierr.Context["e"].(types.ID)),
+			Attr:  string(ierr.Context["a"].(types.Ident)),
+			Value: publicValue(ierr.Context["v"].(types.Value)),
+		}
+		if ident, ok := ierr.Context["constraint"].(types.Ident); ok {
+			constraint.Constraint = string(ident)
+		}
+		if pred, ok := ierr.Context["pred"].(string); ok {
+			constraint.Pred = pred
+		}
+		if cause, ok := ierr.Context["error"].(error); ok {
+			constraint.Err = cause
+		}
+		return constraint
+	}
+	return err
+}
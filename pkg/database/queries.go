@@ -0,0 +1,317 @@
+package database
+
+import (
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dball/destructive/internal/structs/assemblers"
+	"github.com/dball/destructive/internal/types"
+)
+
+// Pattern selects the datums whose fields equal the pattern's nonzero fields.
+type Pattern struct {
+	// E, if nonzero, is the id of the entity.
+	E uint64
+	// A, if given, is the ident of the attribute.
+	A string
+	// V, if not nil, is the value, as for datums, though ints may be given for int64s.
+	V any
+}
+
+// QueryUpdate reports the changes a transaction made to the results of a query.
+type QueryUpdate[R any] struct {
+	// ID is the id of the transaction.
+	ID uint64
+	// Snap is the immutable set of data after the transaction.
+	Snap *Snapshot
+	// Added are the rows the transaction added to the results.
+	Added []R
+	// Removed are the rows the transaction removed from the results. A row that changed is
+	// removed as it was and added as it is.
+	Removed []R
+}
+
+// WatchOptions determine how the updates of a query are buffered.
+type WatchOptions struct {
+	// Buffer is the number of updates held for the subscriber, which is 64 by default.
+	Buffer int
+	// Policy determines what happens to updates when the buffer is full. Drop is treated
+	// as Disconnect, since the results of a query that missed a transaction would be wrong.
+	Policy SlowConsumerPolicy
+}
+
+// WatchDatums returns the datums matching the pattern and a channel of the updates of
+// those results made by the transactions subsequently committed to the database, in
+// commit order, and a function that cancels the query and closes the channel. The
+// updates are computed from the transactions' datums alone. Transactions that do not
+// change the results are not reported. The database must be one returned by NewDatabase.
+func WatchDatums(db Database, pattern Pattern, options WatchOptions) (results []Datum, updates <-chan QueryUpdate[Datum], cancel func(), err error) {
+	ldb, ok := db.(*localDatabase)
+	if !ok {
+		err = types.NewError("database.watch.invalidDatabase")
+		return
+	}
+	pattern, err = pattern.normalize()
+	if err != nil {
+		return
+	}
+	filter := options.filter()
+	if pattern.A != "" {
+		filter.Attrs = []string{pattern.A}
+	}
+	if pattern.E != 0 {
+		filter.IDs = []uint64{pattern.E}
+	}
+	reports, snapshot, unsubscribe := ldb.subscribe(filter)
+	results = pattern.selectDatums(snapshot)
+	// The results are keyed without their transaction ids, which differ between the
+	// assertion and retraction of a datum, and by their attribute ids, since the idents of
+	// their attributes may be renamed.
+	rows := make(map[datumKey]Datum, len(results))
+	for _, datum := range results {
+		rows[datum.key(snapshot)] = datum
+	}
+	updates, cancel = watch(reports, unsubscribe, options, func(report TxReport) (update QueryUpdate[Datum]) {
+		for _, datum := range report.Retracted {
+			key := datum.key(report.After)
+			row, ok := rows[key]
+			if ok && pattern.matches(report.After, datum) {
+				delete(rows, key)
+				update.Removed = append(update.Removed, row)
+			}
+		}
+		for _, datum := range report.Asserted {
+			if pattern.matches(report.After, datum) {
+				rows[datum.key(report.After)] = datum
+				update.Added = append(update.Added, datum)
+			}
+		}
+		return
+	})
+	return
+}
+
+// WatchStructs returns the entities with datums matching the pattern, built as Ts, and a
+// channel of the updates of those results made by the transactions subsequently committed
+// to the database, as WatchDatums does. Only the entities whose datums a transaction
+// changed are rebuilt, so changes only to the entities they reference are not reported.
+// Entities that cannot be built as Ts, e.g. whose values overflow their fields, are left
+// out of the results, as though they did not match the pattern.
+func WatchStructs[T any](db Database, pattern Pattern, options WatchOptions) (results []*T, updates <-chan QueryUpdate[*T], cancel func(), err error) {
+	ldb, ok := db.(*localDatabase)
+	if !ok {
+		err = types.NewError("database.watch.invalidDatabase")
+		return
+	}
+	_, err = ldb.analyzer.Analyze(reflect.TypeFor[T]())
+	if err != nil {
+		return
+	}
+	pattern, err = pattern.normalize()
+	if err != nil {
+		return
+	}
+	reports, snapshot, unsubscribe := ldb.subscribe(options.filter())
+	rows := map[uint64]*T{}
+	assemble := rowAssembler[T](snapshot)
+	for _, datum := range pattern.selectDatums(snapshot) {
+		if _, ok := rows[datum.E]; ok {
+			continue
+		}
+		entity := assemble(datum.E)
+		if entity == nil {
+			continue
+		}
+		rows[datum.E] = entity
+		results = append(results, entity)
+	}
+	updates, cancel = watch(reports, unsubscribe, options, func(report TxReport) (update QueryUpdate[*T]) {
+		// The rows that may have changed are those of the results and of the entities with
+		// matching datums whose datums the transaction changed.
+		var ids []uint64
+		for _, datum := range slices.Concat(report.Retracted, report.Asserted) {
+			if slices.Contains(ids, datum.E) {
+				continue
+			}
+			if _, ok := rows[datum.E]; ok || pattern.matches(report.After, datum) {
+				ids = append(ids, datum.E)
+			}
+		}
+		if len(ids) == 0 {
+			return
+		}
+		after := report.After
+		assemble := rowAssembler[T](after)
+		for _, id := range ids {
+			row, ok := rows[id]
+			var entity *T
+			if pattern.has(after, id) {
+				entity = assemble(id)
+			}
+			if entity == nil {
+				if ok {
+					delete(rows, id)
+					update.Removed = append(update.Removed, row)
+				}
+				continue
+			}
+			if ok {
+				if reflect.DeepEqual(row, entity) {
+					continue
+				}
+				update.Removed = append(update.Removed, row)
+			}
+			rows[id] = entity
+			update.Added = append(update.Added, entity)
+		}
+		return
+	})
+	return
+}
+
+// rowAssembler returns a function that builds the entities with the given ids as Ts from
+// the snapshot, returning nil for entities that cannot be built. An assembler that fails
+// is replaced, since it may hold the partial entities of the failure.
+func rowAssembler[T any](snapshot *Snapshot) func(id uint64) *T {
+	assembler := assemblers.NewAssembler(snapshot.analyzer, snapshot.snap)
+	return func(id uint64) *T {
+		entity, err := assemblers.Assemble[T](assembler, types.ID(id))
+		if err != nil {
+			assembler = assemblers.NewAssembler(snapshot.analyzer, snapshot.snap)
+			return nil
+		}
+		return entity
+	}
+}
+
+// watch applies the reports to the state of a query in a goroutine, sending the updates
+// that change its results until the subscription ends or the query is canceled.
+func watch[R any](reports <-chan TxReport, unsubscribe func(), options WatchOptions, apply func(report TxReport) QueryUpdate[R]) (updates <-chan QueryUpdate[R], cancel func()) {
+	size := options.Buffer
+	if size <= 0 {
+		size = 64
+	}
+	ch := make(chan QueryUpdate[R], size)
+	done := make(chan types.Void)
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			close(done)
+			unsubscribe()
+		})
+	}
+	go func() {
+		defer close(ch)
+		for report := range reports {
+			update := apply(report)
+			if len(update.Added) == 0 && len(update.Removed) == 0 {
+				continue
+			}
+			update.ID = report.ID
+			update.Snap = report.After
+			select {
+			case ch <- update:
+			case <-done:
+				return
+			}
+		}
+	}()
+	updates = ch
+	return
+}
+
+func (options WatchOptions) filter() (filter Filter) {
+	filter.Buffer = options.Buffer
+	filter.Policy = options.Policy
+	if filter.Policy == Drop {
+		filter.Policy = Disconnect
+	}
+	return
+}
+
+// normalize returns the pattern with its value in the form of the values of datums.
+func (pattern Pattern) normalize() (normal Pattern, err error) {
+	normal = pattern
+	if pattern.V == nil {
+		return
+	}
+	value, ok := toValue(pattern.V)
+	if !ok {
+		err = types.NewError("database.watch.invalidValue", "v", pattern.V)
+		return
+	}
+	normal.V = publicValue(value)
+	return
+}
+
+// matches reports whether the datum matches the normalized pattern, whose attribute may
+// be an alias of the datum's in the snapshot.
+func (pattern Pattern) matches(snapshot *Snapshot, datum Datum) bool {
+	return (pattern.E == 0 || pattern.E == datum.E) &&
+		(pattern.A == "" || sameAttr(snapshot.snap, pattern.A, datum.A)) &&
+		(pattern.V == nil || valueKey(pattern.V) == valueKey(datum.V))
+}
+
+// claim returns the claim that selects the datums matching the normalized pattern in the
+// snapshot, or false if none can match.
+func (pattern Pattern) claim(snapshot *Snapshot) (claim types.Claim, ok bool) {
+	if pattern.E != 0 {
+		claim.E = types.ID(pattern.E)
+	}
+	if pattern.A != "" {
+		a := snapshot.snap.ResolveIdent(types.Ident(pattern.A))
+		if a == 0 {
+			return
+		}
+		claim.A = a
+	}
+	if pattern.V != nil {
+		value, _ := toValue(pattern.V)
+		claim.V = value.(types.VRef)
+	}
+	ok = true
+	return
+}
+
+// selectDatums returns the datums in the snapshot matching the normalized pattern.
+func (pattern Pattern) selectDatums(snapshot *Snapshot) (datums []Datum) {
+	claim, ok := pattern.claim(snapshot)
+	if !ok {
+		return
+	}
+	return publicDatums(snapshot.snap, slices.Collect(snapshot.snap.Select(claim)))
+}
+
+// has reports whether the entity has a datum in the snapshot matching the normalized
+// pattern.
+func (pattern Pattern) has(snapshot *Snapshot, id uint64) bool {
+	pattern.E = id
+	claim, ok := pattern.claim(snapshot)
+	return ok && snapshot.snap.Has(claim)
+}
+
+// datumKey identifies a datum regardless of its transaction and the ident of its
+// attribute.
+type datumKey struct {
+	e uint64
+	a types.ID
+	v any
+}
+
+// key returns the key of the datum, whose attribute ident resolves in the snapshot.
+func (datum Datum) key(snapshot *Snapshot) datumKey {
+	return datumKey{e: datum.E, a: snapshot.snap.ResolveIdent(types.Ident(datum.A)), v: valueKey(datum.V)}
+}
+
+// instant is the comparable form of a time value.
+type instant int64
+
+// valueKey returns a comparable form of the public value.
+func valueKey(v any) any {
+	if t, ok := v.(time.Time); ok {
+		return instant(t.UnixNano())
+	}
+	return v
+}
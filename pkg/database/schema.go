@@ -0,0 +1,138 @@
+package database
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/dball/destructive/internal/structs/schemas"
+	"github.com/dball/destructive/internal/sys"
+	"github.com/dball/destructive/internal/types"
+)
+
+// cardinalityOne is the ident of the cardinality of attributes that declare none.
+const cardinalityOne = "sys/attr/cardinality/one"
+
+// Attribute is the schema of an attribute, whose properties are given by the idents of
+// their values, e.g. sys/attr/type/string.
+type Attribute struct {
+	// ID is the id of the attribute, which is zero for undeclared attributes.
+	ID uint64
+	// Ident is the ident of the attribute.
+	Ident string
+	// Aliases are the other idents of the attribute, in order.
+	Aliases []string
+	// Type is the type of the attribute's values.
+	Type string
+	// Cardinality is the cardinality of the attribute, which is sys/attr/cardinality/one
+	// unless it is sys/attr/cardinality/many.
+	Cardinality string
+	// Unique is the uniqueness of the attribute's values, if any.
+	Unique string
+	// RefType is the reference type of a ref attribute, if any.
+	RefType string
+}
+
+// Schema returns the user attributes of the snapshot, ordered by their idents.
+func (snapshot *Snapshot) Schema() (attrs []Attribute) {
+	snap := snapshot.snap
+	for datum := range snap.Select(types.Claim{A: sys.AttrType}) {
+		if datum.E < sys.FirstUserID {
+			continue
+		}
+		attrs = append(attrs, attribute(snap, datum.E))
+	}
+	slices.SortFunc(attrs, func(a, b Attribute) int { return strings.Compare(a.Ident, b.Ident) })
+	return
+}
+
+// attribute returns the schema of the attribute with the id in the snapshot.
+func attribute(snap types.Snapshot, id types.ID) (attr Attribute) {
+	attr.ID = uint64(id)
+	attr.Ident = string(snap.ResolveAttrIdent(id))
+	attr.Cardinality = cardinalityOne
+	for datum := range snap.Select(types.Claim{E: id}) {
+		switch datum.A {
+		case sys.DbAlias:
+			attr.Aliases = append(attr.Aliases, string(datum.V.(types.String)))
+		case sys.AttrType:
+			attr.Type = identOf(snap, datum.V.(types.ID))
+		case sys.AttrCardinality:
+			attr.Cardinality = identOf(snap, datum.V.(types.ID))
+		case sys.AttrUnique:
+			attr.Unique = identOf(snap, datum.V.(types.ID))
+		case sys.AttrRefType:
+			attr.RefType = identOf(snap, datum.V.(types.ID))
+		}
+	}
+	return
+}
+
+// identOf returns the ident of the entity with the id in the snapshot, if any.
+func identOf(snap types.Snapshot, id types.ID) (ident string) {
+	for datum := range snap.Select(types.Claim{E: id, A: sys.DbIdent}) {
+		ident = string(datum.V.(types.String))
+	}
+	return
+}
+
+// SchemaDiff is a difference between an attribute declared by a struct type and the
+// attribute stored in a snapshot.
+type SchemaDiff struct {
+	// Declared is the attribute as the struct type declares it.
+	Declared Attribute
+	// Stored is the stored attribute, which is zero if Missing.
+	Stored Attribute
+	// Missing indicates that the snapshot has no attribute with the declared ident.
+	Missing bool
+	// Conflicts are the properties in which the attributes differ, of type, cardinality and
+	// unique, in that order. Writing the struct type would fail for any of them, apart from
+	// a scalar field of a cardinality many attribute, which is read as one of its values,
+	// unless the stored attribute is first migrated to agree.
+	Conflicts []string
+}
+
+// DiffSchema returns the differences between the attributes T and the struct types it
+// references declare and those stored in the snapshot, in the order they are declared.
+// Attributes may be declared by their aliases. Uniqueness conflicts are reported only for
+// attributes T declares to be unique.
+func DiffSchema[T any](snapshot *Snapshot) (diffs []SchemaDiff, err error) {
+	claims, err := schemas.AnalyzeWith(snapshot.analyzer.Registry(), reflect.TypeFor[T]())
+	if err != nil {
+		return
+	}
+	snap := snapshot.snap
+	for _, declared := range schemas.Attrs(claims) {
+		diff := SchemaDiff{Declared: Attribute{
+			Ident:       string(declared.Ident),
+			Type:        identOf(snap, declared.Type),
+			Cardinality: cardinalityOne,
+		}}
+		if declared.Cardinality != 0 {
+			diff.Declared.Cardinality = identOf(snap, declared.Cardinality)
+		}
+		if declared.Unique != 0 {
+			diff.Declared.Unique = identOf(snap, declared.Unique)
+		}
+		id := snap.ResolveIdent(declared.Ident)
+		if id == 0 || !snap.Has(types.Claim{E: id, A: sys.AttrType}) {
+			diff.Missing = true
+			diffs = append(diffs, diff)
+			continue
+		}
+		diff.Stored = attribute(snap, id)
+		if diff.Declared.Type != diff.Stored.Type {
+			diff.Conflicts = append(diff.Conflicts, "type")
+		}
+		if diff.Declared.Cardinality != diff.Stored.Cardinality {
+			diff.Conflicts = append(diff.Conflicts, "cardinality")
+		}
+		if diff.Declared.Unique != "" && diff.Declared.Unique != diff.Stored.Unique {
+			diff.Conflicts = append(diff.Conflicts, "unique")
+		}
+		if len(diff.Conflicts) != 0 {
+			diffs = append(diffs, diff)
+		}
+	}
+	return
+}